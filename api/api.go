@@ -1,6 +1,12 @@
 package api
 
-import "time"
+import (
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/troubleshootd/troubleshoot"
+)
 
 // StateResponse is the response for the GET /state endpoint.
 type StateResponse struct {
@@ -9,3 +15,44 @@ type StateResponse struct {
 	OS        string    `json:"os"`
 	BuildTime time.Time `json:"buildTime"`
 }
+
+// TroubleshootAnnouncedRequest is the request body for the
+// POST /troubleshoot/announced endpoint.
+type TroubleshootAnnouncedRequest struct {
+	PublicKey types.PublicKey  `json:"publicKey"`
+	Protocols []chain.Protocol `json:"protocols,omitempty"`
+	// Quick only checks that the host is reachable, without scanning its
+	// settings
+	Quick bool `json:"quick,omitempty"`
+	// Force tests the host again even if a recent result is cached
+	Force bool `json:"force,omitempty"`
+	// ContractDuration and DataSize describe the contract the renter
+	// intends to form, see [troubleshoot.Host]
+	ContractDuration uint64 `json:"contractDuration,omitempty"`
+	DataSize         uint64 `json:"dataSize,omitempty"`
+	// SkipVersionCheck does not compare the host's version against the
+	// latest release
+	SkipVersionCheck bool `json:"skipVersionCheck,omitempty"`
+}
+
+// DNSRequest is the request body for the POST /troubleshoot/dns endpoint.
+type DNSRequest struct {
+	Hostname string `json:"hostname"`
+	// Resolver is the DNS server to query, see [troubleshoot.Manager.LookupDNS]
+	Resolver string `json:"resolver,omitempty"`
+	// RecordType is "A" or "AAAA" to only resolve one address family
+	RecordType string `json:"recordType,omitempty"`
+}
+
+// CompareRequest is the request body for the POST /compare endpoint.
+type CompareRequest struct {
+	A troubleshoot.Host `json:"a"`
+	B troubleshoot.Host `json:"b"`
+}
+
+// CompareResponse is the response for the POST /compare endpoint.
+type CompareResponse struct {
+	A           troubleshoot.Result       `json:"a"`
+	B           troubleshoot.Result       `json:"b"`
+	Differences []troubleshoot.Difference `json:"differences"`
+}
@@ -0,0 +1,578 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+	"go.sia.tech/troubleshootd/troubleshoot"
+)
+
+type mockTroubleshooter struct {
+	healthErr error
+	testErr   error
+	delay     time.Duration
+	// versions are reported as the version of each tested host
+	versions map[types.PublicKey]string
+	// cooldowns are the remaining cooldowns of each host
+	cooldowns map[types.PublicKey]time.Duration
+	// tested receives the public key of each tested host, if set
+	tested chan types.PublicKey
+}
+
+func (mt mockTroubleshooter) Cooldown(host troubleshoot.Host) time.Duration {
+	return mt.cooldowns[host.PublicKey]
+}
+
+func (mt mockTroubleshooter) Health() error {
+	return mt.healthErr
+}
+
+func (mockTroubleshooter) ConsensusStatus() troubleshoot.ConsensusStatus {
+	return troubleshoot.ConsensusStatus{
+		Network:         "mainnet",
+		Tip:             types.ChainIndex{Height: 600000},
+		V2AllowHeight:   526000,
+		V2RequireHeight: 530000,
+		V2Allowed:       true,
+		V2Required:      true,
+	}
+}
+
+func (mockTroubleshooter) ReleaseStatus() troubleshoot.ReleaseStatus {
+	return troubleshoot.ReleaseStatus{
+		Version:    "v2.1.0",
+		LastUpdate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+}
+
+func (mt mockTroubleshooter) TestHost(_ context.Context, host troubleshoot.Host) (troubleshoot.Result, error) {
+	time.Sleep(mt.delay)
+	if mt.testErr != nil {
+		return troubleshoot.Result{}, mt.testErr
+	} else if n := mt.cooldowns[host.PublicKey]; n > 0 {
+		return troubleshoot.Result{}, &troubleshoot.CooldownError{Remaining: n}
+	} else if mt.tested != nil {
+		mt.tested <- host.PublicKey
+	}
+	result := troubleshoot.Result{PublicKey: host.PublicKey, AddressFamily: host.AddressFamily, DryRun: host.DryRun, Quick: host.Quick, Version: mt.versions[host.PublicKey]}
+	for _, addr := range host.RHP4NetAddresses {
+		result.RHP4 = append(result.RHP4, troubleshoot.RHP4Result{NetAddress: addr})
+	}
+	return result, nil
+}
+
+func (mt mockTroubleshooter) FetchSettings(_ context.Context, host troubleshoot.Host) (troubleshoot.HostSettings, error) {
+	if mt.testErr != nil {
+		return troubleshoot.HostSettings{}, mt.testErr
+	} else if len(host.RHP4NetAddresses) == 0 {
+		return troubleshoot.HostSettings{}, errors.New("host has no addresses to fetch settings from")
+	}
+	return troubleshoot.HostSettings{
+		NetAddress: host.RHP4NetAddresses[0],
+		Settings:   proto4.HostSettings{Release: mt.versions[host.PublicKey]},
+	}, nil
+}
+
+func (mockTroubleshooter) AnnouncedHost(hostKey types.PublicKey) (troubleshoot.Host, error) {
+	return troubleshoot.Host{
+		PublicKey:        hostKey,
+		RHP4NetAddresses: []chain.NetAddress{{Protocol: siamux.Protocol, Address: "announced.sia.tech:9984"}},
+	}, nil
+}
+
+func (mt mockTroubleshooter) RecentResults(hostKey types.PublicKey) []troubleshoot.Result {
+	if _, ok := mt.versions[hostKey]; !ok {
+		return nil
+	}
+	return []troubleshoot.Result{{PublicKey: hostKey, Version: mt.versions[hostKey]}}
+}
+
+func (mockTroubleshooter) LookupSubnet(_ context.Context, hostname, subnet string) (troubleshoot.SubnetLookup, error) {
+	if subnet == "invalid" {
+		return troubleshoot.SubnetLookup{}, errors.New("invalid subnet")
+	}
+	return troubleshoot.SubnetLookup{Hostname: hostname, Subnet: subnet, Addresses: []string{"203.0.113.10"}, Scope: 24}, nil
+}
+
+func (mockTroubleshooter) LookupDNS(_ context.Context, hostname, resolver, recordType string) (troubleshoot.DNSLookup, error) {
+	if recordType != "" && recordType != "A" {
+		return troubleshoot.DNSLookup{}, errors.New("unsupported record type")
+	}
+	return troubleshoot.DNSLookup{Hostname: hostname, Resolver: resolver, RecordType: recordType, Addresses: []string{"203.0.113.10"}}, nil
+}
+
+func newTestServer(t *testing.T, ts Troubleshooter, opts ...ServerOption) string {
+	t.Helper()
+
+	srv := httptest.NewServer(NewHandler(ts, opts...))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestBasicAuth(t *testing.T) {
+	const password = "foo"
+	addr := newTestServer(t, mockTroubleshooter{}, WithBasicAuth(password))
+	host := troubleshoot.Host{PublicKey: types.GeneratePrivateKey().PublicKey()}
+
+	// the state endpoint should remain public
+	if _, err := NewClient(addr, "").State(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewClient(addr, "").TestConnection(context.Background(), host); err == nil {
+		t.Fatal("expected unauthenticated request to fail")
+	} else if _, err := NewClient(addr, "bar").TestConnection(context.Background(), host); err == nil {
+		t.Fatal("expected request with wrong password to fail")
+	}
+
+	result, err := NewClient(addr, password).TestConnection(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	} else if result.PublicKey != host.PublicKey {
+		t.Fatalf("expected public key %v, got %v", host.PublicKey, result.PublicKey)
+	}
+}
+
+func TestOpenAPI(t *testing.T) {
+	addr := newTestServer(t, mockTroubleshooter{}, WithBasicAuth("foo"))
+
+	resp, err := http.Get(addr + "/openapi.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, resp.StatusCode)
+	} else if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("expected content type application/json, got %q", ct)
+	}
+
+	var doc struct {
+		OpenAPI string                     `json:"openapi"`
+		Paths   map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		t.Fatal(err)
+	} else if doc.OpenAPI == "" {
+		t.Fatal("expected openapi version")
+	}
+	for _, path := range []string{"/state", "/troubleshoot", "/troubleshoot/announced", "/troubleshoot/dns"} {
+		if _, ok := doc.Paths[path]; !ok {
+			t.Fatalf("expected path %q to be documented", path)
+		}
+	}
+}
+
+func TestHealthz(t *testing.T) {
+	healthy := newTestServer(t, mockTroubleshooter{})
+	if err := NewClient(healthy, "").Health(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	unhealthy := newTestServer(t, mockTroubleshooter{healthErr: errors.New("explorer is unreachable")})
+	resp, err := http.Get(unhealthy + "/healthz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected status %d, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	} else if err := NewClient(unhealthy, "").Health(context.Background()); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestConsensus(t *testing.T) {
+	// the consensus endpoint should remain public
+	addr := newTestServer(t, mockTroubleshooter{}, WithBasicAuth("foo"))
+	status, err := NewClient(addr, "").Consensus(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if status.Network != "mainnet" || status.Tip.Height != 600000 {
+		t.Fatalf("unexpected status %+v", status)
+	} else if !status.V2Allowed || !status.V2Required || status.V2AllowHeight != 526000 {
+		t.Fatalf("unexpected hardfork status %+v", status)
+	}
+}
+
+func TestLatestRelease(t *testing.T) {
+	// the latest release should remain public
+	addr := newTestServer(t, mockTroubleshooter{}, WithBasicAuth("foo"))
+	status, err := NewClient(addr, "").LatestRelease(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	} else if status.Version != "v2.1.0" {
+		t.Fatalf("expected version %q, got %q", "v2.1.0", status.Version)
+	} else if !status.LastUpdate.Equal(time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("unexpected last update %v", status.LastUpdate)
+	}
+}
+
+func TestGracefulShutdown(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{
+		Handler: NewHandler(mockTroubleshooter{delay: 500 * time.Millisecond}),
+	}
+	go srv.Serve(l)
+
+	host := troubleshoot.Host{PublicKey: types.GeneratePrivateKey().PublicKey()}
+	type testResult struct {
+		result troubleshoot.Result
+		err    error
+	}
+	done := make(chan testResult, 1)
+	go func() {
+		result, err := NewClient("http://"+l.Addr().String(), "").TestConnection(context.Background(), host)
+		done <- testResult{result, err}
+	}()
+
+	// wait for the request to be in flight before shutting down
+	time.Sleep(100 * time.Millisecond)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("expected in-flight request to complete, got %v", res.err)
+	} else if res.result.PublicKey != host.PublicKey {
+		t.Fatalf("expected public key %v, got %v", host.PublicKey, res.result.PublicKey)
+	}
+}
+
+func TestGETTroubleshoot(t *testing.T) {
+	addr := newTestServer(t, mockTroubleshooter{})
+	hostKey := types.GeneratePrivateKey().PublicKey()
+
+	get := func(query url.Values) (troubleshoot.Result, int) {
+		t.Helper()
+		resp, err := http.Get(addr + "/troubleshoot?" + query.Encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var result troubleshoot.Result
+		if resp.StatusCode == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return result, resp.StatusCode
+	}
+
+	if _, status := get(url.Values{}); status != http.StatusBadRequest {
+		t.Fatalf("expected status %d without a public key, got %d", http.StatusBadRequest, status)
+	} else if _, status := get(url.Values{"publicKey": {"foo"}}); status != http.StatusBadRequest {
+		t.Fatalf("expected status %d with an invalid public key, got %d", http.StatusBadRequest, status)
+	}
+
+	// without addresses, the announced addresses should be tested
+	result, status := get(url.Values{"publicKey": {hostKey.String()}})
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	} else if result.PublicKey != hostKey {
+		t.Fatalf("expected public key %v, got %v", hostKey, result.PublicKey)
+	} else if len(result.RHP4) != 1 || result.RHP4[0].NetAddress.Address != "announced.sia.tech:9984" {
+		t.Fatalf("expected announced address to be tested, got %+v", result.RHP4)
+	}
+
+	// given addresses take precedence over the announcement
+	result, status = get(url.Values{
+		"publicKey": {hostKey.String()},
+		"siamux":    {"host.sia.tech:9984"},
+		"quic":      {"host.sia.tech:9984"},
+	})
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	}
+	expected := []chain.NetAddress{
+		{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"},
+		{Protocol: quic.Protocol, Address: "host.sia.tech:9984"},
+	}
+	if len(result.RHP4) != len(expected) {
+		t.Fatalf("expected %d addresses, got %+v", len(expected), result.RHP4)
+	}
+	for i := range expected {
+		if result.RHP4[i].NetAddress != expected[i] {
+			t.Fatalf("expected address %v, got %v", expected[i], result.RHP4[i].NetAddress)
+		}
+	}
+
+	// the address family applies to the announced addresses too
+	result, status = get(url.Values{"publicKey": {hostKey.String()}, "addressFamily": {"ipv6"}})
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	} else if result.AddressFamily != troubleshoot.AddressFamilyIPv6 {
+		t.Fatalf("expected address family %q, got %q", troubleshoot.AddressFamilyIPv6, result.AddressFamily)
+	}
+
+	result, status = get(url.Values{"publicKey": {hostKey.String()}, "dryRun": {"true"}})
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	} else if !result.DryRun {
+		t.Fatal("expected a dry run")
+	} else if _, status := get(url.Values{"publicKey": {hostKey.String()}, "dryRun": {"maybe"}}); status != http.StatusBadRequest {
+		t.Fatalf("expected status %d with an invalid dry run flag, got %d", http.StatusBadRequest, status)
+	}
+
+	result, status = get(url.Values{"publicKey": {hostKey.String()}, "quick": {"true"}})
+	if status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	} else if !result.Quick {
+		t.Fatal("expected a quick test")
+	}
+
+	if _, status := get(url.Values{"publicKey": {hostKey.String()}, "force": {"true"}}); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	} else if _, status := get(url.Values{"publicKey": {hostKey.String()}, "force": {"maybe"}}); status != http.StatusBadRequest {
+		t.Fatalf("expected status %d with an invalid force flag, got %d", http.StatusBadRequest, status)
+	}
+
+	if _, status := get(url.Values{"publicKey": {hostKey.String()}, "skipVersionCheck": {"true"}}); status != http.StatusOK {
+		t.Fatalf("expected status %d, got %d", http.StatusOK, status)
+	} else if _, status := get(url.Values{"publicKey": {hostKey.String()}, "skipVersionCheck": {"maybe"}}); status != http.StatusBadRequest {
+		t.Fatalf("expected status %d with an invalid skip version check flag, got %d", http.StatusBadRequest, status)
+	}
+}
+
+func TestCooldownResponse(t *testing.T) {
+	addr := newTestServer(t, mockTroubleshooter{testErr: &troubleshoot.CooldownError{Remaining: 1500 * time.Millisecond}})
+
+	resp, err := http.Get(addr + "/troubleshoot?publicKey=" + types.GeneratePrivateKey().PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	} else if v := resp.Header.Get("Retry-After"); v != "2" {
+		t.Fatalf("expected Retry-After 2, got %q", v)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	const limit = 3
+	addr := newTestServer(t, mockTroubleshooter{}, WithRateLimit(limit, time.Minute))
+	client := NewClient(addr, "")
+
+	for i := 0; i < limit; i++ {
+		host := troubleshoot.Host{PublicKey: types.GeneratePrivateKey().PublicKey()}
+		if _, err := client.TestConnection(context.Background(), host); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	resp, err := http.Get(addr + "/troubleshoot?publicKey=" + types.GeneratePrivateKey().PublicKey().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status %d, got %d", http.StatusTooManyRequests, resp.StatusCode)
+	} else if v := resp.Header.Get("Retry-After"); v != "20" {
+		t.Fatalf("expected Retry-After 20, got %q", v)
+	}
+
+	// the public endpoints should not be limited
+	if _, err := client.State(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIPRateLimiterRefill(t *testing.T) {
+	rl := newIPRateLimiter(2, time.Second)
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.allow("1.2.3.4", now); !ok {
+			t.Fatal("expected request to be allowed")
+		}
+	}
+	if ok, wait := rl.allow("1.2.3.4", now); ok {
+		t.Fatal("expected request to be rejected")
+	} else if wait != 500*time.Millisecond {
+		t.Fatalf("expected wait of 500ms, got %s", wait)
+	}
+
+	// other clients have their own bucket
+	if ok, _ := rl.allow("5.6.7.8", now); !ok {
+		t.Fatal("expected request from another client to be allowed")
+	}
+
+	// the bucket should refill over time
+	if ok, _ := rl.allow("1.2.3.4", now.Add(500*time.Millisecond)); !ok {
+		t.Fatal("expected request to be allowed after refill")
+	}
+
+	// full buckets are removed
+	rl.allow("1.2.3.4", now.Add(2*time.Minute))
+	if len(rl.buckets) != 1 {
+		t.Fatalf("expected 1 bucket after sweep, got %d", len(rl.buckets))
+	}
+}
+
+func TestLookupSubnet(t *testing.T) {
+	client := NewClient(newTestServer(t, mockTroubleshooter{}), "")
+
+	lookup, err := client.LookupSubnet(context.Background(), "host.sia.tech", "198.51.100.0/24")
+	if err != nil {
+		t.Fatal(err)
+	} else if lookup.Hostname != "host.sia.tech" || lookup.Subnet != "198.51.100.0/24" {
+		t.Fatalf("unexpected lookup %+v", lookup)
+	} else if lookup.Scope != 24 || len(lookup.Addresses) != 1 {
+		t.Fatalf("unexpected lookup %+v", lookup)
+	}
+
+	if _, err := client.LookupSubnet(context.Background(), "host.sia.tech", ""); err == nil {
+		t.Fatal("expected missing subnet to be rejected")
+	} else if _, err := client.LookupSubnet(context.Background(), "host.sia.tech", "invalid"); err == nil {
+		t.Fatal("expected invalid subnet to be rejected")
+	}
+}
+
+func TestLookupDNS(t *testing.T) {
+	client := NewClient(newTestServer(t, mockTroubleshooter{}), "")
+
+	lookup, err := client.LookupDNS(context.Background(), "host.sia.tech", "1.1.1.1:53", "A")
+	if err != nil {
+		t.Fatal(err)
+	} else if lookup.Hostname != "host.sia.tech" || lookup.Resolver != "1.1.1.1:53" || lookup.RecordType != "A" {
+		t.Fatalf("unexpected lookup %+v", lookup)
+	} else if len(lookup.Addresses) != 1 {
+		t.Fatalf("unexpected lookup %+v", lookup)
+	}
+
+	if _, err := client.LookupDNS(context.Background(), "", "", ""); err == nil {
+		t.Fatal("expected missing hostname to be rejected")
+	} else if _, err := client.LookupDNS(context.Background(), "host.sia.tech", "", "MX"); err == nil {
+		t.Fatal("expected unsupported record type to be rejected")
+	}
+}
+
+func TestCompare(t *testing.T) {
+	a, b := types.GeneratePrivateKey().PublicKey(), types.GeneratePrivateKey().PublicKey()
+	client := NewClient(newTestServer(t, mockTroubleshooter{versions: map[types.PublicKey]string{a: "2.0.0", b: "2.1.0"}}), "")
+
+	resp, err := client.Compare(context.Background(), troubleshoot.Host{PublicKey: a}, troubleshoot.Host{PublicKey: b})
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.A.PublicKey != a || resp.B.PublicKey != b {
+		t.Fatalf("expected results for both hosts, got %v and %v", resp.A.PublicKey, resp.B.PublicKey)
+	} else if len(resp.Differences) != 1 || resp.Differences[0] != (troubleshoot.Difference{Field: "version", A: "2.0.0", B: "2.1.0"}) {
+		t.Fatalf("expected a version difference, got %+v", resp.Differences)
+	}
+
+	if _, err := client.Compare(context.Background(), troubleshoot.Host{PublicKey: a}, troubleshoot.Host{PublicKey: a}); err == nil {
+		t.Fatal("expected comparing a host with itself to fail")
+	}
+
+	// a cooldown on either host rejects the comparison
+	client = NewClient(newTestServer(t, mockTroubleshooter{testErr: &troubleshoot.CooldownError{Remaining: time.Second}}), "")
+	if _, err := client.Compare(context.Background(), troubleshoot.Host{PublicKey: a}, troubleshoot.Host{PublicKey: b}); err == nil {
+		t.Fatal("expected cooldown to reject the comparison")
+	}
+
+	// neither host is tested if the other is on cooldown
+	for _, cooldown := range []types.PublicKey{a, b} {
+		tested := make(chan types.PublicKey, 2)
+		client = NewClient(newTestServer(t, mockTroubleshooter{cooldowns: map[types.PublicKey]time.Duration{cooldown: time.Minute}, tested: tested}), "")
+		_, err := client.Compare(context.Background(), troubleshoot.Host{PublicKey: a}, troubleshoot.Host{PublicKey: b})
+		if err == nil || !strings.Contains(err.Error(), "host is on cooldown") {
+			t.Fatalf("expected cooldown to reject the comparison, got %v", err)
+		} else if len(tested) != 0 {
+			t.Fatalf("expected no host to be tested, got %v", <-tested)
+		}
+	}
+}
+
+func TestSettings(t *testing.T) {
+	hostKey := types.GeneratePrivateKey().PublicKey()
+	client := NewClient(newTestServer(t, mockTroubleshooter{versions: map[types.PublicKey]string{hostKey: "hostd v2.1.0"}}), "")
+
+	addr := chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}
+	settings, err := client.Settings(context.Background(), troubleshoot.Host{PublicKey: hostKey, RHP4NetAddresses: []chain.NetAddress{addr}})
+	if err != nil {
+		t.Fatal(err)
+	} else if settings.NetAddress != addr {
+		t.Fatalf("expected address %v, got %v", addr, settings.NetAddress)
+	} else if settings.Settings.Release != "hostd v2.1.0" {
+		t.Fatalf("expected release %q, got %q", "hostd v2.1.0", settings.Settings.Release)
+	}
+
+	if _, err := client.Settings(context.Background(), troubleshoot.Host{PublicKey: hostKey}); err == nil {
+		t.Fatal("expected host without addresses to fail")
+	}
+}
+
+func TestRecentResults(t *testing.T) {
+	hostKey := types.GeneratePrivateKey().PublicKey()
+	client := NewClient(newTestServer(t, mockTroubleshooter{versions: map[types.PublicKey]string{hostKey: "hostd v2.1.0"}}), "")
+
+	results, err := client.RecentResults(context.Background(), hostKey)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(results) != 1 || results[0].PublicKey != hostKey {
+		t.Fatalf("expected one result for %v, got %+v", hostKey, results)
+	}
+
+	// hosts without results should return an empty list
+	results, err = client.RecentResults(context.Background(), types.GeneratePrivateKey().PublicKey())
+	if err != nil {
+		t.Fatal(err)
+	} else if results == nil || len(results) != 0 {
+		t.Fatalf("expected an empty list, got %+v", results)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	addr := newTestServer(t, mockTroubleshooter{})
+	hostKey := types.GeneratePrivateKey().PublicKey()
+
+	ids := make(map[string]bool)
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(addr + "/troubleshoot?publicKey=" + hostKey.String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		id := resp.Header.Get(requestIDHeader)
+		if id == "" {
+			t.Fatal("expected request ID header")
+		} else if ids[id] {
+			t.Fatalf("expected unique request IDs, got %q twice", id)
+		}
+		ids[id] = true
+	}
+
+	// public endpoints are not assigned IDs
+	resp, err := http.Get(addr + "/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if id := resp.Header.Get(requestIDHeader); id != "" {
+		t.Fatalf("expected no request ID for a public endpoint, got %q", id)
+	}
+}
@@ -2,7 +2,11 @@ package api
 
 import (
 	"context"
+	"fmt"
+	"net/url"
 
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
 	"go.sia.tech/jape"
 	"go.sia.tech/troubleshootd/troubleshoot"
 )
@@ -12,17 +16,87 @@ type Client struct {
 	c jape.Client
 }
 
+// State returns the state of the API server.
+func (c *Client) State(ctx context.Context) (resp StateResponse, err error) {
+	err = c.c.GET(ctx, "/state", &resp)
+	return
+}
+
+// Consensus returns the network and consensus state the server compares
+// hosts against.
+func (c *Client) Consensus(ctx context.Context) (status troubleshoot.ConsensusStatus, err error) {
+	err = c.c.GET(ctx, "/consensus", &status)
+	return
+}
+
+// LatestRelease returns the latest hostd release the server compares
+// hosts against.
+func (c *Client) LatestRelease(ctx context.Context) (status troubleshoot.ReleaseStatus, err error) {
+	err = c.c.GET(ctx, "/version/latest", &status)
+	return
+}
+
+// Health returns an error if the API server is not healthy.
+func (c *Client) Health(ctx context.Context) error {
+	var resp string
+	return c.c.GET(ctx, "/healthz", &resp)
+}
+
 // TestConnection tests the host's connection to the API server.
 func (c *Client) TestConnection(ctx context.Context, host troubleshoot.Host) (result troubleshoot.Result, err error) {
 	err = c.c.POST(ctx, "/troubleshoot", host, &result)
 	return
 }
 
+// TestAnnouncedHost tests the host's announced addresses, as reported by the
+// explorer, against the API server. If protocols are given, only addresses
+// using those protocols are tested.
+func (c *Client) TestAnnouncedHost(ctx context.Context, hostKey types.PublicKey, protocols ...chain.Protocol) (result troubleshoot.Result, err error) {
+	err = c.c.POST(ctx, "/troubleshoot/announced", TroubleshootAnnouncedRequest{PublicKey: hostKey, Protocols: protocols}, &result)
+	return
+}
+
+// Compare tests both hosts and returns their results along with the
+// differences between them. The hosts must have different public keys.
+func (c *Client) Compare(ctx context.Context, a, b troubleshoot.Host) (resp CompareResponse, err error) {
+	err = c.c.POST(ctx, "/compare", CompareRequest{A: a, B: b}, &resp)
+	return
+}
+
+// Settings returns the host's settings from the first of its addresses that
+// responds, without validating them.
+func (c *Client) Settings(ctx context.Context, host troubleshoot.Host) (settings troubleshoot.HostSettings, err error) {
+	err = c.c.POST(ctx, "/settings", host, &settings)
+	return
+}
+
+// RecentResults returns the results of the host's most recent tests, from
+// newest to oldest.
+func (c *Client) RecentResults(ctx context.Context, hostKey types.PublicKey) (results []troubleshoot.Result, err error) {
+	err = c.c.GET(ctx, fmt.Sprintf("/hosts/%s/recent", hostKey), &results)
+	return
+}
+
+// LookupSubnet resolves hostname as if the query came from a client in
+// subnet.
+func (c *Client) LookupSubnet(ctx context.Context, hostname, subnet string) (lookup troubleshoot.SubnetLookup, err error) {
+	err = c.c.GET(ctx, fmt.Sprintf("/troubleshoot/dns?hostname=%s&subnet=%s", url.QueryEscape(hostname), url.QueryEscape(subnet)), &lookup)
+	return
+}
+
+// LookupDNS resolves hostname and runs the supplementary DNS checks on it.
+// The resolver and record type are optional.
+func (c *Client) LookupDNS(ctx context.Context, hostname, resolver, recordType string) (lookup troubleshoot.DNSLookup, err error) {
+	err = c.c.POST(ctx, "/troubleshoot/dns", DNSRequest{Hostname: hostname, Resolver: resolver, RecordType: recordType}, &lookup)
+	return
+}
+
 // NewClient creates a new client for the troubleshoot API.
-func NewClient(addr string) *Client {
+func NewClient(addr, password string) *Client {
 	return &Client{
 		c: jape.Client{
-			BaseURL: addr,
+			BaseURL:  addr,
+			Password: password,
 		},
 	}
 }
@@ -0,0 +1,27 @@
+package api
+
+import "time"
+
+// A ServerOption configures the API server.
+type ServerOption func(*server)
+
+// WithBasicAuth requires the given password for the troubleshoot
+// endpoints. The /state endpoint remains public.
+func WithBasicAuth(password string) ServerOption {
+	return func(s *server) {
+		s.password = password
+	}
+}
+
+// WithRateLimit limits each client IP to the given number of troubleshoot
+// requests per interval. Requests over the limit are rejected with 429 Too
+// Many Requests.
+func WithRateLimit(requests int, interval time.Duration) ServerOption {
+	return func(s *server) {
+		if requests <= 0 || interval <= 0 {
+			s.limiter = nil
+			return
+		}
+		s.limiter = newIPRateLimiter(requests, interval)
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.sia.tech/jape"
+)
+
+var errRateLimited = errors.New("too many requests, please try again later")
+
+type (
+	// A bucket is a token bucket for a single client.
+	bucket struct {
+		tokens     float64
+		lastRefill time.Time
+	}
+
+	// An ipRateLimiter limits the rate of requests from each client IP
+	// using a token bucket. Each client can make up to burst requests at
+	// once, and the bucket refills at rate tokens per second.
+	ipRateLimiter struct {
+		rate  float64
+		burst float64
+
+		mu        sync.Mutex
+		buckets   map[string]*bucket
+		lastSweep time.Time
+	}
+)
+
+// refill adds the tokens accumulated since the last refill.
+func (rl *ipRateLimiter) refill(b *bucket, now time.Time) {
+	b.tokens = math.Min(rl.burst, b.tokens+now.Sub(b.lastRefill).Seconds()*rl.rate)
+	b.lastRefill = now
+}
+
+// allow consumes a token for the client. If none are available, it returns
+// false and the time until the next token is available.
+func (rl *ipRateLimiter) allow(ip string, now time.Time) (bool, time.Duration) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	// remove full buckets so the map does not grow without bound
+	if now.Sub(rl.lastSweep) > time.Minute {
+		for k, b := range rl.buckets {
+			if rl.refill(b, now); b.tokens >= rl.burst {
+				delete(rl.buckets, k)
+			}
+		}
+		rl.lastSweep = now
+	}
+
+	b, ok := rl.buckets[ip]
+	if !ok {
+		b = &bucket{tokens: rl.burst, lastRefill: now}
+		rl.buckets[ip] = b
+	}
+	rl.refill(b, now)
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// limit wraps h, rejecting requests from clients that have exceeded the
+// rate limit.
+func (rl *ipRateLimiter) limit(h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		ip, _, err := net.SplitHostPort(jc.Request.RemoteAddr)
+		if err != nil {
+			ip = jc.Request.RemoteAddr
+		}
+		if ok, wait := rl.allow(ip, time.Now()); !ok {
+			writeRetryAfter(jc, wait)
+			jc.Error(errRateLimited, http.StatusTooManyRequests)
+			return
+		}
+		h(jc)
+	}
+}
+
+// writeRetryAfter sets the Retry-After header, rounded up to the nearest
+// second.
+func writeRetryAfter(jc jape.Context, d time.Duration) {
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	jc.ResponseWriter.Header().Set("Retry-After", strconv.Itoa(seconds))
+}
+
+func newIPRateLimiter(requests int, interval time.Duration) *ipRateLimiter {
+	return &ipRateLimiter{
+		rate:    float64(requests) / interval.Seconds(),
+		burst:   float64(requests),
+		buckets: make(map[string]*bucket),
+	}
+}
@@ -0,0 +1,30 @@
+package api
+
+import (
+	"encoding/hex"
+
+	"go.sia.tech/jape"
+	"go.sia.tech/troubleshootd/troubleshoot"
+	"lukechampine.com/frand"
+)
+
+// requestIDHeader is the response header containing the request's ID.
+const requestIDHeader = "X-Request-ID"
+
+// newRequestID returns a random ID for correlating a request with its
+// logs.
+func newRequestID() string {
+	return hex.EncodeToString(frand.Bytes(8))
+}
+
+// withRequestID assigns each request an ID, returns it in the
+// X-Request-ID header, and attaches it to the request's context so that
+// it is included in the test's logs and result.
+func withRequestID(h jape.Handler) jape.Handler {
+	return func(jc jape.Context) {
+		id := newRequestID()
+		jc.ResponseWriter.Header().Set(requestIDHeader, id)
+		jc.Request = jc.Request.WithContext(troubleshoot.WithRequestID(jc.Request.Context(), id))
+		h(jc)
+	}
+}
@@ -2,26 +2,58 @@ package api
 
 import (
 	"context"
+	_ "embed" // for the OpenAPI document
+	"errors"
 	"net/http"
 	"runtime"
+	"sync"
 	"time"
 
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
 	"go.sia.tech/jape"
 	"go.sia.tech/troubleshootd/build"
 	"go.sia.tech/troubleshootd/troubleshoot"
 )
 
+//go:embed openapi.json
+var openAPISpec []byte
+
 // A Troubleshooter is an interface that defines the methods for testing a host.
 type Troubleshooter interface {
 	TestHost(ctx context.Context, host troubleshoot.Host) (troubleshoot.Result, error)
+	FetchSettings(ctx context.Context, host troubleshoot.Host) (troubleshoot.HostSettings, error)
+	Cooldown(troubleshoot.Host) time.Duration
+	AnnouncedHost(types.PublicKey) (troubleshoot.Host, error)
+	RecentResults(types.PublicKey) []troubleshoot.Result
+	LookupSubnet(ctx context.Context, hostname, subnet string) (troubleshoot.SubnetLookup, error)
+	LookupDNS(ctx context.Context, hostname, resolver, recordType string) (troubleshoot.DNSLookup, error)
+	ConsensusStatus() troubleshoot.ConsensusStatus
+	ReleaseStatus() troubleshoot.ReleaseStatus
+	Health() error
 }
 
 type (
 	server struct {
-		t Troubleshooter
+		t        Troubleshooter
+		password string
+		limiter  *ipRateLimiter
 	}
 )
 
+// writeTestError writes an error returned by TestHost to the response.
+func writeTestError(jc jape.Context, err error) {
+	var ce *troubleshoot.CooldownError
+	if errors.As(err, &ce) {
+		writeRetryAfter(jc, ce.Remaining)
+		jc.Error(err, http.StatusTooManyRequests)
+		return
+	}
+	jc.Error(err, http.StatusInternalServerError)
+}
+
 func (s *server) handleGETState(jc jape.Context) {
 	jc.Encode(StateResponse{
 		Version:   build.Version(),
@@ -31,6 +63,77 @@ func (s *server) handleGETState(jc jape.Context) {
 	})
 }
 
+func (s *server) handleGETConsensus(jc jape.Context) {
+	jc.Encode(s.t.ConsensusStatus())
+}
+
+func (s *server) handleGETVersionLatest(jc jape.Context) {
+	jc.Encode(s.t.ReleaseStatus())
+}
+
+func (s *server) handleGETHealthz(jc jape.Context) {
+	if err := s.t.Health(); err != nil {
+		jc.Error(err, http.StatusServiceUnavailable)
+		return
+	}
+	jc.Encode("ok")
+}
+
+func (s *server) handleGETOpenAPI(jc jape.Context) {
+	jc.ResponseWriter.Header().Set("Content-Type", "application/json")
+	jc.ResponseWriter.Write(openAPISpec)
+}
+
+// handleGETTroubleshoot is a convenience form of POST /troubleshoot for
+// clients that cannot send a request body. If any siamux or quic addresses
+// are given, only those are tested. Otherwise, the host's announced
+// addresses are looked up from the explorer.
+func (s *server) handleGETTroubleshoot(jc jape.Context) {
+	var hostKey types.PublicKey
+	if jc.DecodeForm("publicKey", &hostKey) != nil {
+		return
+	} else if hostKey == (types.PublicKey{}) {
+		jc.Error(errors.New("missing public key"), http.StatusBadRequest)
+		return
+	}
+
+	var family string
+	var dryRun, quick, force, skipVersionCheck bool
+	if jc.DecodeForm("addressFamily", &family) != nil || jc.DecodeForm("dryRun", &dryRun) != nil || jc.DecodeForm("quick", &quick) != nil || jc.DecodeForm("force", &force) != nil || jc.DecodeForm("skipVersionCheck", &skipVersionCheck) != nil {
+		return
+	}
+
+	host := troubleshoot.Host{PublicKey: hostKey}
+	query := jc.Request.URL.Query()
+	for _, proto := range []chain.Protocol{siamux.Protocol, quic.Protocol} {
+		for _, addr := range query[string(proto)] {
+			host.RHP4NetAddresses = append(host.RHP4NetAddresses, chain.NetAddress{Protocol: proto, Address: addr})
+		}
+	}
+	if len(host.RHP4NetAddresses) == 0 {
+		var err error
+		host, err = s.t.AnnouncedHost(hostKey)
+		if jc.Check("failed to get announced host", err) != nil {
+			return
+		}
+	}
+	host.AddressFamily = troubleshoot.AddressFamily(family)
+	host.DryRun = dryRun
+	host.Quick = quick
+	host.Force = force
+	host.SkipVersionCheck = skipVersionCheck
+
+	ctx, cancel := context.WithTimeout(jc.Request.Context(), 45*time.Second)
+	defer cancel()
+
+	resp, err := s.t.TestHost(ctx, host)
+	if err != nil {
+		writeTestError(jc, err)
+		return
+	}
+	jc.Encode(resp)
+}
+
 func (s *server) handlePOSTTroubleshoot(jc jape.Context) {
 	var req troubleshoot.Host
 	if jc.Decode(&req) != nil {
@@ -42,19 +145,187 @@ func (s *server) handlePOSTTroubleshoot(jc jape.Context) {
 
 	resp, err := s.t.TestHost(ctx, req)
 	if err != nil {
-		jc.Error(err, http.StatusInternalServerError)
+		writeTestError(jc, err)
+		return
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handlePOSTTroubleshootAnnounced(jc jape.Context) {
+	var req TroubleshootAnnouncedRequest
+	if jc.Decode(&req) != nil {
+		return
+	}
+
+	host, err := s.t.AnnouncedHost(req.PublicKey)
+	if jc.Check("failed to get announced host", err) != nil {
+		return
+	}
+	host.Protocols = req.Protocols
+	host.Quick = req.Quick
+	host.Force = req.Force
+	host.ContractDuration = req.ContractDuration
+	host.DataSize = req.DataSize
+	host.SkipVersionCheck = req.SkipVersionCheck
+
+	ctx, cancel := context.WithTimeout(jc.Request.Context(), 45*time.Second)
+	defer cancel()
+
+	resp, err := s.t.TestHost(ctx, host)
+	if err != nil {
+		writeTestError(jc, err)
+		return
+	}
+	jc.Encode(resp)
+}
+
+func (s *server) handlePOSTCompare(jc jape.Context) {
+	var req CompareRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if req.A.PublicKey == req.B.PublicKey {
+		// the second test would always be rejected by the cooldown
+		jc.Error(errors.New("hosts must have different public keys"), http.StatusBadRequest)
+		return
+	}
+
+	// check both cooldowns first so that neither host is tested, and put
+	// on cooldown, if the comparison would be rejected
+	for _, host := range []troubleshoot.Host{req.A, req.B} {
+		if n := s.t.Cooldown(host); n > 0 {
+			writeTestError(jc, &troubleshoot.CooldownError{Remaining: n})
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(jc.Request.Context(), 45*time.Second)
+	defer cancel()
+
+	var resp CompareResponse
+	var errA, errB error
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		resp.A, errA = s.t.TestHost(ctx, req.A)
+	}()
+	go func() {
+		defer wg.Done()
+		resp.B, errB = s.t.TestHost(ctx, req.B)
+	}()
+	wg.Wait()
+	if err := errors.Join(errA, errB); err != nil {
+		writeTestError(jc, err)
 		return
 	}
+	resp.Differences = troubleshoot.Compare(resp.A, resp.B)
 	jc.Encode(resp)
 }
 
+func (s *server) handlePOSTSettings(jc jape.Context) {
+	var req troubleshoot.Host
+	if jc.Decode(&req) != nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(jc.Request.Context(), 45*time.Second)
+	defer cancel()
+
+	settings, err := s.t.FetchSettings(ctx, req)
+	if jc.Check("failed to fetch settings", err) != nil {
+		return
+	}
+	jc.Encode(settings)
+}
+
+func (s *server) handleGETHostRecent(jc jape.Context) {
+	var hostKey types.PublicKey
+	if jc.DecodeParam("publicKey", &hostKey) != nil {
+		return
+	}
+	results := s.t.RecentResults(hostKey)
+	if results == nil {
+		results = []troubleshoot.Result{}
+	}
+	jc.Encode(results)
+}
+
+func (s *server) handleGETTroubleshootDNS(jc jape.Context) {
+	var hostname, subnet string
+	if jc.DecodeForm("hostname", &hostname) != nil || jc.DecodeForm("subnet", &subnet) != nil {
+		return
+	} else if hostname == "" || subnet == "" {
+		jc.Error(errors.New("hostname and subnet are required"), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(jc.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	lookup, err := s.t.LookupSubnet(ctx, hostname, subnet)
+	if jc.Check("failed to look up hostname", err) != nil {
+		return
+	}
+	jc.Encode(lookup)
+}
+
+func (s *server) handlePOSTTroubleshootDNS(jc jape.Context) {
+	var req DNSRequest
+	if jc.Decode(&req) != nil {
+		return
+	} else if req.Hostname == "" {
+		jc.Error(errors.New("hostname is required"), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(jc.Request.Context(), 15*time.Second)
+	defer cancel()
+
+	lookup, err := s.t.LookupDNS(ctx, req.Hostname, req.Resolver, req.RecordType)
+	if jc.Check("failed to look up hostname", err) != nil {
+		return
+	}
+	jc.Encode(lookup)
+}
+
 // NewHandler returns a new HTTP handler for the API.
-func NewHandler(t Troubleshooter) http.Handler {
+func NewHandler(t Troubleshooter, opts ...ServerOption) http.Handler {
 	s := &server{
 		t: t,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	// only the troubleshoot endpoints require auth, are rate limited, and
+	// are assigned request IDs
+	private := func(h jape.Handler) jape.Handler { return h }
+	if s.password != "" {
+		private = jape.Adapt(jape.BasicAuth(s.password))
+	}
+	if s.limiter != nil {
+		auth := private
+		private = func(h jape.Handler) jape.Handler { return s.limiter.limit(auth(h)) }
+	}
+	// every request is assigned an ID, even if it is rejected
+	limited := private
+	private = func(h jape.Handler) jape.Handler { return withRequestID(limited(h)) }
+
 	return jape.Mux(map[string]jape.Handler{
-		"GET /state":         s.handleGETState,
-		"POST /troubleshoot": s.handlePOSTTroubleshoot,
+		"GET /state":          s.handleGETState,
+		"GET /healthz":        s.handleGETHealthz,
+		"GET /consensus":      s.handleGETConsensus,
+		"GET /version/latest": s.handleGETVersionLatest,
+		"GET /openapi.json":   s.handleGETOpenAPI,
+
+		"GET /troubleshoot":            private(s.handleGETTroubleshoot),
+		"POST /troubleshoot":           private(s.handlePOSTTroubleshoot),
+		"POST /troubleshoot/announced": private(s.handlePOSTTroubleshootAnnounced),
+		"GET /troubleshoot/dns":        private(s.handleGETTroubleshootDNS),
+		"POST /troubleshoot/dns":       private(s.handlePOSTTroubleshootDNS),
+		"POST /compare":                private(s.handlePOSTCompare),
+		"POST /settings":               private(s.handlePOSTSettings),
+
+		"GET /hosts/:publicKey/recent": private(s.handleGETHostRecent),
 	})
 }
@@ -15,6 +15,12 @@ func Version() string {
 	return version
 }
 
+// UserAgent returns the User-Agent sent with outbound HTTP requests so that
+// they can be identified as coming from troubleshootd.
+func UserAgent() string {
+	return "troubleshootd/" + version
+}
+
 // Time returns the time at which the binary was built.
 func Time() time.Time {
 	return time.Unix(buildTime, 0)
@@ -3,20 +3,102 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/signal"
+	"strings"
 	"time"
 
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
 	eapi "go.sia.tech/explored/api"
 	"go.sia.tech/troubleshootd/api"
 	"go.sia.tech/troubleshootd/build"
+	"go.sia.tech/troubleshootd/internal/config"
+	"go.sia.tech/troubleshootd/internal/dns"
+	"go.sia.tech/troubleshootd/internal/logfile"
 	"go.sia.tech/troubleshootd/troubleshoot"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/proxy"
 )
 
+// shutdownTimeout is the maximum time to wait for in-flight requests to
+// complete during shutdown.
+const shutdownTimeout = time.Minute
+
+// proxyDialer returns a dialer for the SOCKS5 proxy at rawURL. If rawURL is
+// empty, the ALL_PROXY and HTTPS_PROXY environment variables are checked
+// instead. Proxies from the environment that are not SOCKS5 are ignored,
+// since they may be intended for HTTP clients only. A nil dialer means
+// connections are made directly.
+func proxyDialer(rawURL string, log *zap.Logger) (proxy.ContextDialer, error) {
+	fromEnv := rawURL == ""
+	if fromEnv {
+		for _, key := range []string{"ALL_PROXY", "all_proxy", "HTTPS_PROXY", "https_proxy"} {
+			if rawURL = os.Getenv(key); rawURL != "" {
+				break
+			}
+		}
+	}
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse proxy URL: %w", err)
+	} else if u.Scheme != "socks5" && u.Scheme != "socks5h" {
+		if fromEnv {
+			log.Warn("ignoring non-SOCKS5 proxy from environment", zap.String("scheme", u.Scheme))
+			return nil, nil
+		}
+		return nil, fmt.Errorf("unsupported proxy scheme %q: only socks5 is supported", u.Scheme)
+	}
+
+	dialer, err := proxy.FromURL(u, proxy.Direct)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create proxy dialer: %w", err)
+	}
+	return dialer.(proxy.ContextDialer), nil
+}
+
+// parseCIDRs parses a comma-separated list of networks in CIDR notation.
+func parseCIDRs(s string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		if cidr = strings.TrimSpace(cidr); cidr == "" {
+			continue
+		}
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q: %w", cidr, err)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// A userAgentTransport sets the User-Agent of each request that does not
+// already have one.
+type userAgentTransport struct {
+	rt        http.RoundTripper
+	userAgent string
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("User-Agent") == "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("User-Agent", t.userAgent)
+	}
+	return t.rt.RoundTrip(req)
+}
+
 // humanEncoder returns a zapcore.Encoder that encodes logs as human-readable
 // text.
 func humanEncoder(showColors bool) zapcore.Encoder {
@@ -35,23 +117,176 @@ func humanEncoder(showColors bool) zapcore.Encoder {
 	return zapcore.NewConsoleEncoder(cfg)
 }
 
+// jsonEncoder returns a zapcore.Encoder that encodes logs as JSON.
+func jsonEncoder() zapcore.Encoder {
+	cfg := zap.NewProductionEncoderConfig()
+	cfg.EncodeTime = zapcore.RFC3339TimeEncoder
+	cfg.EncodeDuration = zapcore.StringDurationEncoder
+	cfg.EncodeLevel = zapcore.LowercaseLevelEncoder
+	cfg.StacktraceKey = ""
+	return zapcore.NewJSONEncoder(cfg)
+}
+
 func main() {
 	var (
-		httpAddr string
+		configPath string
+
+		httpAddr     string
+		apiPassword  string
+		apiRateLimit int
 
 		exploredAPIAddress  string
 		exploredAPIPassword string
+		explorerRetries     int
+		explorerBackoff     time.Duration
+		explorerTimeout     time.Duration
 
-		logLevel zap.AtomicLevel
+		startupTimeout time.Duration
+
+		consensusMode string
+		consensusDir  string
+		consensusAddr string
+
+		proxyURL     string
+		dnsResolvers string
+		ecsResolver  string
+		maxCNAMEs    int
+
+		scanConcurrency      int
+		scanMaxAddresses     int
+		scanRetries          int
+		scanRetryBackoff     time.Duration
+		scanDefaultPorts     bool
+		scanDialTimeout      time.Duration
+		scanSourceAddr       string
+		scanAllowCIDRs       string
+		scanDenyCIDRs        string
+		scanAllowInternal    bool
+		scanHandshakeTimeout time.Duration
+		scanSettingsTimeout  time.Duration
+		scanCooldown         time.Duration
+		scanCacheTTL         time.Duration
+		scanRDAP             bool
+		scanDisableQUIC      bool
+		scanDisableSiaMux    bool
+
+		historySize     int
+		historyMaxHosts int
+		flapTransitions int
+		flapWindow      time.Duration
+
+		checkMinCollateralRatio  float64
+		checkMinContractDuration uint64
+		checkHardforkWindow      uint64
+		checkPrereleases         bool
+
+		webhookURL      string
+		webhookSeverity string
+
+		logLevel      zap.AtomicLevel
+		logFormat     string
+		logFile       string
+		logMaxSize    int
+		logMaxBackups int
 	)
 
+	flag.StringVar(&configPath, "config", "", "Path of a JSON file to load flag values from; flags set on the command line take precedence")
 	flag.StringVar(&httpAddr, "http.addr", ":8080", "HTTP address to listen on")
-	flag.StringVar(&exploredAPIAddress, "explorer.address", "https://api.siascan.com", "Explored API address")
-	flag.StringVar(&exploredAPIPassword, "explorer.password", "", "Explored API password")
+	flag.StringVar(&apiPassword, "api.password", "", "Password required to use the troubleshoot endpoints; if empty, TROUBLESHOOTD_API_PASSWORD is used, and if both are empty, they are public")
+	flag.IntVar(&apiRateLimit, "api.rate-limit", 0, "Maximum number of troubleshoot requests per minute from each client IP; if 0, requests are not limited")
+	flag.StringVar(&exploredAPIAddress, "explorer.address", "https://api.siascan.com", "Comma-separated list of explored API addresses; if a request to one fails, the next is tried")
+	flag.StringVar(&exploredAPIPassword, "explorer.password", "", "Explored API password; if empty, TROUBLESHOOTD_EXPLORER_PASSWORD is used")
+	flag.IntVar(&explorerRetries, "explorer.retries", 3, "Maximum number of attempts for explorer requests")
+	flag.DurationVar(&explorerBackoff, "explorer.retry-backoff", time.Second, "Initial delay between explorer request attempts")
+	flag.DurationVar(&explorerTimeout, "explorer.timeout", 10*time.Second, "Timeout for each explorer request attempt")
+	flag.DurationVar(&startupTimeout, "startup.timeout", 2*time.Minute, "Maximum time to wait for GitHub and the explorer at startup; if 0, startup is not bounded")
+	flag.StringVar(&consensusMode, "consensus.mode", "explorer", "Source of consensus state (explorer, local); local syncs the blockchain instead of querying the explorer, which is still used for host announcements")
+	flag.StringVar(&consensusDir, "consensus.dir", "consensus", "Directory to store the blockchain in when using local consensus")
+	flag.StringVar(&consensusAddr, "consensus.addr", ":9981", "Address to listen for peer connections on when using local consensus")
+	flag.StringVar(&proxyURL, "proxy.url", "", "SOCKS5 proxy URL for TCP connections to hosts; if empty, ALL_PROXY or HTTPS_PROXY is used. QUIC connections are always made directly")
+	flag.StringVar(&dnsResolvers, "dns.resolvers", "system,1.1.1.1:53", "Comma-separated list of DNS servers to query concurrently when resolving hosts, as host:port, tls://host[:port] for DNS over TLS, or an https:// URL for DNS over HTTPS; \"system\" uses the system resolver")
+	flag.IntVar(&maxCNAMEs, "dns.max-cname-depth", dns.DefaultMaxCNAMEDepth, "Maximum number of CNAME records to follow when resolving hosts")
+	flag.StringVar(&ecsResolver, "dns.ecs-resolver", "8.8.8.8:53", "DNS server used for client subnet lookups, in the same format as -dns.resolvers; it must support the EDNS0 client subnet option")
+	flag.IntVar(&scanConcurrency, "scan.concurrency", 64, "Maximum number of concurrent address tests")
+	flag.IntVar(&scanMaxAddresses, "scan.max-addresses", 16, "Maximum number of RHP4 addresses tested per request; additional addresses are ignored. If 0, the number is not limited")
+	flag.IntVar(&scanRetries, "scan.retries", 1, "Maximum number of attempts when resolving or connecting to a host")
+	flag.DurationVar(&scanRetryBackoff, "scan.retry-backoff", time.Second, "Initial delay between connection attempts")
+	flag.DurationVar(&scanDialTimeout, "scan.dial-timeout", 15*time.Second, "Timeout for each TCP connection attempt")
+	flag.StringVar(&scanSourceAddr, "scan.source-addr", "", "Local IP address to make TCP connections to hosts from; if empty, the default interface is used. QUIC connections always use the default interface")
+	flag.StringVar(&scanAllowCIDRs, "scan.allow-cidr", "", "Comma-separated list of networks, in CIDR notation, hosts must resolve to; if empty, hosts on any network not denied by -scan.deny-cidr are tested")
+	flag.StringVar(&scanDenyCIDRs, "scan.deny-cidr", "", "Comma-separated list of networks, in CIDR notation, that hosts are not tested on, in addition to internal addresses")
+	flag.BoolVar(&scanAllowInternal, "scan.allow-internal", false, "Allow testing hosts that resolve to loopback, private, or link-local addresses; only enable this for trusted deployments, since it lets callers reach the server's internal network and cloud metadata endpoints")
+	flag.DurationVar(&scanHandshakeTimeout, "scan.handshake-timeout", 20*time.Second, "Timeout for each transport handshake")
+	flag.DurationVar(&scanSettingsTimeout, "scan.settings-timeout", 10*time.Second, "Timeout for each settings scan after the handshake completes")
+	flag.DurationVar(&scanCooldown, "scan.cooldown", 15*time.Second, "Minimum time between tests of the same host")
+	flag.DurationVar(&scanCacheTTL, "scan.cache-ttl", time.Minute, "How long a result is returned for repeat requests for the same host and addresses instead of testing again; if 0, results are not cached")
+	flag.BoolVar(&scanRDAP, "scan.rdap", false, "Look up the network owner and abuse contact of each resolved address using RDAP")
+	flag.BoolVar(&scanDisableQUIC, "scan.disable-quic", false, "Skip testing QUIC addresses, for example if the server's network blocks UDP")
+	flag.BoolVar(&scanDisableSiaMux, "scan.disable-siamux", false, "Skip testing siamux addresses, for example if the server's network blocks outgoing TCP connections to hosts")
+	flag.BoolVar(&scanDefaultPorts, "scan.default-ports", false, "Assume the default port for addresses without one instead of rejecting them")
+	flag.IntVar(&historySize, "history.size", 10, "Number of recent results kept in memory for each host; if 0, results are not kept")
+	flag.IntVar(&historyMaxHosts, "history.max-hosts", 1000, "Maximum number of hosts whose recent results are kept; the least recently tested host is evicted first")
+	flag.IntVar(&flapTransitions, "history.flap-transitions", 3, "Number of reachability changes within the flap window that add a flapping warning; if 0, flapping is not detected")
+	flag.DurationVar(&flapWindow, "history.flap-window", time.Hour, "Window of recent results checked for flapping")
+	flag.Float64Var(&checkMinCollateralRatio, "check.min-collateral-ratio", 2, "Ratio of the collateral price to the storage price below which a warning is reported")
+	flag.Uint64Var(&checkMinContractDuration, "check.min-contract-duration", 144*30, "Maximum contract duration, in blocks, below which a warning is reported")
+	flag.Uint64Var(&checkHardforkWindow, "check.hardfork-window", 144*7, "Number of blocks before the v2 hardfork's require height that hosts without a working RHP4 address are warned; if 0, no warning is reported")
+	flag.BoolVar(&checkPrereleases, "check.prereleases", false, "Compare hosts against the latest hostd release even if it is a pre-release")
+	flag.StringVar(&webhookURL, "webhook.url", "", "URL to POST the result of each test that finds issues to; if empty, no webhooks are sent")
+	flag.StringVar(&webhookSeverity, "webhook.severity", "error", "Minimum issue severity that triggers a webhook (error, warning)")
 	flag.TextVar(&logLevel, "log.level", zap.NewAtomicLevelAt(zapcore.InfoLevel), "Log level (debug, info, warn, error)")
+	flag.StringVar(&logFormat, "log.format", "human", "Log format (human, json)")
+	flag.StringVar(&logFile, "log.file", "", "Path of a file to also write logs to; if empty, logs are only written to stdout")
+	flag.IntVar(&logMaxSize, "log.max-size", 100, "Maximum size of the log file in megabytes before it is rotated")
+	flag.IntVar(&logMaxBackups, "log.max-backups", 3, "Maximum number of rotated log files to keep")
 	flag.Parse()
 
-	core := zapcore.NewCore(humanEncoder(true), zapcore.Lock(os.Stdout), logLevel)
+	if configPath != "" {
+		if err := config.Load(flag.CommandLine, configPath); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+	}
+	// secrets are read from the environment so they are not visible in the
+	// process list
+	err := config.LoadEnv(flag.CommandLine, map[string]string{
+		"api.password":      "TROUBLESHOOTD_API_PASSWORD",
+		"explorer.password": "TROUBLESHOOTD_EXPLORER_PASSWORD",
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	// the scan subcommand prints its result to stdout, so logs are written
+	// to stderr instead
+	scanMode := flag.Arg(0) == "scan"
+	logOutput := os.Stdout
+	if scanMode {
+		logOutput = os.Stderr
+	}
+
+	var encoder, fileEncoder zapcore.Encoder
+	switch logFormat {
+	case "human":
+		encoder, fileEncoder = humanEncoder(true), humanEncoder(false)
+	case "json":
+		encoder, fileEncoder = jsonEncoder(), jsonEncoder()
+	default:
+		fmt.Fprintf(os.Stderr, "invalid log format %q: must be human or json\n", logFormat)
+		os.Exit(1)
+	}
+
+	core := zapcore.NewCore(encoder, zapcore.Lock(logOutput), logLevel)
+	if logFile != "" {
+		w, err := logfile.Open(logFile, int64(logMaxSize)<<20, logMaxBackups)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		defer w.Close()
+		core = zapcore.NewTee(core, zapcore.NewCore(fileEncoder, w, logLevel))
+	}
 	log := zap.New(core, zap.AddCaller())
 	defer log.Sync()
 
@@ -60,28 +295,152 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	exploredClient := eapi.NewClient(exploredAPIAddress, exploredAPIPassword)
-
-	tip, err := exploredClient.ConsensusTip()
+	dialer, err := proxyDialer(proxyURL, log)
 	if err != nil {
-		log.Fatal("failed to get consensus tip from explored API", zap.Error(err))
+		log.Fatal("failed to configure proxy", zap.Error(err))
+	} else if dialer != nil {
+		log.Info("using proxy for host connections")
+	}
+
+	// validate the DNS servers at startup so that a typo does not fail
+	// every test
+	resolvers := strings.Split(dnsResolvers, ",")
+	for _, server := range resolvers {
+		if server = strings.TrimSpace(server); server != "" && server != "system" {
+			if err := dns.ValidateServer(server); err != nil {
+				log.Fatal("invalid DNS resolver", zap.Error(err))
+			}
+		}
+	}
+	if err := dns.ValidateServer(ecsResolver); err != nil {
+		log.Fatal("invalid client subnet resolver", zap.Error(err))
+	}
+
+	if scanConcurrency <= 0 {
+		log.Fatal("invalid scan concurrency: must be at least 1", zap.Int("concurrency", scanConcurrency))
 	}
 
-	t, err := troubleshoot.NewManager(exploredClient, log.Named("troubleshoot"))
+	opts := []troubleshoot.Option{
+		troubleshoot.WithMaxConcurrentScans(scanConcurrency),
+		troubleshoot.WithMaxAddresses(scanMaxAddresses),
+		troubleshoot.WithExplorerRetries(explorerRetries, explorerBackoff, explorerTimeout),
+		troubleshoot.WithRetries(scanRetries, scanRetryBackoff),
+		troubleshoot.WithDialTimeout(scanDialTimeout),
+		troubleshoot.WithStepTimeouts(scanHandshakeTimeout, scanSettingsTimeout),
+		troubleshoot.WithCooldown(scanCooldown),
+		troubleshoot.WithResultCacheTTL(scanCacheTTL),
+		troubleshoot.WithProxy(dialer),
+		troubleshoot.WithNetworkOwnerLookup(scanRDAP),
+		troubleshoot.WithResolvers(resolvers...),
+		troubleshoot.WithMaxCNAMEDepth(maxCNAMEs),
+		troubleshoot.WithECSResolver(ecsResolver),
+		troubleshoot.WithDefaultPorts(scanDefaultPorts),
+		troubleshoot.WithHistory(historySize, historyMaxHosts),
+		troubleshoot.WithFlapDetection(flapTransitions, flapWindow),
+		troubleshoot.WithHardforkWarningWindow(checkHardforkWindow),
+		troubleshoot.WithPrereleases(checkPrereleases),
+		troubleshoot.WithThresholds(troubleshoot.Thresholds{
+			MinCollateralRatio:  checkMinCollateralRatio,
+			MinContractDuration: checkMinContractDuration,
+		}),
+	}
+	if scanSourceAddr != "" {
+		ip := net.ParseIP(scanSourceAddr)
+		if ip == nil {
+			log.Fatal("invalid source address", zap.String("addr", scanSourceAddr))
+		}
+		opts = append(opts, troubleshoot.WithSourceAddress(ip))
+	}
+	allowed, err := parseCIDRs(scanAllowCIDRs)
+	if err != nil {
+		log.Fatal("invalid allowed networks", zap.Error(err))
+	}
+	denied, err := parseCIDRs(scanDenyCIDRs)
 	if err != nil {
+		log.Fatal("invalid denied networks", zap.Error(err))
+	}
+	opts = append(opts, troubleshoot.WithNetworkRestrictions(allowed, denied), troubleshoot.WithInternalAddresses(scanAllowInternal))
+	var disabled []chain.Protocol
+	if scanDisableSiaMux {
+		disabled = append(disabled, siamux.Protocol)
+	}
+	if scanDisableQUIC {
+		disabled = append(disabled, quic.Protocol)
+	}
+	if len(disabled) > 0 {
+		opts = append(opts, troubleshoot.WithDisabledProtocols(disabled...))
+	}
+	if webhookURL != "" {
+		switch webhookSeverity {
+		case "error", "warning":
+		default:
+			log.Fatal("invalid webhook severity", zap.String("severity", webhookSeverity))
+		}
+		opts = append(opts, troubleshoot.WithWebhook(webhookURL, webhookSeverity == "warning"))
+	}
+
+	// the explorer client uses the default HTTP client and does not allow
+	// setting headers
+	http.DefaultClient.Transport = userAgentTransport{rt: http.DefaultTransport, userAgent: build.UserAgent()}
+
+	var explorers []troubleshoot.Explorer
+	for _, addr := range strings.Split(exploredAPIAddress, ",") {
+		explorers = append(explorers, eapi.NewClient(strings.TrimSpace(addr), exploredAPIPassword))
+	}
+	explorer := troubleshoot.NewFailoverExplorer(explorers...)
+
+	switch consensusMode {
+	case "explorer":
+	case "local":
+		n, err := startNode(consensusDir, consensusAddr, log.Named("node"))
+		if err != nil {
+			log.Fatal("failed to start local node", zap.Error(err))
+		}
+		defer n.Close()
+		if err := n.waitForSync(ctx, log); err != nil {
+			log.Fatal("failed to sync local node", zap.Error(err))
+		}
+		explorer = localExplorer{cm: n.cm, hosts: explorer}
+	default:
+		log.Fatal("invalid consensus mode", zap.String("mode", consensusMode))
+	}
+
+	// bound startup so that a hung explorer or GitHub request cannot hang
+	// the daemon
+	startupCtx := ctx
+	if startupTimeout > 0 {
+		var startupCancel context.CancelFunc
+		startupCtx, startupCancel = context.WithTimeout(ctx, startupTimeout)
+		defer startupCancel()
+	}
+	t, err := troubleshoot.NewManager(startupCtx, explorer, log.Named("troubleshoot"), opts...)
+	if err != nil && startupCtx.Err() == context.DeadlineExceeded {
+		log.Fatal("timed out waiting for GitHub and the explorer at startup: check that they are reachable or increase -startup.timeout", zap.Duration("timeout", startupTimeout), zap.Error(err))
+	} else if err != nil {
 		log.Fatal("failed to create troubleshoot manager", zap.Error(err))
 	}
 	defer t.Close()
+	tip := t.ConsensusTip()
 
-	l, err := net.Listen("tcp", ":8080")
+	if scanMode {
+		err := runScan(ctx, t, flag.Args()[1:])
+		// wait for webhook deliveries before exiting
+		t.Close()
+		if err != nil {
+			log.Fatal("scan failed", zap.Error(err))
+		}
+		return
+	}
+
+	l, err := net.Listen("tcp", httpAddr)
 	if err != nil {
-		log.Fatal("failed to listen", zap.Error(err))
+		log.Fatal("failed to listen", zap.String("addr", httpAddr), zap.Error(err))
 	}
 	defer l.Close()
 
 	srv := &http.Server{
 		ReadTimeout: 10 * time.Second,
-		Handler:     api.NewHandler(t),
+		Handler:     api.NewHandler(t, api.WithBasicAuth(apiPassword), api.WithRateLimit(apiRateLimit, time.Minute)),
 	}
 	defer srv.Close()
 	go func() {
@@ -92,5 +451,13 @@ func main() {
 
 	log.Info("troubleshoot server started", zap.Stringer("tip", tip), zap.String("http", l.Addr().String()), zap.String("version", build.Version()), zap.String("explorer", exploredAPIAddress))
 	<-ctx.Done()
-	log.Info("shutting down server")
+	log.Info("shutting down server", zap.Int("activeTests", t.ActiveTests()))
+
+	// stop accepting new requests and wait for in-flight tests to complete
+	// before the manager is closed
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer shutdownCancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Warn("failed to gracefully shut down server", zap.Error(err), zap.Int("activeTests", t.ActiveTests()))
+	}
 }
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/gateway"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/syncer"
+	"go.sia.tech/explored/explorer"
+	"go.sia.tech/troubleshootd/troubleshoot"
+	"go.uber.org/zap"
+)
+
+// syncedThreshold is how old the local tip can be before the node is
+// considered to still be syncing. Blocks are expected every 10 minutes,
+// so a gap of 3 hours is very unlikely on a synced node.
+const syncedThreshold = 3 * time.Hour
+
+// A peerStore is an in-memory syncer.PeerStore. Peers are rediscovered
+// from the bootstrap peers on each start.
+type peerStore struct {
+	mu     sync.Mutex
+	peers  map[string]syncer.PeerInfo
+	banned map[string]time.Time
+}
+
+// AddPeer implements syncer.PeerStore.
+func (ps *peerStore) AddPeer(addr string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, ok := ps.peers[addr]; !ok {
+		ps.peers[addr] = syncer.PeerInfo{Address: addr, FirstSeen: time.Now()}
+	}
+	return nil
+}
+
+// Peers implements syncer.PeerStore.
+func (ps *peerStore) Peers() ([]syncer.PeerInfo, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	peers := make([]syncer.PeerInfo, 0, len(ps.peers))
+	for _, p := range ps.peers {
+		peers = append(peers, p)
+	}
+	return peers, nil
+}
+
+// PeerInfo implements syncer.PeerStore.
+func (ps *peerStore) PeerInfo(addr string) (syncer.PeerInfo, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.peers[addr]
+	if !ok {
+		return syncer.PeerInfo{}, syncer.ErrPeerNotFound
+	}
+	return p, nil
+}
+
+// UpdatePeerInfo implements syncer.PeerStore.
+func (ps *peerStore) UpdatePeerInfo(addr string, fn func(*syncer.PeerInfo)) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	p, ok := ps.peers[addr]
+	if !ok {
+		return syncer.ErrPeerNotFound
+	}
+	fn(&p)
+	ps.peers[addr] = p
+	return nil
+}
+
+// Ban implements syncer.PeerStore. Only individual addresses are banned;
+// subnet bans are ignored.
+func (ps *peerStore) Ban(addr string, duration time.Duration, _ string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	ps.banned[addr] = time.Now().Add(duration)
+	return nil
+}
+
+// Banned implements syncer.PeerStore.
+func (ps *peerStore) Banned(addr string) (bool, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	return time.Now().Before(ps.banned[addr]), nil
+}
+
+func newPeerStore() *peerStore {
+	return &peerStore{
+		peers:  make(map[string]syncer.PeerInfo),
+		banned: make(map[string]time.Time),
+	}
+}
+
+// A localExplorer answers consensus state requests from a local chain
+// manager. Host announcements are not indexed locally, so host lookups are
+// still sent to the remote explorer.
+type localExplorer struct {
+	cm    *chain.Manager
+	hosts troubleshoot.Explorer
+}
+
+// ConsensusState implements troubleshoot.Explorer. An error is returned
+// while the node is syncing so that the server does not compare hosts
+// against an old tip.
+func (le localExplorer) ConsensusState() (consensus.State, error) {
+	cs := le.cm.TipState()
+	if age := time.Since(cs.PrevTimestamps[0]); age > syncedThreshold {
+		return consensus.State{}, fmt.Errorf("local node is still syncing: height %d is %s old", cs.Index.Height, age.Round(time.Minute))
+	}
+	return cs, nil
+}
+
+// Host implements troubleshoot.Explorer.
+func (le localExplorer) Host(hostKey types.PublicKey) (explorer.Host, error) {
+	return le.hosts.Host(hostKey)
+}
+
+// A node syncs the blockchain from the Sia network.
+type node struct {
+	cm *chain.Manager
+	s  *syncer.Syncer
+	db *coreutils.BoltChainDB
+}
+
+// Close stops syncing and closes the chain database.
+func (n *node) Close() error {
+	return errors.Join(n.s.Close(), n.db.Close())
+}
+
+// waitForSync blocks until the node is synced or the context is canceled,
+// periodically logging the sync progress.
+func (n *node) waitForSync(ctx context.Context, log *zap.Logger) error {
+	le := localExplorer{cm: n.cm}
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		if _, err := le.ConsensusState(); err == nil {
+			return nil
+		}
+		log.Info("waiting for the local node to sync", zap.Stringer("tip", n.cm.Tip()))
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// startNode opens the chain database in dir and starts syncing from the
+// Sia network, accepting peer connections on addr.
+func startNode(dir, addr string, log *zap.Logger) (*node, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create consensus directory: %w", err)
+	}
+	db, err := coreutils.OpenBoltChainDB(filepath.Join(dir, "consensus.db"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open consensus database: %w", err)
+	}
+
+	network, genesisBlock := chain.Mainnet()
+	store, tipState, err := chain.NewDBStore(db, network, genesisBlock, chain.NewZapMigrationLogger(log.Named("migrate")))
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create chain store: %w", err)
+	}
+	cm := chain.NewManager(store, tipState)
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to listen for peers: %w", err)
+	}
+
+	ps := newPeerStore()
+	for _, peer := range syncer.MainnetBootstrapPeers {
+		ps.AddPeer(peer)
+	}
+	header := gateway.Header{
+		GenesisID:  genesisBlock.ID(),
+		UniqueID:   gateway.GenerateUniqueID(),
+		NetAddress: l.Addr().String(),
+	}
+	s := syncer.New(l, cm, ps, header, syncer.WithLogger(log.Named("syncer")))
+	go func() {
+		if err := s.Run(); err != nil {
+			log.Error("syncer stopped", zap.Error(err))
+		}
+	}()
+	return &node{cm: cm, s: s, db: db}, nil
+}
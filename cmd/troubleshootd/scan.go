@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+	"go.sia.tech/troubleshootd/troubleshoot"
+)
+
+// parseScanAddress parses an address given on the command line. Addresses
+// may be prefixed with "siamux://" or "quic://" to test a single protocol.
+// Otherwise both protocols are tested.
+func parseScanAddress(s string) []chain.NetAddress {
+	for _, proto := range []chain.Protocol{siamux.Protocol, quic.Protocol} {
+		if addr, ok := strings.CutPrefix(s, string(proto)+"://"); ok {
+			return []chain.NetAddress{{Protocol: proto, Address: addr}}
+		}
+	}
+	return []chain.NetAddress{
+		{Protocol: siamux.Protocol, Address: s},
+		{Protocol: quic.Protocol, Address: s},
+	}
+}
+
+// runScan tests a single host and prints the result to stdout. It returns
+// an error if the host could not be tested or the test found errors.
+func runScan(ctx context.Context, t *troubleshoot.Manager, args []string) error {
+	fs := flag.NewFlagSet("scan", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: troubleshootd [flags] scan [-json] <public key> [address...]")
+		fmt.Fprintln(fs.Output(), "If no addresses are given, the host's announced addresses are tested.")
+		fs.PrintDefaults()
+	}
+	jsonOutput := fs.Bool("json", false, "Print the result as JSON")
+	timeout := fs.Duration("timeout", 45*time.Second, "Maximum time to spend testing the host")
+	family := fs.String("family", "", "Only resolve and dial addresses of this family (\"ipv4\" or \"ipv6\")")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		return errors.New("missing public key")
+	}
+
+	var hostKey types.PublicKey
+	if err := hostKey.UnmarshalText([]byte(fs.Arg(0))); err != nil {
+		return fmt.Errorf("invalid public key: %w", err)
+	}
+
+	host := troubleshoot.Host{PublicKey: hostKey}
+	for _, arg := range fs.Args()[1:] {
+		host.RHP4NetAddresses = append(host.RHP4NetAddresses, parseScanAddress(arg)...)
+	}
+	if len(host.RHP4NetAddresses) == 0 {
+		var err error
+		host, err = t.AnnouncedHost(hostKey)
+		if err != nil {
+			return fmt.Errorf("failed to get announced host: %w", err)
+		}
+	}
+
+	host.AddressFamily = troubleshoot.AddressFamily(*family)
+
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+
+	result, err := t.TestHost(ctx, host)
+	if err != nil {
+		return fmt.Errorf("failed to test host: %w", err)
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(result); err != nil {
+			return fmt.Errorf("failed to encode result: %w", err)
+		}
+	} else {
+		// only use colors when writing to a terminal
+		info, err := os.Stdout.Stat()
+		colors := err == nil && info.Mode()&os.ModeCharDevice != 0
+		if err := result.WriteReport(os.Stdout, colors); err != nil {
+			return fmt.Errorf("failed to write report: %w", err)
+		}
+	}
+
+	if len(result.Errors) > 0 {
+		return fmt.Errorf("host has %d errors", len(result.Errors))
+	}
+	return nil
+}
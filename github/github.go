@@ -2,24 +2,96 @@ package github
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/google/go-github/github"
+	"go.sia.tech/troubleshootd/build"
 )
 
-// LatestRelease fetches the latest release from a GitHub repository.
-func LatestRelease(org, repo string) (string, error) {
-	client := github.NewClient(nil)
+// abuseBackoff is how long requests should be paused after GitHub's abuse
+// rate limit is reached without a Retry-After header.
+const abuseBackoff = time.Hour
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// RateLimitReset returns the time at which requests can resume and true if
+// err was caused by one of GitHub's rate limits. Requests made before then
+// will also fail, so they should not be retried on the normal schedule.
+func RateLimitReset(err error) (time.Time, bool) {
+	var rateErr *github.RateLimitError
+	var abuseErr *github.AbuseRateLimitError
+	switch {
+	case errors.As(err, &rateErr):
+		return rateErr.Rate.Reset.Time, true
+	case errors.As(err, &abuseErr) && abuseErr.RetryAfter != nil:
+		return time.Now().Add(*abuseErr.RetryAfter), true
+	case errors.As(err, &abuseErr):
+		return time.Now().Add(abuseBackoff), true
+	}
+	return time.Time{}, false
+}
+
+// newClient returns a GitHub client that identifies itself as troubleshootd.
+func newClient(httpClient *http.Client) *github.Client {
+	client := github.NewClient(httpClient)
+	client.UserAgent = build.UserAgent()
+	return client
+}
 
+// releaseName returns the release's name, falling back to its tag if it is
+// unnamed.
+func releaseName(release *github.RepositoryRelease) string {
+	// some releases are published with only a tag and no name
+	if release.GetName() != "" {
+		return release.GetName()
+	}
+	return release.GetTagName()
+}
+
+func latestRelease(ctx context.Context, client *github.Client, org, repo string) (string, error) {
 	release, _, err := client.Repositories.GetLatestRelease(ctx, org, repo)
 	if err != nil {
 		return "", err
-	} else if release.Name == nil {
-		return "", fmt.Errorf("no release found for %s/%s", org, repo)
+	} else if name := releaseName(release); name != "" {
+		return name, nil
+	}
+	return "", fmt.Errorf("no release found for %s/%s", org, repo)
+}
+
+// prereleasePageSize is the number of recent releases searched for the
+// latest pre-release.
+const prereleasePageSize = 10
+
+// latestPrerelease returns the most recently published release, including
+// pre-releases. GitHub's latest release only considers stable releases.
+func latestPrerelease(ctx context.Context, client *github.Client, org, repo string) (string, error) {
+	// releases are listed from newest to oldest
+	releases, _, err := client.Repositories.ListReleases(ctx, org, repo, &github.ListOptions{PerPage: prereleasePageSize})
+	if err != nil {
+		return "", err
+	}
+	for _, release := range releases {
+		if release.GetDraft() {
+			continue
+		} else if name := releaseName(release); name != "" {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("no release found for %s/%s", org, repo)
+}
+
+// LatestRelease fetches the latest release from a GitHub repository.
+// The release name is preferred, falling back to the tag name if
+// the release is unnamed. If prereleases is true, the most recently
+// published release is returned, even if it is a pre-release. The request
+// is limited to 10 seconds or the context's deadline, whichever is sooner.
+func LatestRelease(ctx context.Context, org, repo string, prereleases bool) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	if prereleases {
+		return latestPrerelease(ctx, newClient(nil), org, repo)
 	}
-	return *release.Name, nil
+	return latestRelease(ctx, newClient(nil), org, repo)
 }
@@ -0,0 +1,154 @@
+package github
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/github"
+	"go.sia.tech/troubleshootd/build"
+)
+
+func newTestClient(t *testing.T, h http.HandlerFunc) *github.Client {
+	t.Helper()
+
+	srv := httptest.NewServer(h)
+	t.Cleanup(srv.Close)
+
+	client := newClient(srv.Client())
+	u, err := url.Parse(srv.URL + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.BaseURL = u
+	return client
+}
+
+func TestLatestRelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		expected string
+		err      bool
+	}{
+		{"name", `{"name":"v1.2.3","tag_name":"v1.2.2"}`, "v1.2.3", false},
+		{"nil name", `{"tag_name":"v1.2.3"}`, "v1.2.3", false},
+		{"empty name", `{"name":"","tag_name":"v1.2.3"}`, "v1.2.3", false},
+		{"missing", `{}`, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if ua := r.Header.Get("User-Agent"); ua != build.UserAgent() {
+					t.Errorf("expected User-Agent %q, got %q", build.UserAgent(), ua)
+				}
+				if r.URL.Path != "/repos/SiaFoundation/hostd/releases/latest" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(test.payload))
+			})
+
+			release, err := latestRelease(context.Background(), client, "SiaFoundation", "hostd")
+			if test.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if release != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, release)
+			}
+		})
+	}
+}
+
+func TestRateLimitReset(t *testing.T) {
+	reset := time.Now().Add(30 * time.Minute).Truncate(time.Second)
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		limited bool
+		reset   time.Time
+	}{
+		{"rate limit", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("X-RateLimit-Remaining", "0")
+			w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset.Unix(), 10))
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"API rate limit exceeded for 203.0.113.10."}`))
+		}, true, reset},
+		{"abuse retry after", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Retry-After", "1800")
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"You have triggered an abuse detection mechanism.","documentation_url":"https://developer.github.com/v3/#abuse-rate-limits"}`))
+		}, true, reset},
+		{"abuse", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(`{"message":"You have triggered an abuse detection mechanism.","documentation_url":"https://developer.github.com/v3/#abuse-rate-limits"}`))
+		}, true, time.Now().Add(abuseBackoff)},
+		{"server error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, false, time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, test.handler)
+			_, err := latestRelease(context.Background(), client, "SiaFoundation", "hostd")
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			got, ok := RateLimitReset(err)
+			if ok != test.limited {
+				t.Fatalf("expected rate limited %v, got %v: %v", test.limited, ok, err)
+			} else if d := got.Sub(test.reset).Abs(); d > 5*time.Second {
+				t.Fatalf("expected reset at %s, got %s", test.reset, got)
+			}
+		})
+	}
+}
+
+func TestLatestPrerelease(t *testing.T) {
+	tests := []struct {
+		name     string
+		payload  string
+		expected string
+		err      bool
+	}{
+		{"prerelease", `[{"name":"v1.3.0-rc.1","prerelease":true},{"name":"v1.2.3"}]`, "v1.3.0-rc.1", false},
+		{"stable", `[{"name":"v1.2.3"},{"name":"v1.2.3-rc.1","prerelease":true}]`, "v1.2.3", false},
+		{"draft", `[{"name":"v1.4.0","draft":true},{"tag_name":"v1.3.0-beta.1","prerelease":true}]`, "v1.3.0-beta.1", false},
+		{"none", `[]`, "", true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			client := newTestClient(t, func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/repos/SiaFoundation/hostd/releases" {
+					http.NotFound(w, r)
+					return
+				}
+				w.Write([]byte(test.payload))
+			})
+
+			release, err := latestPrerelease(context.Background(), client, "SiaFoundation", "hostd")
+			if test.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if release != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, release)
+			}
+		})
+	}
+}
@@ -5,12 +5,16 @@ go 1.26.0
 require (
 	github.com/google/go-github v17.0.0+incompatible
 	github.com/miekg/dns v1.1.72
+	github.com/quic-go/quic-go v0.60.0
 	go.sia.tech/core v0.21.7
 	go.sia.tech/coreutils v0.23.5
 	go.sia.tech/explored v1.0.0-beta.1
 	go.sia.tech/jape v0.14.1
+	go.sia.tech/mux v1.5.3
 	go.uber.org/zap v1.28.0
 	golang.org/x/exp v0.0.0-20240506185415-9bf2ced13842
+	golang.org/x/net v0.57.0
+	lukechampine.com/frand v1.5.1
 )
 
 require (
@@ -20,16 +24,13 @@ require (
 	github.com/oschwald/geoip2-golang v1.11.0 // indirect
 	github.com/oschwald/maxminddb-golang v1.13.0 // indirect
 	github.com/quic-go/qpack v0.6.0 // indirect
-	github.com/quic-go/quic-go v0.60.0 // indirect
 	github.com/quic-go/webtransport-go v0.11.1 // indirect
-	go.sia.tech/mux v1.5.3 // indirect
+	go.etcd.io/bbolt v1.5.0 // indirect
 	go.uber.org/multierr v1.11.0 // indirect
 	golang.org/x/crypto v0.54.0 // indirect
 	golang.org/x/mod v0.38.0 // indirect
-	golang.org/x/net v0.57.0 // indirect
 	golang.org/x/sync v0.22.0 // indirect
 	golang.org/x/sys v0.47.0 // indirect
 	golang.org/x/text v0.40.0 // indirect
 	golang.org/x/tools v0.48.0 // indirect
-	lukechampine.com/frand v1.5.1 // indirect
 )
@@ -0,0 +1,136 @@
+// Package config loads command-line flag values from a JSON file and
+// environment variables.
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+)
+
+// flatten converts the decoded JSON value v into flag values keyed by
+// name. Nested objects are joined with dots, so {"scan": {"cooldown": "1m"}}
+// sets the "scan.cooldown" flag. Arrays are joined with commas for flags
+// that accept comma-separated lists.
+func flatten(prefix string, v any, values map[string]string) error {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, child := range v {
+			if prefix != "" {
+				key = prefix + "." + key
+			}
+			if err := flatten(key, child, values); err != nil {
+				return err
+			}
+		}
+		return nil
+	case nil:
+		return fmt.Errorf("%q: null is not a valid value", prefix)
+	}
+
+	if prefix == "" {
+		return errors.New("config must be a JSON object")
+	}
+	value, err := stringify(prefix, v)
+	if err != nil {
+		return err
+	}
+	values[prefix] = value
+	return nil
+}
+
+// stringify returns the flag value of a JSON string, number, boolean, or
+// array of them.
+func stringify(key string, v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return fmt.Sprint(v), nil
+	case []any:
+		elems := make([]string, 0, len(v))
+		for _, e := range v {
+			if _, ok := e.([]any); ok {
+				return "", fmt.Errorf("%q: nested arrays are not supported", key)
+			}
+			s, err := stringify(key, e)
+			if err != nil {
+				return "", err
+			}
+			elems = append(elems, s)
+		}
+		return strings.Join(elems, ","), nil
+	default:
+		return "", fmt.Errorf("%q: unsupported value %v", key, v)
+	}
+}
+
+// setFlags returns the names of the flags in fs that have been set.
+func setFlags(fs *flag.FlagSet) map[string]bool {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	return set
+}
+
+// Load sets the flags in fs from the JSON file at path. Flags that were
+// already set on the command line take precedence over the file. It
+// returns an error if the file sets a flag that does not exist or has an
+// invalid value.
+func Load(fs *flag.FlagSet, path string) error {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(buf))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return fmt.Errorf("failed to decode config file: %w", err)
+	}
+	values := make(map[string]string)
+	if err := flatten("", v, values); err != nil {
+		return fmt.Errorf("invalid config file: %w", err)
+	}
+
+	set := setFlags(fs)
+	// sort the names so that errors are deterministic
+	for _, name := range slices.Sorted(maps.Keys(values)) {
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("invalid config file: unknown flag %q", name)
+		} else if set[name] {
+			continue
+		} else if err := fs.Set(name, values[name]); err != nil {
+			return fmt.Errorf("invalid config file: invalid value for %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// LoadEnv sets the flags in fs from environment variables, keyed by flag
+// name. It is intended for secrets, which are visible to other users in
+// the process list when passed on the command line. Flags that were already
+// set on the command line or by a config file take precedence, and empty
+// variables are ignored.
+func LoadEnv(fs *flag.FlagSet, vars map[string]string) error {
+	set := setFlags(fs)
+	for _, name := range slices.Sorted(maps.Keys(vars)) {
+		value, ok := os.LookupEnv(vars[name])
+		if fs.Lookup(name) == nil {
+			return fmt.Errorf("unknown flag %q", name)
+		} else if set[name] || !ok || value == "" {
+			continue
+		} else if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("invalid value for %s: %w", vars[name], err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	password := fs.String("explorer.password", "", "")
+	addresses := fs.String("explorer.address", "https://api.siascan.com", "")
+	cooldown := fs.Duration("scan.cooldown", 15*time.Second, "")
+	concurrency := fs.Int("scan.concurrency", 64, "")
+	rdap := fs.Bool("scan.rdap", false, "")
+	ratio := fs.Float64("check.min-collateral-ratio", 2, "")
+
+	// flags on the command line take precedence over the file
+	if err := fs.Parse([]string{"-scan.concurrency", "8"}); err != nil {
+		t.Fatal(err)
+	}
+
+	path := writeConfig(t, `{
+		"explorer": {
+			"password": "hunter2",
+			"address": ["https://a.example", "https://b.example"]
+		},
+		"scan.cooldown": "1m",
+		"scan": {"concurrency": 16, "rdap": true},
+		"check": {"min-collateral-ratio": 1.5}
+	}`)
+	if err := Load(fs, path); err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case *password != "hunter2":
+		t.Fatalf("expected password from the file, got %q", *password)
+	case *addresses != "https://a.example,https://b.example":
+		t.Fatalf("expected joined addresses, got %q", *addresses)
+	case *cooldown != time.Minute:
+		t.Fatalf("expected cooldown from the file, got %s", *cooldown)
+	case *concurrency != 8:
+		t.Fatalf("expected the command line to override the file, got %d", *concurrency)
+	case !*rdap:
+		t.Fatal("expected rdap to be enabled")
+	case *ratio != 1.5:
+		t.Fatalf("expected ratio from the file, got %g", *ratio)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name     string
+		contents string
+		err      string
+	}{
+		{"unknown flag", `{"scan": {"colldown": "1m"}}`, `unknown flag "scan.colldown"`},
+		{"invalid value", `{"scan.cooldown": "soon"}`, `invalid value for "scan.cooldown"`},
+		{"null", `{"scan.cooldown": null}`, "null is not a valid value"},
+		{"not an object", `["scan.cooldown"]`, "must be a JSON object"},
+		{"malformed", `{"scan.cooldown": `, "failed to decode"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			fs.Duration("scan.cooldown", 15*time.Second, "")
+			if err := Load(fs, writeConfig(t, test.contents)); err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, err)
+			}
+		})
+	}
+
+	if err := Load(flag.NewFlagSet("test", flag.ContinueOnError), filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Fatal("expected a missing file to fail")
+	}
+}
+
+func TestLoadEnv(t *testing.T) {
+	vars := map[string]string{
+		"api.password":      "TROUBLESHOOTD_API_PASSWORD",
+		"explorer.password": "TROUBLESHOOTD_EXPLORER_PASSWORD",
+		"scan.cooldown":     "TROUBLESHOOTD_SCAN_COOLDOWN",
+	}
+	t.Setenv("TROUBLESHOOTD_API_PASSWORD", "from-env")
+	t.Setenv("TROUBLESHOOTD_EXPLORER_PASSWORD", "from-env")
+	t.Setenv("TROUBLESHOOTD_SCAN_COOLDOWN", "")
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	apiPassword := fs.String("api.password", "", "")
+	explorerPassword := fs.String("explorer.password", "", "")
+	cooldown := fs.Duration("scan.cooldown", 15*time.Second, "")
+	if err := fs.Parse([]string{"-explorer.password", "from-flag"}); err != nil {
+		t.Fatal(err)
+	} else if err := LoadEnv(fs, vars); err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case *apiPassword != "from-env":
+		t.Fatalf("expected the API password from the environment, got %q", *apiPassword)
+	case *explorerPassword != "from-flag":
+		t.Fatalf("expected the flag to override the environment, got %q", *explorerPassword)
+	case *cooldown != 15*time.Second:
+		t.Fatalf("expected an empty variable to be ignored, got %s", *cooldown)
+	}
+
+	t.Setenv("TROUBLESHOOTD_SCAN_COOLDOWN", "soon")
+	if err := LoadEnv(fs, vars); err == nil || !strings.Contains(err.Error(), "TROUBLESHOOTD_SCAN_COOLDOWN") {
+		t.Fatalf("expected an invalid value error, got %v", err)
+	}
+	if err := LoadEnv(fs, map[string]string{"missing": "TROUBLESHOOTD_MISSING"}); err == nil {
+		t.Fatal("expected an unknown flag to fail")
+	}
+}
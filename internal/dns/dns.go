@@ -2,28 +2,57 @@ package dns
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"net"
-	"time"
+	"net/netip"
+	"strings"
 
 	"github.com/miekg/dns"
 )
 
+// DefaultMaxCNAMEDepth is the default maximum number of CNAME records
+// followed when resolving a hostname.
+const DefaultMaxCNAMEDepth = 3
+
 // ErrNotFound is returned when a DNS query does not return any records.
 var ErrNotFound = errors.New("no such host")
 
-func queryRecord(ctx context.Context, server string, hostname string, recordType uint16) ([]string, error) {
-	client := &dns.Client{
-		Net:     "udp",
-		Timeout: 5 * time.Second,
+// exchange sends a query to the DNS server, which may be a plain, DNS over
+// TLS, or DNS over HTTPS server. If subnet is not nil, it is sent as an
+// EDNS0 client subnet option so the server answers as if the query came
+// from that subnet.
+func exchange(ctx context.Context, server string, hostname string, recordType uint16, subnet *net.IPNet) (*dns.Msg, error) {
+	s, err := parseServer(server)
+	if err != nil {
+		return nil, err
 	}
 	m := new(dns.Msg)
 	m.SetQuestion(dns.Fqdn(hostname), recordType)
-	resp, _, err := client.ExchangeContext(ctx, m, server)
-	if err != nil {
-		return nil, err
+	if subnet != nil {
+		ones, _ := subnet.Mask.Size()
+		ecs := &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: uint8(ones),
+			Address:       subnet.IP.To4(),
+		}
+		if ecs.Address == nil {
+			ecs.Family = 2
+			ecs.Address = subnet.IP.To16()
+		}
+		m.SetEdns0(dns.DefaultMsgSize, false)
+		opt := m.IsEdns0()
+		opt.Option = append(opt.Option, ecs)
 	}
+	return send(ctx, s, m)
+}
+
+// answerRecords returns the values of the records in the response's answer
+// section.
+func answerRecords(resp *dns.Msg) ([]string, error) {
 	var results []string
 	for _, answer := range resp.Answer {
 		switch record := answer.(type) {
@@ -33,6 +62,8 @@ func queryRecord(ctx context.Context, server string, hostname string, recordType
 			results = append(results, record.AAAA.String())
 		case *dns.CNAME:
 			results = append(results, record.Target)
+		case *dns.PTR:
+			results = append(results, record.Ptr)
 		default:
 			return nil, fmt.Errorf("unsupported record type: %T", answer)
 		}
@@ -40,19 +71,36 @@ func queryRecord(ctx context.Context, server string, hostname string, recordType
 	return results, nil
 }
 
-func resolve(ctx context.Context, server, hostname string, depth int, maxDepth int) ([]net.IP, error) {
-	if depth > maxDepth {
-		return nil, fmt.Errorf("maximum CNAME resolution depth reached: %d", maxDepth)
+func queryRecord(ctx context.Context, server string, hostname string, recordType uint16) ([]string, error) {
+	resp, err := exchange(ctx, server, hostname, recordType, nil)
+	if err != nil {
+		return nil, err
 	}
+	return answerRecords(resp)
+}
 
-	a, err := queryRecord(ctx, server, hostname, dns.TypeA)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query A records: %w", err)
+// resolve resolves hostname, following CNAME records. The network must be
+// "ip", "ip4", or "ip6". Only A records are queried for "ip4" and only AAAA
+// records for "ip6".
+func resolve(ctx context.Context, server, network, hostname string, depth int, maxDepth int, chain *[]string) ([]net.IP, error) {
+	*chain = append(*chain, hostname)
+	if depth > maxDepth {
+		return nil, fmt.Errorf("CNAME chain exceeds the maximum depth of %d: %s", maxDepth, strings.Join(*chain, " -> "))
 	}
 
-	aaaa, err := queryRecord(ctx, server, hostname, dns.TypeAAAA)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query AAAA records: %w", err)
+	var a, aaaa []string
+	var err error
+	if network != "ip6" {
+		a, err = queryRecord(ctx, server, hostname, dns.TypeA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query A records: %w", err)
+		}
+	}
+	if network != "ip4" {
+		aaaa, err = queryRecord(ctx, server, hostname, dns.TypeAAAA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query AAAA records: %w", err)
+		}
 	}
 
 	records := make([]net.IP, 0, len(a)+len(aaaa))
@@ -76,7 +124,7 @@ func resolve(ctx context.Context, server, hostname string, depth int, maxDepth i
 		return nil, fmt.Errorf("failed to query CNAME records: %w", err)
 	}
 	for _, r := range cname {
-		ips, err := resolve(ctx, server, r, depth+1, maxDepth)
+		ips, err := resolve(ctx, server, network, strings.TrimSuffix(r, "."), depth+1, maxDepth, chain)
 		if err != nil {
 			return nil, fmt.Errorf("failed to resolve CNAME %q: %w", r, err)
 		}
@@ -120,17 +168,218 @@ func QueryAAAA(ctx context.Context, server string, hostname string) ([]string, e
 	return resp, nil
 }
 
+// QueryPTR queries the DNS server for PTR records of the given IP address
+// using its reverse in-addr.arpa or ip6.arpa name.
+func QueryPTR(ctx context.Context, server string, ip string) ([]string, error) {
+	name, err := dns.ReverseAddr(ip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get reverse address: %w", err)
+	}
+	resp, err := queryRecord(ctx, server, name, dns.TypePTR)
+	if err != nil {
+		return nil, err
+	} else if len(resp) == 0 {
+		return nil, ErrNotFound
+	}
+	return resp, nil
+}
+
+// ParseIP parses s as an IP address, returning nil if it is not one. Unlike
+// net.ParseIP, it accepts an IPv6 address enclosed in brackets or with a
+// zone, such as "[fe80::1%eth0]". The zone is not included in the returned
+// IP.
+func ParseIP(s string) net.IP {
+	if strings.HasPrefix(s, "[") && strings.HasSuffix(s, "]") {
+		s = s[1 : len(s)-1]
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return nil
+	}
+	return net.IP(addr.WithZone("").AsSlice())
+}
+
+// literalIP returns the IP address of hostname if it is an IP literal in
+// the given network. It returns ErrNotFound if the literal is in a
+// different network.
+func literalIP(network, hostname string) (net.IP, bool, error) {
+	ip := ParseIP(hostname)
+	switch {
+	case ip == nil:
+		return nil, false, nil
+	case network == "ip4" && ip.To4() == nil, network == "ip6" && ip.To4() != nil:
+		return nil, true, ErrNotFound
+	}
+	return ip, true, nil
+}
+
 // LookupIP resolves the given hostname to its IP addresses using the specified DNS server.
 func LookupIP(ctx context.Context, server, hostname string) ([]net.IP, error) {
-	if ip := net.ParseIP(hostname); ip != nil {
+	ips, _, err := LookupIPChain(ctx, server, "ip", hostname, DefaultMaxCNAMEDepth)
+	return ips, err
+}
+
+// LookupIPChain resolves the given hostname, following at most maxDepth
+// CNAME records. It also returns the chain of hostnames that were
+// resolved, starting with hostname. The network must be "ip", "ip4", or
+// "ip6" to resolve both address families, only IPv4, or only IPv6. IP
+// literals, including bracketed IPv6 addresses, are returned without
+// querying the server.
+func LookupIPChain(ctx context.Context, server, network, hostname string, maxDepth int) ([]net.IP, []string, error) {
+	if ip, ok, err := literalIP(network, hostname); err != nil {
+		return nil, nil, err
+	} else if ok {
 		// If the hostname is already an IP address, return it directly.
-		return []net.IP{ip}, nil
+		return []net.IP{ip}, nil, nil
 	}
-	records, err := resolve(ctx, server, hostname, 0, 3)
+	var chain []string
+	records, err := resolve(ctx, server, network, hostname, 0, maxDepth, &chain)
 	if err != nil {
-		return nil, err
+		return nil, chain, err
 	} else if len(records) == 0 {
-		return nil, ErrNotFound
+		return nil, chain, ErrNotFound
 	}
-	return records, nil
+	return records, chain, nil
+}
+
+// HasWildcard checks whether the zone containing hostname has a wildcard
+// record by resolving a random, nonexistent sibling of hostname. It returns
+// true if the sibling resolves to any of the given IPs. Hostnames without a
+// parent zone, such as "example.com", are never reported as wildcards since a
+// wildcard record cannot match the zone apex.
+func HasWildcard(ctx context.Context, server, hostname string, ips []net.IP) (bool, error) {
+	hostname = strings.TrimSuffix(hostname, ".")
+	_, parent, ok := strings.Cut(hostname, ".")
+	if !ok || !strings.Contains(parent, ".") || ParseIP(hostname) != nil {
+		return false, nil
+	}
+
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return false, fmt.Errorf("failed to generate random label: %w", err)
+	}
+	random := "troubleshootd-" + hex.EncodeToString(buf) + "." + parent
+
+	var chain []string
+	records, err := resolve(ctx, server, "ip", random, 0, DefaultMaxCNAMEDepth, &chain)
+	if err != nil {
+		return false, err
+	}
+	for _, record := range records {
+		for _, ip := range ips {
+			if record.Equal(ip) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// cnameConflictTypes are the record types checked for by CNAMEConflicts.
+var cnameConflictTypes = []uint16{dns.TypeSOA, dns.TypeNS, dns.TypeA, dns.TypeAAAA, dns.TypeMX, dns.TypeTXT}
+
+// CNAMEConflicts returns the types of the records that exist at the same
+// name as the CNAME record for hostname. A CNAME must be the only record
+// for a name, so any conflicts indicate an invalid configuration that
+// some resolvers will reject. Conflicting SOA or NS records mean the CNAME
+// is at the zone apex. It returns nil if hostname has no CNAME record.
+func CNAMEConflicts(ctx context.Context, server, hostname string) ([]string, error) {
+	if _, err := QueryCNAME(ctx, server, hostname); errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to query CNAME records: %w", err)
+	}
+
+	name := dns.Fqdn(hostname)
+	var conflicts []string
+	for _, recordType := range cnameConflictTypes {
+		resp, err := exchange(ctx, server, hostname, recordType, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s records: %w", dns.TypeToString[recordType], err)
+		}
+		// resolvers follow the CNAME, so only records owned by hostname
+		// itself conflict
+		for _, answer := range resp.Answer {
+			hdr := answer.Header()
+			if hdr.Rrtype == recordType && strings.EqualFold(hdr.Name, name) {
+				conflicts = append(conflicts, dns.TypeToString[recordType])
+				break
+			}
+		}
+	}
+	return conflicts, nil
+}
+
+// DNSSECFailure returns true if the server fails to answer queries for
+// hostname's A records because their DNSSEC signatures do not validate. The
+// server must be a validating resolver. A server failure is queried again
+// with checking disabled: if the records are then returned, the failure
+// was caused by validation rather than by the zone's name servers. IP
+// literals are never reported.
+func DNSSECFailure(ctx context.Context, server, hostname string) (bool, error) {
+	if ParseIP(hostname) != nil {
+		return false, nil
+	}
+	s, err := parseServer(server)
+	if err != nil {
+		return false, err
+	}
+	query := func(checkingDisabled bool) (*dns.Msg, error) {
+		m := new(dns.Msg)
+		m.SetQuestion(dns.Fqdn(hostname), dns.TypeA)
+		m.SetEdns0(dns.DefaultMsgSize, true)
+		m.CheckingDisabled = checkingDisabled
+		return send(ctx, s, m)
+	}
+
+	if resp, err := query(false); err != nil {
+		return false, fmt.Errorf("failed to query A records: %w", err)
+	} else if resp.Rcode != dns.RcodeServerFailure {
+		return false, nil
+	}
+	resp, err := query(true)
+	if err != nil {
+		return false, fmt.Errorf("failed to query A records with checking disabled: %w", err)
+	}
+	return resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0, nil
+}
+
+// LookupIPSubnet resolves the given hostname as if the query came from a
+// client in subnet, using the EDNS0 client subnet option. The server must
+// be a resolver that supports the option. It also returns the prefix length
+// the answer applies to, as reported by the server, or -1 if the server did
+// not include the option in its response.
+func LookupIPSubnet(ctx context.Context, server, hostname string, subnet *net.IPNet) (ips []net.IP, scope int, err error) {
+	scope = -1
+	if ip := ParseIP(hostname); ip != nil {
+		return []net.IP{ip}, scope, nil
+	}
+	for _, recordType := range []uint16{dns.TypeA, dns.TypeAAAA} {
+		resp, err := exchange(ctx, server, hostname, recordType, subnet)
+		if err != nil {
+			return nil, -1, fmt.Errorf("failed to query %s records: %w", dns.TypeToString[recordType], err)
+		}
+		records, err := answerRecords(resp)
+		if err != nil {
+			return nil, -1, err
+		}
+		for _, r := range records {
+			// CNAME targets are followed by the resolver
+			if ip := net.ParseIP(r); ip != nil {
+				ips = append(ips, ip)
+			}
+		}
+
+		if opt := resp.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				if ecs, ok := o.(*dns.EDNS0_SUBNET); ok && int(ecs.SourceScope) > scope {
+					scope = int(ecs.SourceScope)
+				}
+			}
+		}
+	}
+	if len(ips) == 0 {
+		return nil, scope, ErrNotFound
+	}
+	return ips, scope, nil
 }
@@ -3,8 +3,13 @@ package dns
 import (
 	"context"
 	"errors"
+	"net"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/miekg/dns"
 )
 
 func TestLookupIP(t *testing.T) {
@@ -30,3 +35,439 @@ func TestLookupIP(t *testing.T) {
 		}
 	})
 }
+
+func TestQueryPTR(t *testing.T) {
+	addr := newTestServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		if q := req.Question[0]; q.Name == "10.113.0.203.in-addr.arpa." && q.Qtype == dns.TypePTR {
+			resp.Answer = append(resp.Answer, &dns.PTR{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: 60},
+				Ptr: "host.sia.test.",
+			})
+		}
+		w.WriteMsg(resp)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	res, err := QueryPTR(ctx, addr, "203.0.113.10")
+	if err != nil {
+		t.Fatal(err)
+	} else if !slices.Equal(res, []string{"host.sia.test."}) {
+		t.Fatalf("expected host.sia.test., got %v", res)
+	}
+
+	if _, err := QueryPTR(ctx, addr, "203.0.113.20"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %q, got %v", ErrNotFound, err)
+	} else if _, err := QueryPTR(ctx, addr, "not an ip"); err == nil {
+		t.Fatal("expected error for invalid IP")
+	}
+}
+
+func TestHasWildcard(t *testing.T) {
+	// every name in example.test resolves, names in sia.test only resolve
+	// if they have a record
+	addr := newTestServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		q := req.Question[0]
+		if q.Qtype == dns.TypeA && (q.Name == "host.sia.test." || strings.HasSuffix(q.Name, ".example.test.")) {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("203.0.113.10"),
+			})
+		}
+		w.WriteMsg(resp)
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	ips := []net.IP{net.ParseIP("203.0.113.10")}
+	tests := []struct {
+		hostname string
+		wildcard bool
+	}{
+		{"host.example.test", true},
+		{"host.sia.test", false},
+		// the zone apex and IP addresses are never wildcards
+		{"example.test", false},
+		{"203.0.113.10", false},
+	}
+	for _, test := range tests {
+		t.Run(test.hostname, func(t *testing.T) {
+			if wildcard, err := HasWildcard(ctx, addr, test.hostname, ips); err != nil && !errors.Is(err, ErrNotFound) {
+				t.Fatal(err)
+			} else if wildcard != test.wildcard {
+				t.Fatalf("expected wildcard %t, got %t", test.wildcard, wildcard)
+			}
+		})
+	}
+
+	// a wildcard that resolves to other addresses is not reported
+	if wildcard, err := HasWildcard(ctx, addr, "host.example.test", []net.IP{net.ParseIP("203.0.113.20")}); err != nil {
+		t.Fatal(err)
+	} else if wildcard {
+		t.Fatal("expected no wildcard for different addresses")
+	}
+}
+
+// newTestServer starts a local DNS server with the given handler and
+// returns its address.
+func newTestServer(t *testing.T, handler dns.HandlerFunc) string {
+	t.Helper()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &dns.Server{
+		PacketConn: pc,
+		Handler:    handler,
+	}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	<-started
+	return pc.LocalAddr().String()
+}
+
+// newECSServer starts a DNS server that answers A queries based on the
+// client subnet option and echoes the option with a scope of 16.
+func newECSServer(t *testing.T) string {
+	t.Helper()
+
+	return newTestServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+
+		ip := "203.0.113.10"
+		if opt := req.IsEdns0(); opt != nil {
+			for _, o := range opt.Option {
+				ecs, ok := o.(*dns.EDNS0_SUBNET)
+				if !ok {
+					continue
+				} else if ecs.Address.Equal(net.ParseIP("198.51.100.0")) {
+					ip = "203.0.113.20"
+				}
+				resp.SetEdns0(dns.DefaultMsgSize, false)
+				resp.IsEdns0().Option = append(resp.IsEdns0().Option, &dns.EDNS0_SUBNET{
+					Code:          dns.EDNS0SUBNET,
+					Family:        ecs.Family,
+					SourceNetmask: ecs.SourceNetmask,
+					SourceScope:   16,
+					Address:       ecs.Address,
+				})
+			}
+		}
+		if req.Question[0].Qtype == dns.TypeA {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: req.Question[0].Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP(ip),
+			})
+		}
+		w.WriteMsg(resp)
+	})
+}
+
+func TestLookupIPSubnet(t *testing.T) {
+	server := newECSServer(t)
+
+	tests := []struct {
+		name   string
+		subnet string
+		ip     string
+		scope  int
+	}{
+		{"default", "", "203.0.113.10", -1},
+		{"other subnet", "192.0.2.0/24", "203.0.113.10", 16},
+		{"geo subnet", "198.51.100.0/24", "203.0.113.20", 16},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			var subnet *net.IPNet
+			if test.subnet != "" {
+				_, subnet, _ = net.ParseCIDR(test.subnet)
+			}
+			ips, scope, err := LookupIPSubnet(ctx, server, "host.sia.tech", subnet)
+			if err != nil {
+				t.Fatal(err)
+			} else if len(ips) != 1 || !ips[0].Equal(net.ParseIP(test.ip)) {
+				t.Fatalf("expected %s, got %v", test.ip, ips)
+			} else if scope != test.scope {
+				t.Fatalf("expected scope %d, got %d", test.scope, scope)
+			}
+		})
+	}
+}
+
+// newCNAMEServer starts a DNS server that answers from the given CNAME and
+// A records.
+func newCNAMEServer(t *testing.T, cnames, addrs map[string]string) string {
+	t.Helper()
+
+	return newTestServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+
+		q := req.Question[0]
+		hdr := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: 60}
+		switch {
+		case q.Qtype == dns.TypeCNAME && cnames[q.Name] != "":
+			resp.Answer = append(resp.Answer, &dns.CNAME{Hdr: hdr, Target: cnames[q.Name]})
+		case q.Qtype == dns.TypeA && addrs[q.Name] != "":
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: hdr, A: net.ParseIP(addrs[q.Name])})
+		}
+		w.WriteMsg(resp)
+	})
+}
+
+func TestLookupIPChain(t *testing.T) {
+	server := newCNAMEServer(t, map[string]string{
+		"host.sia.tech.":  "lb.sia.tech.",
+		"lb.sia.tech.":    "edge.cdn.net.",
+		"edge.cdn.net.":   "origin.cdn.net.",
+		"loop.sia.tech.":  "loop2.sia.tech.",
+		"loop2.sia.tech.": "loop.sia.tech.",
+	}, map[string]string{
+		"origin.cdn.net.":  "203.0.113.10",
+		"direct.sia.tech.": "203.0.113.20",
+	})
+
+	tests := []struct {
+		name     string
+		hostname string
+		maxDepth int
+		ip       string
+		chain    []string
+		err      string
+	}{
+		{"direct", "direct.sia.tech", 3, "203.0.113.20", []string{"direct.sia.tech"}, ""},
+		{"chain", "host.sia.tech", 3, "203.0.113.10", []string{"host.sia.tech", "lb.sia.tech", "edge.cdn.net", "origin.cdn.net"}, ""},
+		{"too deep", "host.sia.tech", 2, "", []string{"host.sia.tech", "lb.sia.tech", "edge.cdn.net", "origin.cdn.net"}, "exceeds the maximum depth of 2"},
+		{"loop", "loop.sia.tech", 4, "", []string{"loop.sia.tech", "loop2.sia.tech", "loop.sia.tech", "loop2.sia.tech", "loop.sia.tech", "loop2.sia.tech"}, "exceeds the maximum depth of 4"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			ips, chain, err := LookupIPChain(ctx, server, "ip", test.hostname, test.maxDepth)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			} else if len(ips) != 1 || !ips[0].Equal(net.ParseIP(test.ip)) {
+				t.Fatalf("expected %s, got %v", test.ip, ips)
+			}
+			if !slices.Equal(chain, test.chain) {
+				t.Fatalf("expected chain %v, got %v", test.chain, chain)
+			}
+		})
+	}
+}
+
+// newRecordServer starts a DNS server that answers with the records
+// matching the question's name and type.
+func newRecordServer(t *testing.T, records ...string) string {
+	t.Helper()
+
+	rrs := make([]dns.RR, 0, len(records))
+	for _, record := range records {
+		rr, err := dns.NewRR(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rrs = append(rrs, rr)
+	}
+	return newTestServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+
+		q := req.Question[0]
+		for _, rr := range rrs {
+			if hdr := rr.Header(); hdr.Name == q.Name && hdr.Rrtype == q.Qtype {
+				resp.Answer = append(resp.Answer, rr)
+			}
+		}
+		w.WriteMsg(resp)
+	})
+}
+
+func TestCNAMEConflicts(t *testing.T) {
+	server := newRecordServer(t,
+		"sia.tech. 60 IN SOA ns1.sia.tech. admin.sia.tech. 1 7200 3600 1209600 300",
+		"sia.tech. 60 IN NS ns1.sia.tech.",
+		"sia.tech. 60 IN CNAME lb.cdn.net.",
+		"host.sia.tech. 60 IN CNAME lb.cdn.net.",
+		"mixed.sia.tech. 60 IN CNAME lb.cdn.net.",
+		"mixed.sia.tech. 60 IN TXT \"v=spf1 -all\"",
+		"direct.sia.tech. 60 IN A 203.0.113.10",
+		"lb.cdn.net. 60 IN A 203.0.113.10",
+	)
+
+	tests := []struct {
+		hostname  string
+		conflicts []string
+	}{
+		{"sia.tech", []string{"SOA", "NS"}},
+		{"host.sia.tech", nil},
+		{"mixed.sia.tech", []string{"TXT"}},
+		{"direct.sia.tech", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.hostname, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			conflicts, err := CNAMEConflicts(ctx, server, test.hostname)
+			if err != nil {
+				t.Fatal(err)
+			} else if !slices.Equal(conflicts, test.conflicts) {
+				t.Fatalf("expected conflicts %v, got %v", test.conflicts, conflicts)
+			}
+		})
+	}
+}
+
+func TestLookupIPChainNetwork(t *testing.T) {
+	server := newRecordServer(t,
+		"host.sia.tech. 60 IN A 203.0.113.10",
+		"host.sia.tech. 60 IN AAAA 2001:db8::10",
+	)
+
+	tests := []struct {
+		network string
+		ips     []string
+	}{
+		{"ip", []string{"203.0.113.10", "2001:db8::10"}},
+		{"ip4", []string{"203.0.113.10"}},
+		{"ip6", []string{"2001:db8::10"}},
+	}
+	for _, test := range tests {
+		t.Run(test.network, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			ips, _, err := LookupIPChain(ctx, server, test.network, "host.sia.tech", DefaultMaxCNAMEDepth)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, ip := range ips {
+				got = append(got, ip.String())
+			}
+			if !slices.Equal(got, test.ips) {
+				t.Fatalf("expected %v, got %v", test.ips, got)
+			}
+		})
+	}
+}
+
+func TestDNSSECFailure(t *testing.T) {
+	// bogus.sia.tech has invalid signatures and the name servers for
+	// broken.sia.tech fail whether or not the answer is validated
+	server := newTestServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+
+		q := req.Question[0]
+		switch {
+		case q.Name == "bogus.sia.tech." && !req.CheckingDisabled:
+			resp.Rcode = dns.RcodeServerFailure
+		case q.Name == "broken.sia.tech.":
+			resp.Rcode = dns.RcodeServerFailure
+		case q.Qtype == dns.TypeA:
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("203.0.113.10")})
+		}
+		w.WriteMsg(resp)
+	})
+
+	tests := []struct {
+		hostname string
+		failure  bool
+	}{
+		{"host.sia.tech", false},
+		{"bogus.sia.tech", true},
+		{"broken.sia.tech", false},
+	}
+	for _, test := range tests {
+		t.Run(test.hostname, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			failure, err := DNSSECFailure(ctx, server, test.hostname)
+			if err != nil {
+				t.Fatal(err)
+			} else if failure != test.failure {
+				t.Fatalf("expected failure %v, got %v", test.failure, failure)
+			}
+		})
+	}
+}
+
+func TestParseIP(t *testing.T) {
+	tests := []struct {
+		s        string
+		expected string
+	}{
+		{"203.0.113.10", "203.0.113.10"},
+		{"2001:db8::1", "2001:db8::1"},
+		{"[2001:db8::1]", "2001:db8::1"},
+		{"fe80::1%eth0", "fe80::1"},
+		{"[fe80::1%eth0]", "fe80::1"},
+		{"::ffff:203.0.113.10", "203.0.113.10"},
+		{"[203.0.113.10", ""},
+		{"[2001:db8::1]:9984", ""},
+		{"203.0.113.10%eth0", ""},
+		{"host.sia.tech", ""},
+		{"", ""},
+	}
+	for _, test := range tests {
+		ip := ParseIP(test.s)
+		if test.expected == "" && ip != nil {
+			t.Errorf("expected %q not to parse, got %v", test.s, ip)
+		} else if test.expected != "" && (ip == nil || !ip.Equal(net.ParseIP(test.expected))) {
+			t.Errorf("expected %q to parse as %s, got %v", test.s, test.expected, ip)
+		}
+	}
+}
+
+func TestLookupIPChainLiteral(t *testing.T) {
+	// literals are returned without querying the server
+	const server = "127.0.0.1:1"
+
+	tests := []struct {
+		network  string
+		hostname string
+		expected string
+		err      error
+	}{
+		{"ip", "203.0.113.10", "203.0.113.10", nil},
+		{"ip", "[2001:db8::1]", "2001:db8::1", nil},
+		{"ip6", "[fe80::1%eth0]", "fe80::1", nil},
+		{"ip4", "[2001:db8::1]", "", ErrNotFound},
+		{"ip6", "203.0.113.10", "", ErrNotFound},
+	}
+	for _, test := range tests {
+		ips, chain, err := LookupIPChain(context.Background(), server, test.network, test.hostname, DefaultMaxCNAMEDepth)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("expected %v for %s %q, got %v", test.err, test.network, test.hostname, err)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("expected %s %q to resolve, got %v", test.network, test.hostname, err)
+		} else if len(ips) != 1 || !ips[0].Equal(net.ParseIP(test.expected)) || chain != nil {
+			t.Errorf("expected %s for %s %q, got %v %v", test.expected, test.network, test.hostname, ips, chain)
+		}
+	}
+}
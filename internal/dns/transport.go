@@ -0,0 +1,188 @@
+package dns
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.sia.tech/troubleshootd/build"
+)
+
+const (
+	// exchangeTimeout is the maximum duration of a single query.
+	exchangeTimeout = 5 * time.Second
+
+	// defaultTLSPort is the port DNS over TLS servers listen on if the
+	// server address does not specify one.
+	defaultTLSPort = "853"
+
+	tlsScheme = "tls://"
+	dohMIME   = "application/dns-message"
+)
+
+// httpClient sends DNS over HTTPS queries.
+var httpClient = &http.Client{Timeout: exchangeTimeout}
+
+// A DialControl is called with the network and address of each connection
+// made to a DNS server before it is established. Returning an error aborts
+// the connection.
+type DialControl func(network, address string, c syscall.RawConn) error
+
+type dialControlKey struct{}
+
+// WithDialControl returns a context that runs control before every
+// connection made to a DNS server while querying with it.
+func WithDialControl(ctx context.Context, control DialControl) context.Context {
+	return context.WithValue(ctx, dialControlKey{}, control)
+}
+
+// dialControl returns the context's DialControl, or nil if it has none.
+func dialControl(ctx context.Context) DialControl {
+	control, _ := ctx.Value(dialControlKey{}).(DialControl)
+	return control
+}
+
+// controlledClient returns a copy of httpClient that runs control before
+// every connection. Requests are never proxied, since the proxy's address
+// would be checked instead of the server's.
+func controlledClient(control DialControl) *http.Client {
+	transport, ok := httpClient.Transport.(*http.Transport)
+	if !ok {
+		transport = http.DefaultTransport.(*http.Transport)
+	}
+	transport = transport.Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{Timeout: exchangeTimeout, Control: control}).DialContext
+	return &http.Client{Timeout: httpClient.Timeout, Transport: transport}
+}
+
+// A server is a parsed DNS server address.
+type server struct {
+	// network is "udp", "tcp-tls", or "https"
+	network string
+	// addr is the server's host and port, or its URL for DNS over HTTPS
+	addr string
+	// serverName is the name the server's TLS certificate is verified
+	// against
+	serverName string
+}
+
+// parseServer parses a DNS server address. Plain DNS servers are given as
+// "host:port", DNS over TLS servers as "tls://host[:port]", and DNS over
+// HTTPS servers as an "https://" URL.
+func parseServer(s string) (server, error) {
+	switch {
+	case strings.HasPrefix(s, "https://"):
+		u, err := url.Parse(s)
+		if err != nil {
+			return server{}, fmt.Errorf("invalid DNS over HTTPS URL %q: %w", s, err)
+		} else if u.Host == "" {
+			return server{}, fmt.Errorf("invalid DNS over HTTPS URL %q: missing host", s)
+		}
+		return server{network: "https", addr: s}, nil
+	case strings.HasPrefix(s, tlsScheme):
+		addr := strings.TrimPrefix(s, tlsScheme)
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			// the port is optional
+			host = strings.Trim(addr, "[]")
+			addr = net.JoinHostPort(host, defaultTLSPort)
+		}
+		if host == "" || strings.ContainsAny(host, "/[]") {
+			return server{}, fmt.Errorf("invalid DNS over TLS server %q", s)
+		}
+		return server{network: "tcp-tls", addr: addr, serverName: host}, nil
+	case strings.Contains(s, "://"):
+		return server{}, fmt.Errorf("unsupported DNS server %q: must be host:port, tls://host[:port], or an https:// URL", s)
+	}
+
+	if host, _, err := net.SplitHostPort(s); err != nil {
+		return server{}, fmt.Errorf("invalid DNS server %q: %w", s, err)
+	} else if host == "" {
+		return server{}, fmt.Errorf("invalid DNS server %q: missing host", s)
+	}
+	return server{network: "udp", addr: s}, nil
+}
+
+// ValidateServer returns an error if s is not a valid DNS server address.
+// Plain DNS servers are given as "host:port", DNS over TLS servers as
+// "tls://host[:port]", and DNS over HTTPS servers as an "https://" URL.
+func ValidateServer(s string) error {
+	_, err := parseServer(s)
+	return err
+}
+
+// exchangeHTTPS sends the query to a DNS over HTTPS server as described in
+// RFC 8484.
+func exchangeHTTPS(ctx context.Context, endpoint string, m *dns.Msg) (*dns.Msg, error) {
+	// the ID should be zero so that responses can be cached
+	m.Id = 0
+	buf, err := m.Pack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to pack query: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, exchangeTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(buf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", dohMIME)
+	req.Header.Set("Accept", dohMIME)
+	req.Header.Set("User-Agent", build.UserAgent())
+
+	client := httpClient
+	if control := dialControl(ctx); control != nil {
+		client = controlledClient(control)
+		defer client.CloseIdleConnections()
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("DNS over HTTPS server returned %s", resp.Status)
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, dns.MaxMsgSize))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	reply := new(dns.Msg)
+	if err := reply.Unpack(body); err != nil {
+		return nil, fmt.Errorf("failed to unpack response: %w", err)
+	} else if q := m.Question[0]; len(reply.Question) == 0 || !strings.EqualFold(reply.Question[0].Name, q.Name) || reply.Question[0].Qtype != q.Qtype {
+		return nil, errors.New("DNS over HTTPS server answered a different question")
+	}
+	return reply, nil
+}
+
+// send sends the query to the server using the server's transport.
+func send(ctx context.Context, s server, m *dns.Msg) (*dns.Msg, error) {
+	if s.network == "https" {
+		return exchangeHTTPS(ctx, s.addr, m)
+	}
+	client := &dns.Client{
+		Net:     s.network,
+		Timeout: exchangeTimeout,
+	}
+	if control := dialControl(ctx); control != nil {
+		client.Dialer = &net.Dialer{Timeout: exchangeTimeout, Control: control}
+	}
+	if s.network == "tcp-tls" {
+		client.TLSConfig = &tls.Config{ServerName: s.serverName}
+	}
+	resp, _, err := client.ExchangeContext(ctx, m, s.addr)
+	return resp, err
+}
@@ -0,0 +1,157 @@
+package dns
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+	"go.sia.tech/troubleshootd/build"
+)
+
+func TestParseServer(t *testing.T) {
+	tests := []struct {
+		server     string
+		network    string
+		addr       string
+		serverName string
+		err        bool
+	}{
+		{"1.1.1.1:53", "udp", "1.1.1.1:53", "", false},
+		{"[2606:4700:4700::1111]:53", "udp", "[2606:4700:4700::1111]:53", "", false},
+		{"tls://1.1.1.1", "tcp-tls", "1.1.1.1:853", "1.1.1.1", false},
+		{"tls://dns.quad9.net:8853", "tcp-tls", "dns.quad9.net:8853", "dns.quad9.net", false},
+		{"tls://[2606:4700:4700::1111]", "tcp-tls", "[2606:4700:4700::1111]:853", "2606:4700:4700::1111", false},
+		{"https://cloudflare-dns.com/dns-query", "https", "https://cloudflare-dns.com/dns-query", "", false},
+		{"1.1.1.1", "", "", "", true},
+		{":53", "", "", "", true},
+		{"tls://", "", "", "", true},
+		{"https:///dns-query", "", "", "", true},
+		{"http://cloudflare-dns.com/dns-query", "", "", "", true},
+		{"quic://dns.adguard.com", "", "", "", true},
+	}
+	for _, test := range tests {
+		s, err := parseServer(test.server)
+		if test.err {
+			if err == nil {
+				t.Errorf("expected %q to be invalid", test.server)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("expected %q to be valid, got %v", test.server, err)
+		} else if s.network != test.network || s.addr != test.addr || s.serverName != test.serverName {
+			t.Errorf("expected %q to parse as %q %q %q, got %+v", test.server, test.network, test.addr, test.serverName, s)
+		}
+	}
+}
+
+// newDoHServer starts a DNS over HTTPS server that answers A queries for
+// host.sia.tech and returns its URL.
+func newDoHServer(t *testing.T) string {
+	t.Helper()
+
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != dohMIME {
+			http.Error(w, "expected a DNS message", http.StatusUnsupportedMediaType)
+			return
+		} else if ua := r.Header.Get("User-Agent"); ua != build.UserAgent() {
+			t.Errorf("expected User-Agent %q, got %q", build.UserAgent(), ua)
+		}
+		buf, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req := new(dns.Msg)
+		if err := req.Unpack(buf); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		if q := req.Question[0]; q.Name == "host.sia.tech." && q.Qtype == dns.TypeA {
+			resp.Answer = append(resp.Answer, &dns.A{
+				Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60},
+				A:   net.ParseIP("203.0.113.10"),
+			})
+		} else if q.Name == "fail.sia.tech." {
+			http.Error(w, "upstream failure", http.StatusBadGateway)
+			return
+		}
+		buf, err = resp.Pack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", dohMIME)
+		w.Write(buf)
+	}))
+	t.Cleanup(srv.Close)
+
+	// trust the test server's certificate
+	prev := httpClient
+	httpClient = srv.Client()
+	t.Cleanup(func() { httpClient = prev })
+	return srv.URL + "/dns-query"
+}
+
+func TestDNSOverHTTPS(t *testing.T) {
+	server := newDoHServer(t)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips, err := LookupIP(ctx, server, "host.sia.tech")
+	if err != nil {
+		t.Fatal(err)
+	} else if len(ips) != 1 || !ips[0].Equal(net.ParseIP("203.0.113.10")) {
+		t.Fatalf("expected 203.0.113.10, got %v", ips)
+	}
+
+	if _, err := LookupIP(ctx, server, "unknown.sia.tech"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %q, got %v", ErrNotFound, err)
+	} else if _, err := LookupIP(ctx, server, "fail.sia.tech"); err == nil || !strings.Contains(err.Error(), "502 Bad Gateway") {
+		t.Fatalf("expected the server's status, got %v", err)
+	}
+}
+
+func TestDialControl(t *testing.T) {
+	udp := newTestServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		if q := req.Question[0]; q.Qtype == dns.TypeA {
+			resp.Answer = append(resp.Answer, &dns.A{Hdr: dns.RR_Header{Name: q.Name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: 60}, A: net.ParseIP("203.0.113.10")})
+		}
+		w.WriteMsg(resp)
+	})
+	doh := newDoHServer(t)
+
+	errDenied := errors.New("denied")
+	for _, server := range []string{udp, doh} {
+		var dialed []string
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		ctx = WithDialControl(ctx, func(network, address string, _ syscall.RawConn) error {
+			dialed = append(dialed, address)
+			return nil
+		})
+		if _, err := LookupIP(ctx, server, "host.sia.tech"); err != nil {
+			t.Fatal(err)
+		} else if len(dialed) == 0 || !strings.HasPrefix(dialed[0], "127.0.0.1:") {
+			t.Fatalf("expected the control to see the server's address, got %v", dialed)
+		}
+
+		ctx = WithDialControl(ctx, func(string, string, syscall.RawConn) error { return errDenied })
+		if _, err := LookupIP(ctx, server, "host.sia.tech"); !errors.Is(err, errDenied) {
+			t.Fatalf("expected %q, got %v", errDenied, err)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package logfile
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// A Writer writes to a log file, rotating it when it reaches a maximum
+// size. Rotated files are renamed with a numeric suffix, with ".1" being
+// the most recent, and only a limited number of them are kept.
+type Writer struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+// rotate renames the current file to the first backup, shifting the
+// existing backups and removing the oldest, then opens a new file.
+func (w *Writer) rotate() error {
+	if err := w.f.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+
+	if w.maxBackups <= 0 {
+		if err := os.Remove(w.path); err != nil {
+			return fmt.Errorf("failed to remove log file: %w", err)
+		}
+	} else {
+		for i := w.maxBackups - 1; i > 0; i-- {
+			err := os.Rename(backupPath(w.path, i), backupPath(w.path, i+1))
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("failed to rotate log file: %w", err)
+			}
+		}
+		if err := os.Rename(w.path, backupPath(w.path, 1)); err != nil {
+			return fmt.Errorf("failed to rotate log file: %w", err)
+		}
+	}
+
+	f, err := os.OpenFile(w.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	w.f, w.size = f, 0
+	return nil
+}
+
+// Write implements io.Writer. The file is rotated before the write if it
+// would exceed the maximum size.
+func (w *Writer) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.size > 0 && w.size+int64(len(p)) > w.maxSize {
+		if err := w.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := w.f.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+// Sync commits the file's contents to disk.
+func (w *Writer) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Sync()
+}
+
+// Close closes the file.
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.f.Close()
+}
+
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// Open opens the log file at path for appending, creating it if it does
+// not exist. The file is rotated when it would exceed maxSize bytes and at
+// most maxBackups rotated files are kept.
+func Open(path string, maxSize int64, maxBackups int) (*Writer, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("failed to stat log file: %w", err)
+	}
+	return &Writer{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+
+		f:    f,
+		size: info.Size(),
+	}, nil
+}
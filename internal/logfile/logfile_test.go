@@ -0,0 +1,63 @@
+package logfile
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "troubleshootd.log")
+	w, err := Open(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	readFile := func(path string) string {
+		t.Helper()
+		buf, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf)
+	}
+
+	if _, err := w.Write([]byte("line one\n")); err != nil {
+		t.Fatal(err)
+	} else if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	} else if contents := readFile(path); contents != "line one\n" {
+		t.Fatalf("expected file to contain the first line, got %q", contents)
+	}
+
+	// each write exceeds the maximum size, so the file is rotated before
+	// every write
+	for _, line := range []string{"line two\n", "line three\n", "line four\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if contents := readFile(path); contents != "line four\n" {
+		t.Fatalf("expected current file to contain the last line, got %q", contents)
+	} else if contents := readFile(path + ".1"); contents != "line three\n" {
+		t.Fatalf("expected first backup to contain the previous line, got %q", contents)
+	} else if contents := readFile(path + ".2"); contents != "line two\n" {
+		t.Fatalf("expected second backup to contain the oldest kept line, got %q", contents)
+	} else if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Fatalf("expected only 2 backups to be kept, got %v", err)
+	}
+
+	// reopening appends to the existing file
+	w.Close()
+	w, err = Open(path, 100, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write([]byte("line five\n")); err != nil {
+		t.Fatal(err)
+	} else if contents := readFile(path); contents != "line four\nline five\n" {
+		t.Fatalf("expected line to be appended, got %q", contents)
+	}
+}
@@ -0,0 +1,218 @@
+package rdap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.sia.tech/troubleshootd/build"
+)
+
+const (
+	// DefaultBaseURL is a bootstrap server that redirects queries to the
+	// registry responsible for the address.
+	DefaultBaseURL = "https://rdap.org"
+
+	cacheTTL     = 24 * time.Hour
+	maxCacheSize = 1024
+)
+
+// ErrNotFound is returned when the registry has no record of the address.
+var ErrNotFound = errors.New("network not found")
+
+type (
+	// A Network is the registration of the network containing an IP
+	// address.
+	Network struct {
+		Name         string
+		Organization string
+		AbuseContact string
+
+		StartAddress net.IP
+		EndAddress   net.IP
+	}
+
+	entity struct {
+		Roles      []string          `json:"roles"`
+		VCardArray []json.RawMessage `json:"vcardArray"`
+		Entities   []entity          `json:"entities"`
+	}
+
+	ipNetwork struct {
+		Name         string   `json:"name"`
+		StartAddress string   `json:"startAddress"`
+		EndAddress   string   `json:"endAddress"`
+		Entities     []entity `json:"entities"`
+	}
+
+	cacheEntry struct {
+		network Network
+		expires time.Time
+	}
+
+	// A Client looks up IP network registrations using RDAP. Results are
+	// cached per network so that addresses in the same network only
+	// require one query.
+	Client struct {
+		baseURL string
+		client  *http.Client
+
+		mu    sync.Mutex
+		cache []cacheEntry
+	}
+)
+
+// vcardField returns the value of the named property in a jCard.
+func (e entity) vcardField(name string) string {
+	if len(e.VCardArray) != 2 {
+		return ""
+	}
+	var props [][]json.RawMessage
+	if err := json.Unmarshal(e.VCardArray[1], &props); err != nil {
+		return ""
+	}
+	for _, prop := range props {
+		if len(prop) < 4 {
+			continue
+		}
+		var key, value string
+		if json.Unmarshal(prop[0], &key) != nil || key != name {
+			continue
+		} else if json.Unmarshal(prop[3], &value) == nil {
+			return value
+		}
+	}
+	return ""
+}
+
+func (e entity) hasRole(role string) bool {
+	for _, r := range e.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// findEntity returns the first entity with the given role. Registries may
+// nest entities, so they are searched recursively.
+func findEntity(entities []entity, role string) (entity, bool) {
+	for _, e := range entities {
+		if e.hasRole(role) {
+			return e, true
+		}
+	}
+	for _, e := range entities {
+		if found, ok := findEntity(e.Entities, role); ok {
+			return found, true
+		}
+	}
+	return entity{}, false
+}
+
+func (n ipNetwork) toNetwork() (Network, error) {
+	network := Network{
+		Name:         n.Name,
+		StartAddress: net.ParseIP(n.StartAddress),
+		EndAddress:   net.ParseIP(n.EndAddress),
+	}
+	if network.StartAddress == nil || network.EndAddress == nil {
+		return Network{}, fmt.Errorf("invalid network range %q - %q", n.StartAddress, n.EndAddress)
+	}
+	if e, ok := findEntity(n.Entities, "registrant"); ok {
+		network.Organization = e.vcardField("fn")
+	}
+	if e, ok := findEntity(n.Entities, "abuse"); ok {
+		network.AbuseContact = e.vcardField("email")
+	}
+	return network, nil
+}
+
+// Contains returns true if ip is within the network's range.
+func (n Network) Contains(ip net.IP) bool {
+	ip = ip.To16()
+	return ip != nil && bytes.Compare(ip, n.StartAddress.To16()) >= 0 && bytes.Compare(ip, n.EndAddress.To16()) <= 0
+}
+
+func (c *Client) cached(ip net.IP) (Network, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for _, entry := range c.cache {
+		if now.Before(entry.expires) && entry.network.Contains(ip) {
+			return entry.network, true
+		}
+	}
+	return Network{}, false
+}
+
+func (c *Client) addCache(network Network) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// drop expired entries, then the oldest entries if the cache is full
+	now := time.Now()
+	entries := c.cache[:0]
+	for _, entry := range c.cache {
+		if now.Before(entry.expires) {
+			entries = append(entries, entry)
+		}
+	}
+	if len(entries) >= maxCacheSize {
+		entries = entries[len(entries)-maxCacheSize+1:]
+	}
+	c.cache = append(entries, cacheEntry{network: network, expires: now.Add(cacheTTL)})
+}
+
+// Lookup returns the registration of the network containing ip.
+func (c *Client) Lookup(ctx context.Context, ip net.IP) (Network, error) {
+	if network, ok := c.cached(ip); ok {
+		return network, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/ip/"+ip.String(), nil)
+	if err != nil {
+		return Network{}, err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	req.Header.Set("User-Agent", build.UserAgent())
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return Network{}, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return Network{}, ErrNotFound
+	case resp.StatusCode != http.StatusOK:
+		return Network{}, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+
+	var n ipNetwork
+	if err := json.NewDecoder(resp.Body).Decode(&n); err != nil {
+		return Network{}, fmt.Errorf("failed to decode response: %w", err)
+	}
+	network, err := n.toNetwork()
+	if err != nil {
+		return Network{}, err
+	}
+	c.addCache(network)
+	return network, nil
+}
+
+// NewClient returns a new RDAP client using the given server.
+func NewClient(baseURL string) *Client {
+	return &Client{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
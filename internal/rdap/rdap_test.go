@@ -0,0 +1,97 @@
+package rdap
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"go.sia.tech/troubleshootd/build"
+)
+
+const testResponse = `{
+	"objectClassName": "ip network",
+	"handle": "NET-203-0-113-0-1",
+	"startAddress": "203.0.113.0",
+	"endAddress": "203.0.113.255",
+	"name": "TEST-NET-3",
+	"entities": [
+		{
+			"objectClassName": "entity",
+			"roles": ["registrant"],
+			"vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Example Hosting"]]],
+			"entities": [
+				{
+					"objectClassName": "entity",
+					"roles": ["abuse"],
+					"vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Abuse"], ["email", {}, "text", "abuse@example.com"]]]
+				}
+			]
+		}
+	]
+}`
+
+func TestLookup(t *testing.T) {
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if ua := r.Header.Get("User-Agent"); ua != build.UserAgent() {
+			t.Errorf("expected User-Agent %q, got %q", build.UserAgent(), ua)
+		}
+		if r.URL.Path != "/ip/203.0.113.10" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/rdap+json")
+		w.Write([]byte(testResponse))
+	}))
+	t.Cleanup(srv.Close)
+
+	c := NewClient(srv.URL)
+	network, err := c.Lookup(context.Background(), net.ParseIP("203.0.113.10"))
+	if err != nil {
+		t.Fatal(err)
+	} else if network.Name != "TEST-NET-3" {
+		t.Fatalf("expected name %q, got %q", "TEST-NET-3", network.Name)
+	} else if network.Organization != "Example Hosting" {
+		t.Fatalf("expected organization %q, got %q", "Example Hosting", network.Organization)
+	} else if network.AbuseContact != "abuse@example.com" {
+		t.Fatalf("expected abuse contact %q, got %q", "abuse@example.com", network.AbuseContact)
+	}
+
+	// addresses in the same network should be served from the cache
+	if _, err := c.Lookup(context.Background(), net.ParseIP("203.0.113.200")); err != nil {
+		t.Fatal(err)
+	} else if n := requests.Load(); n != 1 {
+		t.Fatalf("expected 1 request, got %d", n)
+	}
+
+	// addresses outside of the network should not
+	if _, err := c.Lookup(context.Background(), net.ParseIP("198.51.100.1")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %q, got %v", ErrNotFound, err)
+	} else if n := requests.Load(); n != 2 {
+		t.Fatalf("expected 2 requests, got %d", n)
+	}
+}
+
+func TestNetworkContains(t *testing.T) {
+	n := Network{StartAddress: net.ParseIP("203.0.113.0"), EndAddress: net.ParseIP("203.0.113.255")}
+	tests := []struct {
+		ip       string
+		expected bool
+	}{
+		{"203.0.113.0", true},
+		{"203.0.113.255", true},
+		{"203.0.112.255", false},
+		{"203.0.114.0", false},
+		{"2001:db8::1", false},
+	}
+	for _, test := range tests {
+		if n.Contains(net.ParseIP(test.ip)) != test.expected {
+			t.Fatalf("expected Contains(%s) to be %t", test.ip, test.expected)
+		}
+	}
+}
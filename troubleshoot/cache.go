@@ -0,0 +1,79 @@
+package troubleshoot
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const defaultResultCacheTTL = time.Minute
+
+// A resultCache stores the most recent result of each test request so that
+// repeat requests can be answered without testing the host again.
+type resultCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	results map[string]cachedResult
+}
+
+type cachedResult struct {
+	result   Result
+	cachedAt time.Time
+}
+
+// resultCacheKey identifies a test request by the host and the parameters
+// that affect its result.
+func resultCacheKey(host Host) string {
+	host.DryRun, host.Force = false, false
+	// Host only contains types that can be marshaled
+	buf, _ := json.Marshal(host)
+	return string(buf)
+}
+
+// get returns the cached result for the key if it is still fresh. A nil
+// cache never has a result.
+func (c *resultCache) get(key string) (Result, bool) {
+	if c == nil || c.ttl <= 0 {
+		return Result{}, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.results[key]
+	if !ok || time.Since(cached.cachedAt) > c.ttl {
+		return Result{}, false
+	}
+	res := cached.result
+	res.Cached = true
+	res.CachedAt = cached.cachedAt
+	return res, true
+}
+
+// add caches the result for the key, replacing any previous result. Expired
+// results are removed so that the cache only grows with the number of
+// requests within the TTL.
+func (c *resultCache) add(key string, res Result) {
+	if c == nil || c.ttl <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for k, cached := range c.results {
+		if now.Sub(cached.cachedAt) > c.ttl {
+			delete(c.results, k)
+		}
+	}
+	c.results[key] = cachedResult{result: res, cachedAt: now}
+}
+
+func newResultCache(ttl time.Duration) *resultCache {
+	return &resultCache{
+		ttl:     ttl,
+		results: make(map[string]cachedResult),
+	}
+}
@@ -0,0 +1,133 @@
+package troubleshoot
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+	"go.sia.tech/coreutils/threadgroup"
+	"go.uber.org/zap"
+)
+
+func TestResultCache(t *testing.T) {
+	host := Host{
+		PublicKey:        types.GeneratePrivateKey().PublicKey(),
+		RHP4NetAddresses: []chain.NetAddress{{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}},
+	}
+	key := resultCacheKey(host)
+
+	c := newResultCache(time.Minute)
+	if _, ok := c.get(key); ok {
+		t.Fatal("expected a miss")
+	}
+	c.add(key, Result{PublicKey: host.PublicKey, Version: "1"})
+	if res, ok := c.get(key); !ok {
+		t.Fatal("expected a hit")
+	} else if !res.Cached || res.CachedAt.IsZero() || res.Version != "1" {
+		t.Fatalf("expected cached result, got %+v", res)
+	}
+
+	// forcing a test does not change which result it replaces
+	forced := host
+	forced.Force = true
+	if resultCacheKey(forced) != key {
+		t.Fatal("expected force to be ignored by the key")
+	}
+
+	// a different set of addresses is a different request
+	other := host
+	other.RHP4NetAddresses = []chain.NetAddress{{Protocol: siamux.Protocol, Address: "other.sia.tech:9984"}}
+	if _, ok := c.get(resultCacheKey(other)); ok {
+		t.Fatal("expected a miss for different addresses")
+	}
+
+	// expired results are not returned
+	expired := newResultCache(time.Millisecond)
+	expired.add(key, Result{})
+	time.Sleep(5 * time.Millisecond)
+	if _, ok := expired.get(key); ok {
+		t.Fatal("expected an expired result to miss")
+	}
+	expired.add(resultCacheKey(other), Result{})
+	if len(expired.results) != 1 {
+		t.Fatalf("expected expired results to be removed, got %d", len(expired.results))
+	}
+
+	// results are not cached if the cache is disabled
+	for _, c := range []*resultCache{nil, newResultCache(0)} {
+		c.add(key, Result{})
+		if _, ok := c.get(key); ok {
+			t.Fatal("expected a disabled cache to miss")
+		}
+	}
+}
+
+func TestTestHostCache(t *testing.T) {
+	m := &Manager{
+		tg:       threadgroup.New(),
+		log:      zap.NewNop(),
+		explorer: mockExplorer{},
+		scanSem:  make(chan struct{}, defaultMaxConcurrentScans),
+		cooldown: make(map[types.PublicKey]time.Time),
+	}
+	WithResultCacheTTL(time.Minute)(m)
+	WithCooldown(time.Minute)(m)
+
+	host := Host{PublicKey: types.GeneratePrivateKey().PublicKey()}
+	first, err := m.TestHost(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	} else if first.Cached {
+		t.Fatal("expected the first test not to be cached")
+	}
+
+	// a repeat request within the cooldown is served from the cache
+	ctx := WithRequestID(context.Background(), "repeat")
+	cached, err := m.TestHost(ctx, host)
+	if err != nil {
+		t.Fatal(err)
+	} else if !cached.Cached || cached.CachedAt.Before(first.ScannedAt) {
+		t.Fatalf("expected a cached result, got %+v", cached)
+	} else if !cached.ScannedAt.Equal(first.ScannedAt) {
+		t.Fatalf("expected the original scan time %s, got %s", first.ScannedAt, cached.ScannedAt)
+	} else if cached.RequestID != "repeat" {
+		t.Fatalf("expected the repeat request's ID, got %q", cached.RequestID)
+	}
+	if n := m.Cooldown(host); n != 0 {
+		t.Fatalf("expected a cached request to ignore the cooldown, got %s", n)
+	}
+
+	// forcing a test still respects the cooldown
+	forced := host
+	forced.Force = true
+	if n := m.Cooldown(forced); n <= 0 {
+		t.Fatalf("expected a forced request to be on cooldown, got %s", n)
+	}
+	var ce *CooldownError
+	if _, err := m.TestHost(context.Background(), forced); !errors.As(err, &ce) {
+		t.Fatalf("expected cooldown error, got %v", err)
+	}
+
+	// a request with different parameters misses the cache
+	other := host
+	other.Protocols = []chain.Protocol{siamux.Protocol}
+	if _, err := m.TestHost(context.Background(), other); !errors.As(err, &ce) {
+		t.Fatalf("expected cooldown error, got %v", err)
+	}
+
+	// once the cooldown expires, a forced test replaces the cached result
+	delete(m.cooldown, host.PublicKey)
+	if result, err := m.TestHost(context.Background(), forced); err != nil {
+		t.Fatal(err)
+	} else if result.Cached {
+		t.Fatal("expected a forced test not to be cached")
+	} else if cached, err := m.TestHost(context.Background(), host); err != nil {
+		t.Fatal(err)
+	} else if !cached.Cached || !cached.ScannedAt.Equal(result.ScannedAt) {
+		t.Fatalf("expected the forced result to replace the cached result, got %+v", cached)
+	}
+}
@@ -0,0 +1,109 @@
+package troubleshoot
+
+import (
+	"strconv"
+
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+)
+
+// unknownValue is used in place of a value that could not be determined,
+// such as the settings of a host that could not be scanned.
+const unknownValue = "unknown"
+
+// A Difference is a field that differs between two results.
+type Difference struct {
+	Field string `json:"field"`
+	A     string `json:"a"`
+	B     string `json:"b"`
+}
+
+// settings returns the settings and pricing of the first address that
+// was scanned.
+func (r Result) settings() (*proto4.HostSettings, *Pricing) {
+	for _, res := range r.RHP4 {
+		if res.Settings != nil {
+			return res.Settings, res.Pricing
+		}
+	}
+	return nil, nil
+}
+
+// reachable returns "true" if any address using the protocol was reached,
+// "false" if none were, and unknownValue if the protocol was not tested.
+func (r Result) reachable(proto chain.Protocol) string {
+	tested := false
+	for _, res := range r.RHP4 {
+		if res.NetAddress.Protocol != proto || res.Skipped {
+			continue
+		} else if res.reached() {
+			return "true"
+		}
+		tested = true
+	}
+	if !tested {
+		return unknownValue
+	}
+	return "false"
+}
+
+// A comparedField is a named value compared between results.
+type comparedField struct {
+	name  string
+	value string
+}
+
+// comparedFields returns the values compared by [Compare] in a stable
+// order.
+func (r Result) comparedFields() []comparedField {
+	settings, pricing := r.settings()
+	var s proto4.HostSettings
+	if settings != nil {
+		s = *settings
+	}
+	var p Pricing
+	if pricing != nil {
+		p = *pricing
+	}
+	known := func(ok bool, v string) string {
+		if !ok {
+			return unknownValue
+		}
+		return v
+	}
+	hasSettings, hasPricing := settings != nil, pricing != nil
+
+	// settings are compared field by field so that the signed prices,
+	// which always differ, are not reported
+	return []comparedField{
+		{"version", r.Version},
+		{"errors", strconv.Itoa(len(r.Errors))},
+		{"siamux.reachable", r.reachable(siamux.Protocol)},
+		{"quic.reachable", r.reachable(quic.Protocol)},
+		{"settings.protocolVersion", known(hasSettings, s.ProtocolVersion.String())},
+		{"settings.acceptingContracts", known(hasSettings, strconv.FormatBool(s.AcceptingContracts))},
+		{"settings.maxCollateral", known(hasSettings, s.MaxCollateral.String())},
+		{"settings.maxContractDuration", known(hasSettings, strconv.FormatUint(s.MaxContractDuration, 10))},
+		{"settings.remainingStorage", known(hasSettings, strconv.FormatUint(s.RemainingStorage, 10))},
+		{"settings.totalStorage", known(hasSettings, strconv.FormatUint(s.TotalStorage, 10))},
+		{"pricing.storagePrice", known(hasPricing, p.StoragePrice.String())},
+		{"pricing.collateral", known(hasPricing, p.Collateral.String())},
+		{"pricing.ingressPrice", known(hasPricing, p.IngressPrice.String())},
+		{"pricing.egressPrice", known(hasPricing, p.EgressPrice.String())},
+	}
+}
+
+// Compare returns the differences in version, reachability, settings, and
+// pricing between two results.
+func Compare(a, b Result) []Difference {
+	fa, fb := a.comparedFields(), b.comparedFields()
+	var diffs []Difference
+	for i := range fa {
+		if fa[i].value != fb[i].value {
+			diffs = append(diffs, Difference{Field: fa[i].name, A: fa[i].value, B: fb[i].value})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,62 @@
+package troubleshoot
+
+import (
+	"reflect"
+	"testing"
+
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+)
+
+func TestCompare(t *testing.T) {
+	settings := func(accepting bool, remaining uint64) *proto4.HostSettings {
+		return &proto4.HostSettings{
+			ProtocolVersion:    proto4.ProtocolVersion{1, 0, 0},
+			AcceptingContracts: accepting,
+			MaxCollateral:      types.Siacoins(1000),
+			RemainingStorage:   remaining,
+			TotalStorage:       1000,
+		}
+	}
+	pricing := func(storage uint32) *Pricing {
+		return &Pricing{StoragePrice: types.Siacoins(storage)}
+	}
+
+	a := Result{
+		Version: "2.0.0",
+		RHP4: []RHP4Result{
+			{NetAddress: chain.NetAddress{Protocol: siamux.Protocol}, Scanned: true, Settings: settings(true, 500), Pricing: pricing(100)},
+			{NetAddress: chain.NetAddress{Protocol: quic.Protocol}, Scanned: true, Settings: settings(true, 500), Pricing: pricing(100)},
+		},
+	}
+	b := Result{
+		Version: "2.1.0",
+		RHP4: []RHP4Result{
+			{NetAddress: chain.NetAddress{Protocol: siamux.Protocol}, Scanned: true, Settings: settings(false, 500), Pricing: pricing(200)},
+			{NetAddress: chain.NetAddress{Protocol: quic.Protocol}, Errors: []string{"failed to connect to quic"}},
+		},
+		Errors: []Issue{{Protocol: quic.Protocol, Message: "failed to connect to quic"}},
+	}
+
+	expected := []Difference{
+		{Field: "version", A: "2.0.0", B: "2.1.0"},
+		{Field: "errors", A: "0", B: "1"},
+		{Field: "quic.reachable", A: "true", B: "false"},
+		{Field: "settings.acceptingContracts", A: "true", B: "false"},
+		{Field: "pricing.storagePrice", A: types.Siacoins(100).String(), B: types.Siacoins(200).String()},
+	}
+	if diffs := Compare(a, b); !reflect.DeepEqual(diffs, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, diffs)
+	} else if diffs := Compare(a, a); len(diffs) != 0 {
+		t.Fatalf("expected no differences, got %+v", diffs)
+	}
+
+	// an unreachable host's settings are unknown
+	diffs := Compare(a, Result{Version: "2.0.0"})
+	if len(diffs) == 0 || diffs[0].Field != "siamux.reachable" || diffs[0].B != unknownValue {
+		t.Fatalf("expected unknown reachability, got %+v", diffs)
+	}
+}
@@ -0,0 +1,153 @@
+package troubleshoot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	eapi "go.sia.tech/explored/api"
+	"go.sia.tech/explored/explorer"
+)
+
+const defaultExplorerTimeout = 10 * time.Second
+
+var defaultExplorerRetry = retryPolicy{Attempts: 3, Backoff: time.Second}
+
+// A retryExplorer wraps an Explorer, retrying failed requests and bounding
+// how long each attempt can take.
+type retryExplorer struct {
+	explorer Explorer
+	retry    retryPolicy
+	// timeout is the maximum duration of each attempt. If zero, attempts
+	// are not bounded.
+	timeout time.Duration
+}
+
+// withTimeout calls fn, returning an error if it does not return within the
+// timeout. The explorer client does not accept a context, so a call that
+// times out is abandoned rather than canceled.
+func withTimeout[T any](timeout time.Duration, fn func() (T, error)) (T, error) {
+	if timeout <= 0 {
+		return fn()
+	}
+
+	type result struct {
+		v   T
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		ch <- result{v, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-time.After(timeout):
+		var zero T
+		return zero, fmt.Errorf("explorer request timed out after %s", timeout)
+	}
+}
+
+// withContext calls fn, returning the context's error if it is done before
+// fn returns. As with withTimeout, a call that is abandoned keeps running in
+// the background.
+func withContext[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	type result struct {
+		v   T
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		v, err := fn()
+		ch <- result{v, err}
+	}()
+
+	select {
+	case r := <-ch:
+		return r.v, r.err
+	case <-ctx.Done():
+		var zero T
+		return zero, ctx.Err()
+	}
+}
+
+// isHostNotFound returns true if the explorer reported that the host has not
+// been announced. A missing host is not a transient failure, so the request
+// is not retried.
+func isHostNotFound(err error) bool {
+	// the explorer client does not preserve the error type
+	return strings.Contains(err.Error(), eapi.ErrHostNotFound.Error())
+}
+
+// retryExplorerRequest calls fn until it succeeds, returns a permanent
+// error, or the maximum number of attempts is reached.
+func retryExplorerRequest[T any](e *retryExplorer, fn func() (T, error)) (T, error) {
+	backoff := e.retry.Backoff
+	for attempt := 1; ; attempt++ {
+		v, err := withTimeout(e.timeout, fn)
+		if err == nil || attempt >= e.retry.Attempts || isHostNotFound(err) {
+			return v, err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// ConsensusState implements Explorer.
+func (e *retryExplorer) ConsensusState() (consensus.State, error) {
+	return retryExplorerRequest(e, e.explorer.ConsensusState)
+}
+
+// Host implements Explorer.
+func (e *retryExplorer) Host(hostKey types.PublicKey) (explorer.Host, error) {
+	return retryExplorerRequest(e, func() (explorer.Host, error) {
+		return e.explorer.Host(hostKey)
+	})
+}
+
+// A failoverExplorer sends each request to its explorers in order until one
+// succeeds.
+type failoverExplorer []Explorer
+
+// failover calls fn with each explorer until it succeeds or the host is
+// not found.
+func failover[T any](fe failoverExplorer, fn func(Explorer) (T, error)) (T, error) {
+	var errs []error
+	for i, e := range fe {
+		v, err := fn(e)
+		if err == nil || isHostNotFound(err) {
+			return v, err
+		}
+		errs = append(errs, fmt.Errorf("explorer %d: %w", i+1, err))
+	}
+	var zero T
+	return zero, errors.Join(errs...)
+}
+
+// ConsensusState implements Explorer.
+func (fe failoverExplorer) ConsensusState() (consensus.State, error) {
+	return failover(fe, Explorer.ConsensusState)
+}
+
+// Host implements Explorer.
+func (fe failoverExplorer) Host(hostKey types.PublicKey) (explorer.Host, error) {
+	return failover(fe, func(e Explorer) (explorer.Host, error) {
+		return e.Host(hostKey)
+	})
+}
+
+// NewFailoverExplorer returns an Explorer that sends each request to the
+// explorers in order, falling back to the next one if a request fails. If
+// only one explorer is given, it is returned unchanged.
+func NewFailoverExplorer(explorers ...Explorer) Explorer {
+	if len(explorers) == 1 {
+		return explorers[0]
+	}
+	return failoverExplorer(explorers)
+}
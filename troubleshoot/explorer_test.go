@@ -0,0 +1,136 @@
+package troubleshoot
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	eapi "go.sia.tech/explored/api"
+	"go.sia.tech/explored/explorer"
+)
+
+// flakyExplorer fails the first failures requests with err.
+type flakyExplorer struct {
+	failures int
+	err      error
+	delay    time.Duration
+	calls    int
+}
+
+func (fe *flakyExplorer) call() error {
+	fe.calls++
+	time.Sleep(fe.delay)
+	if fe.calls <= fe.failures {
+		return fe.err
+	}
+	return nil
+}
+
+func (fe *flakyExplorer) ConsensusState() (consensus.State, error) {
+	if err := fe.call(); err != nil {
+		return consensus.State{}, err
+	}
+	return consensus.State{Index: types.ChainIndex{Height: 100}}, nil
+}
+
+func (fe *flakyExplorer) Host(hostKey types.PublicKey) (explorer.Host, error) {
+	if err := fe.call(); err != nil {
+		return explorer.Host{}, err
+	}
+	return explorer.Host{PublicKey: hostKey}, nil
+}
+
+func TestRetryExplorer(t *testing.T) {
+	retry := retryPolicy{Attempts: 3, Backoff: time.Millisecond}
+
+	t.Run("recovers", func(t *testing.T) {
+		fe := &flakyExplorer{failures: 2, err: errors.New("502 bad gateway")}
+		e := &retryExplorer{explorer: fe, retry: retry}
+		cs, err := e.ConsensusState()
+		if err != nil {
+			t.Fatal(err)
+		} else if cs.Index.Height != 100 {
+			t.Fatalf("expected height 100, got %d", cs.Index.Height)
+		} else if fe.calls != 3 {
+			t.Fatalf("expected 3 calls, got %d", fe.calls)
+		}
+	})
+
+	t.Run("exhausted", func(t *testing.T) {
+		fe := &flakyExplorer{failures: 5, err: errors.New("502 bad gateway")}
+		e := &retryExplorer{explorer: fe, retry: retry}
+		if _, err := e.ConsensusState(); err == nil {
+			t.Fatal("expected error")
+		} else if fe.calls != 3 {
+			t.Fatalf("expected 3 calls, got %d", fe.calls)
+		}
+	})
+
+	t.Run("host not found", func(t *testing.T) {
+		fe := &flakyExplorer{failures: 5, err: errors.New(eapi.ErrHostNotFound.Error())}
+		e := &retryExplorer{explorer: fe, retry: retry}
+		if _, err := e.Host(types.PublicKey{1}); err == nil {
+			t.Fatal("expected error")
+		} else if fe.calls != 1 {
+			t.Fatalf("expected a missing host not to be retried, got %d calls", fe.calls)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		fe := &flakyExplorer{delay: time.Second}
+		e := &retryExplorer{explorer: fe, retry: retryPolicy{Attempts: 1}, timeout: 10 * time.Millisecond}
+		start := time.Now()
+		if _, err := e.Host(types.PublicKey{1}); err == nil || !strings.Contains(err.Error(), "timed out") {
+			t.Fatalf("expected timeout error, got %v", err)
+		} else if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+			t.Fatalf("expected request to be abandoned after the timeout, took %s", elapsed)
+		}
+	})
+}
+
+func TestFailoverExplorer(t *testing.T) {
+	down := &flakyExplorer{failures: 10, err: errors.New("connection refused")}
+	up := &flakyExplorer{}
+	e := NewFailoverExplorer(down, up)
+
+	cs, err := e.ConsensusState()
+	if err != nil {
+		t.Fatal(err)
+	} else if cs.Index.Height != 100 {
+		t.Fatalf("expected height 100, got %d", cs.Index.Height)
+	} else if down.calls != 1 || up.calls != 1 {
+		t.Fatalf("expected each explorer to be called once, got %d and %d", down.calls, up.calls)
+	}
+
+	hostKey := types.PublicKey{1}
+	if host, err := e.Host(hostKey); err != nil {
+		t.Fatal(err)
+	} else if host.PublicKey != hostKey {
+		t.Fatalf("expected host %v, got %v", hostKey, host.PublicKey)
+	}
+
+	// a missing host should not fall back to the next explorer
+	missing := &flakyExplorer{failures: 10, err: errors.New(eapi.ErrHostNotFound.Error())}
+	fallback := &flakyExplorer{}
+	if _, err := NewFailoverExplorer(missing, fallback).Host(hostKey); err == nil {
+		t.Fatal("expected error")
+	} else if fallback.calls != 0 {
+		t.Fatalf("expected the second explorer not to be called, got %d calls", fallback.calls)
+	}
+
+	// every explorer failing should return all of their errors
+	other := &flakyExplorer{failures: 10, err: errors.New("timeout")}
+	if _, err := NewFailoverExplorer(down, other).ConsensusState(); err == nil {
+		t.Fatal("expected error")
+	} else if !strings.Contains(err.Error(), "connection refused") || !strings.Contains(err.Error(), "timeout") {
+		t.Fatalf("expected both errors, got %v", err)
+	}
+
+	// a single explorer should be used directly
+	if NewFailoverExplorer(up) != Explorer(up) {
+		t.Fatal("expected a single explorer to be returned unchanged")
+	}
+}
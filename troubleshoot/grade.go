@@ -0,0 +1,104 @@
+package troubleshoot
+
+import (
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+)
+
+// A Grade is a letter grade summarizing a result.
+type Grade string
+
+// Grades from best to worst.
+const (
+	GradeA Grade = "A"
+	GradeB Grade = "B"
+	GradeC Grade = "C"
+	GradeD Grade = "D"
+	GradeF Grade = "F"
+)
+
+// ScoreWeights are the points deducted from a perfect score of 100 for each
+// problem found. The penalties are cumulative: a problem that is also
+// reported as an issue is penalized both for its category and for the
+// issue.
+type ScoreWeights struct {
+	// Unreachable is deducted for each tested protocol with no address
+	// that could be scanned.
+	Unreachable int
+	// Outdated is deducted if the host's version is unknown or older than
+	// the latest release.
+	Outdated int
+	// Settings is deducted if the host's settings or pricing fail any
+	// of the configured thresholds or price limits.
+	Settings int
+	// Error and Warning are deducted for each distinct error and warning.
+	Error   int
+	Warning int
+}
+
+// defaultScoreWeights grade a host with any error no better than a C and
+// an unreachable protocol, which is also reported as an error, no better
+// than a D.
+var defaultScoreWeights = ScoreWeights{
+	Unreachable: 15,
+	Outdated:    10,
+	Settings:    10,
+	Error:       25,
+	Warning:     5,
+}
+
+// gradeForScore returns the letter grade for a score. Each grade above F
+// covers 10 points.
+func gradeForScore(score int) Grade {
+	switch {
+	case score >= 90:
+		return GradeA
+	case score >= 80:
+		return GradeB
+	case score >= 70:
+		return GradeC
+	case score >= 60:
+		return GradeD
+	default:
+		return GradeF
+	}
+}
+
+// settingsFailed returns true if the result's settings or pricing fail the
+// thresholds or price limits.
+func settingsFailed(r Result, p scanParams) bool {
+	settings, pricing := r.settings()
+	if settings == nil {
+		return false
+	}
+	var res RHP4Result
+	checkSettings(*settings, p.thresholds, &res)
+	if pricing != nil {
+		checkPriceLimits(*pricing, p.priceLimits, &res)
+	}
+	return len(res.Errors) > 0 || len(res.Warnings) > 0
+}
+
+// score returns a score between 0 and 100 for the result.
+func score(r Result, p scanParams) int {
+	w := p.scoreWeights
+	score := 100
+	for _, proto := range []chain.Protocol{siamux.Protocol, quic.Protocol} {
+		if r.reachable(proto) == "false" {
+			score -= w.Unreachable
+		}
+	}
+	if r.Version != "" && !p.skipVersionCheck {
+		release, err := parseReleaseString(r.Version)
+		if err != nil || release.Cmp(p.currentVersion) < 0 {
+			score -= w.Outdated
+		}
+	}
+	if settingsFailed(r, p) {
+		score -= w.Settings
+	}
+	score -= w.Error * len(r.Errors)
+	score -= w.Warning * len(r.Warnings)
+	return max(0, min(100, score))
+}
@@ -0,0 +1,138 @@
+package troubleshoot
+
+import (
+	"testing"
+
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+)
+
+func TestScore(t *testing.T) {
+	params := scanParams{
+		scanConfig: scanConfig{
+			priceLimits:  defaultPriceLimits,
+			thresholds:   defaultThresholds,
+			scoreWeights: defaultScoreWeights,
+		},
+		currentVersion: SemVer{version: [3]byte{2, 1, 0}},
+	}
+
+	settings := proto4.HostSettings{
+		Release:             "hostd v2.1.0",
+		AcceptingContracts:  true,
+		MaxCollateral:       types.Siacoins(1000),
+		MaxContractDuration: 144 * 90,
+		Prices: proto4.HostPrices{
+			StoragePrice: types.Siacoins(1).Div64(1e12),
+			Collateral:   types.Siacoins(2).Div64(1e12),
+		},
+	}
+	scanned := func(proto chain.Protocol, settings proto4.HostSettings) RHP4Result {
+		return RHP4Result{
+			NetAddress: chain.NetAddress{Protocol: proto, Address: "host.example:9984"},
+			Scanned:    true,
+			Settings:   &settings,
+			Pricing:    convertPrices(settings.Prices),
+		}
+	}
+	failed := func(proto chain.Protocol) RHP4Result {
+		return RHP4Result{NetAddress: chain.NetAddress{Protocol: proto, Address: "host.example:9984"}}
+	}
+	issues := func(n int) []Issue {
+		return make([]Issue, n)
+	}
+
+	lowCollateral := settings
+	lowCollateral.Prices.Collateral = settings.Prices.StoragePrice.Mul64(3).Div64(2)
+
+	tests := []struct {
+		name   string
+		result Result
+		score  int
+		grade  Grade
+	}{
+		{
+			name: "healthy",
+			result: Result{
+				Version: "hostd v2.1.0",
+				RHP4:    []RHP4Result{scanned(siamux.Protocol, settings), scanned(quic.Protocol, settings)},
+			},
+			score: 100,
+			grade: GradeA,
+		},
+		{
+			name: "outdated",
+			result: Result{
+				Version:  "hostd v2.0.0",
+				RHP4:     []RHP4Result{scanned(siamux.Protocol, settings), scanned(quic.Protocol, settings)},
+				Warnings: issues(1),
+			},
+			score: 85,
+			grade: GradeB,
+		},
+		{
+			name: "low collateral",
+			result: Result{
+				Version:  "hostd v2.1.0",
+				RHP4:     []RHP4Result{scanned(siamux.Protocol, lowCollateral)},
+				Warnings: issues(1),
+			},
+			score: 85,
+			grade: GradeB,
+		},
+		{
+			name: "quic unreachable",
+			result: Result{
+				Version: "hostd v2.1.0",
+				RHP4:    []RHP4Result{scanned(siamux.Protocol, settings), failed(quic.Protocol)},
+				Errors:  issues(1),
+			},
+			score: 60,
+			grade: GradeD,
+		},
+		{
+			name: "unreachable",
+			result: Result{
+				RHP4:   []RHP4Result{failed(siamux.Protocol), failed(quic.Protocol)},
+				Errors: issues(2),
+			},
+			score: 20,
+			grade: GradeF,
+		},
+		{
+			name: "clamped",
+			result: Result{
+				RHP4:   []RHP4Result{failed(siamux.Protocol), failed(quic.Protocol)},
+				Errors: issues(5),
+			},
+			score: 0,
+			grade: GradeF,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			score := score(tt.result, params)
+			if score != tt.score {
+				t.Fatalf("expected score %d, got %d", tt.score, score)
+			} else if grade := gradeForScore(score); grade != tt.grade {
+				t.Fatalf("expected grade %s, got %s", tt.grade, grade)
+			}
+		})
+	}
+
+	t.Run("custom weights", func(t *testing.T) {
+		p := params
+		p.scoreWeights = ScoreWeights{Warning: 20}
+		r := Result{
+			Version:  "hostd v2.0.0",
+			RHP4:     []RHP4Result{scanned(siamux.Protocol, settings)},
+			Warnings: issues(1),
+		}
+		if score := score(r, p); score != 80 {
+			t.Fatalf("expected score 80, got %d", score)
+		}
+	})
+}
@@ -0,0 +1,349 @@
+package troubleshoot
+
+import (
+	"context"
+	"errors"
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+	"go.sia.tech/coreutils/threadgroup"
+	"go.sia.tech/explored/explorer"
+	"go.sia.tech/troubleshootd/internal/dns"
+	"go.uber.org/zap"
+)
+
+// A fakeExplorer returns a fixed consensus state and the announcements of
+// a set of known hosts.
+type fakeExplorer struct {
+	state consensus.State
+	hosts map[types.PublicKey]explorer.Host
+}
+
+func (fe fakeExplorer) ConsensusState() (consensus.State, error) {
+	return fe.state, nil
+}
+
+func (fe fakeExplorer) Host(hostKey types.PublicKey) (explorer.Host, error) {
+	host, ok := fe.hosts[hostKey]
+	if !ok {
+		return explorer.Host{}, errors.New("host not found")
+	}
+	return host, nil
+}
+
+// newTestManager returns a Manager with the same defaults as NewManager
+// that compares hosts against the explorer's consensus state and the given
+// latest release instead of fetching it from GitHub. Internal addresses are
+// allowed, since test hosts listen on loopback.
+func newTestManager(t *testing.T, e Explorer, latestRelease string, opts ...Option) *Manager {
+	t.Helper()
+
+	m := &Manager{
+		tg:       threadgroup.New(),
+		log:      zap.NewNop(),
+		explorer: e,
+		scanSem:  make(chan struct{}, defaultMaxConcurrentScans),
+		cfg: scanConfig{
+			retry:            retryPolicy{Attempts: 1},
+			dialTimeout:      time.Second,
+			handshakeTimeout: defaultHandshakeTimeout,
+			settingsTimeout:  defaultSettingsTimeout,
+			priceLimits:      defaultPriceLimits,
+			thresholds:       defaultThresholds,
+			scoreWeights:     defaultScoreWeights,
+			hardforkWindow:   defaultHardforkWindow,
+			resolvers:        defaultResolvers(),
+			maxCNAMEDepth:    dns.DefaultMaxCNAMEDepth,
+		},
+		history:         newHistory(defaultHistorySize, defaultHistoryMaxHosts),
+		flapTransitions: defaultFlapTransitions,
+		flapWindow:      defaultFlapWindow,
+		cooldown:        make(map[types.PublicKey]time.Time),
+	}
+	for _, opt := range opts {
+		opt(m)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	cs, err := e.ConsensusState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	m.setState(cs)
+	var release SemVer
+	if err := release.UnmarshalText([]byte(latestRelease)); err != nil {
+		t.Fatal(err)
+	}
+	m.setLatestRelease(release)
+	return m
+}
+
+// testHostSettings returns settings that pass every check at the default
+// thresholds and price limits.
+func testHostSettings(release string) proto4.HostSettings {
+	return proto4.HostSettings{
+		Release:             release,
+		AcceptingContracts:  true,
+		MaxCollateral:       types.Siacoins(1000),
+		MaxContractDuration: 144 * 60,
+		Prices: proto4.HostPrices{
+			StoragePrice: types.NewCurrency64(1e10),
+			Collateral:   types.NewCurrency64(3e10),
+			IngressPrice: types.NewCurrency64(1e10),
+			EgressPrice:  types.NewCurrency64(1e10),
+		},
+	}
+}
+
+func TestTestHostScenarios(t *testing.T) {
+	// the state's height matches stubChain's tip
+	e := fakeExplorer{state: consensus.State{Index: types.ChainIndex{Height: 100}}}
+
+	withEgressPrice := func(s proto4.HostSettings, price types.Currency) proto4.HostSettings {
+		s.Prices.EgressPrice = price
+		return s
+	}
+
+	tests := []struct {
+		name      string
+		settings  *proto4.HostSettings // nil if the host is offline
+		reachable string
+		drift     VersionDrift
+		err       string
+		warning   string
+	}{
+		{
+			name:      "reachable",
+			settings:  new(testHostSettings("hostd v2.1.0")),
+			reachable: "true",
+			drift:     VersionUpToDate,
+		},
+		{
+			name:      "unreachable",
+			reachable: "false",
+			err:       "connection refused",
+		},
+		{
+			name:      "outdated version",
+			settings:  new(testHostSettings("hostd v2.0.3")),
+			reachable: "true",
+			drift:     VersionMinorBehind,
+			warning:   `outdated version "v2.0.3"`,
+		},
+		{
+			name:      "bad pricing",
+			settings:  new(withEgressPrice(testHostSettings("hostd v2.1.0"), types.Siacoins(1).Div64(1e6))),
+			reachable: "true",
+			drift:     VersionUpToDate,
+			warning:   "host's egress price",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hostKey := types.GeneratePrivateKey()
+			var addr string
+			if test.settings != nil {
+				addr = newSiaMuxHost(t, hostKey, *test.settings)
+			} else {
+				l, err := net.Listen("tcp", "127.0.0.1:0")
+				if err != nil {
+					t.Fatal(err)
+				}
+				addr = l.Addr().String()
+				l.Close()
+			}
+
+			m := newTestManager(t, e, "v2.1.0")
+			result, err := m.TestHost(context.Background(), Host{
+				PublicKey:        hostKey.PublicKey(),
+				RHP4NetAddresses: []chain.NetAddress{{Protocol: siamux.Protocol, Address: addr}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			} else if reachable := result.reachable(siamux.Protocol); reachable != test.reachable {
+				t.Fatalf("expected reachable %q, got %q (errors %v)", test.reachable, reachable, result.RHP4[0].Errors)
+			} else if result.VersionDrift != test.drift {
+				t.Fatalf("expected drift %q, got %q", test.drift, result.VersionDrift)
+			} else if !slices.Equal(result.Transports, []chain.Protocol{siamux.Protocol}) || !slices.Equal(result.MissingTransports, []chain.Protocol{quic.Protocol}) {
+				t.Fatalf("expected only siamux to be offered, got %v and missing %v", result.Transports, result.MissingTransports)
+			}
+
+			res := result.RHP4[0]
+			// every test host is on a loopback address
+			warnings := slices.DeleteFunc(slices.Clone(res.Warnings), func(w string) bool {
+				return strings.Contains(w, "loopback address")
+			})
+			if test.err == "" && len(res.Errors) != 0 {
+				t.Fatalf("expected no errors, got %v", res.Errors)
+			} else if test.err != "" && !hasIssue(res.Errors, test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, res.Errors)
+			} else if test.warning == "" && len(warnings) != 0 {
+				t.Fatalf("expected no warnings, got %v", warnings)
+			} else if test.warning != "" && !hasIssue(warnings, test.warning) {
+				t.Fatalf("expected warning containing %q, got %v", test.warning, warnings)
+			}
+		})
+	}
+}
+
+func TestTestHostContractCost(t *testing.T) {
+	e := fakeExplorer{state: consensus.State{Index: types.ChainIndex{Height: 100}}}
+	hostKey := types.GeneratePrivateKey()
+	settings := testHostSettings("hostd v2.1.0")
+	addr := newSiaMuxHost(t, hostKey, settings)
+
+	m := newTestManager(t, e, "v2.1.0", WithCooldown(0))
+	test := func(duration, size uint64) RHP4Result {
+		t.Helper()
+		result, err := m.TestHost(context.Background(), Host{
+			PublicKey:        hostKey.PublicKey(),
+			RHP4NetAddresses: []chain.NetAddress{{Protocol: siamux.Protocol, Address: addr}},
+			ContractDuration: duration,
+			DataSize:         size,
+		})
+		if err != nil {
+			t.Fatal(err)
+		} else if result.RHP4[0].Contract == nil {
+			t.Fatal("expected the contract cost to be calculated")
+		}
+		return result.RHP4[0]
+	}
+
+	// the default contract is the minimum duration and 1 TB
+	res := test(0, 0)
+	if res.Contract.Duration != defaultThresholds.MinContractDuration || res.Contract.DataSize != bytesPerTB {
+		t.Fatalf("expected the default contract, got %+v", res.Contract)
+	}
+	defaultCost := res.Contract.StorageCost
+
+	// doubling the duration doubles the storage cost and collateral
+	res = test(2*defaultThresholds.MinContractDuration, 0)
+	if !res.Contract.StorageCost.Equals(defaultCost.Mul64(2)) {
+		t.Fatalf("expected storage cost %v, got %v", defaultCost.Mul64(2), res.Contract.StorageCost)
+	} else if hasIssue(res.Warnings, "requested") {
+		t.Fatalf("expected no contract warnings, got %v", res.Warnings)
+	}
+
+	// a longer contract than the host allows is warned
+	res = test(settings.MaxContractDuration+1, 0)
+	if !hasIssue(res.Warnings, "less than the requested") {
+		t.Fatalf("expected duration warning, got %v", res.Warnings)
+	}
+
+	// a larger contract than the host's max collateral covers is warned
+	res = test(0, 100*bytesPerTB)
+	if !hasIssue(res.Warnings, "the requested contract cannot be formed") {
+		t.Fatalf("expected collateral warning, got %v", res.Warnings)
+	}
+}
+
+func TestTestHostDiagnosis(t *testing.T) {
+	e := fakeExplorer{state: consensus.State{Index: types.ChainIndex{Height: 100}}}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	m := newTestManager(t, e, "v2.1.0")
+	result, err := m.TestHost(context.Background(), Host{
+		PublicKey:        types.GeneratePrivateKey().PublicKey(),
+		RHP4NetAddresses: []chain.NetAddress{{Protocol: siamux.Protocol, Address: net.JoinHostPort("localhost", port)}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(result.RHP4[0].ResolvedAddresses) == 0 {
+		t.Fatalf("expected localhost to resolve, got errors %v", result.RHP4[0].Errors)
+	} else if !strings.Contains(result.Diagnosis, "refused the connection") {
+		t.Fatalf("expected a refused diagnosis, got %q (errors %v)", result.Diagnosis, result.RHP4[0].Errors)
+	}
+}
+
+func TestTestHostSkipVersionCheck(t *testing.T) {
+	e := fakeExplorer{state: consensus.State{Index: types.ChainIndex{Height: 100}}}
+	hostKey := types.GeneratePrivateKey()
+	addr := newSiaMuxHost(t, hostKey, testHostSettings("hostd v1.9.0"))
+
+	m := newTestManager(t, e, "v2.1.0", WithCooldown(0))
+	test := func(skip bool) Result {
+		t.Helper()
+		result, err := m.TestHost(context.Background(), Host{
+			PublicKey:        hostKey.PublicKey(),
+			RHP4NetAddresses: []chain.NetAddress{{Protocol: siamux.Protocol, Address: addr}},
+			SkipVersionCheck: skip,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	result := test(false)
+	if result.VersionDrift != VersionMajorBehind {
+		t.Fatalf("expected drift %q, got %q", VersionMajorBehind, result.VersionDrift)
+	} else if !hasIssue(result.RHP4[0].Errors, "a major version behind") {
+		t.Fatalf("expected a version error, got %v", result.RHP4[0].Errors)
+	}
+	checked := result.Score
+
+	result = test(true)
+	if result.Version != "hostd v1.9.0" {
+		t.Fatalf("expected the host's version to be reported, got %q", result.Version)
+	} else if result.VersionDrift != "" {
+		t.Fatalf("expected no drift, got %q", result.VersionDrift)
+	} else if len(result.RHP4[0].Errors) != 0 {
+		t.Fatalf("expected no errors, got %v", result.RHP4[0].Errors)
+	} else if hasIssue(result.RHP4[0].Warnings, "version") {
+		t.Fatalf("expected no version warnings, got %v", result.RHP4[0].Warnings)
+	} else if result.Score <= checked {
+		t.Fatalf("expected the outdated version not to lower the score, got %d and %d", checked, result.Score)
+	}
+}
+
+func TestTestHostQUICFirewalled(t *testing.T) {
+	e := fakeExplorer{state: consensus.State{Index: types.ChainIndex{Height: 100}}}
+	hostKey := types.GeneratePrivateKey()
+	addr := newSiaMuxHost(t, hostKey, testHostSettings("hostd v2.1.0"))
+
+	// a UDP socket that never responds, like a firewall dropping packets
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	m := newTestManager(t, e, "v2.1.0", WithStepTimeouts(500*time.Millisecond, defaultSettingsTimeout))
+	result, err := m.TestHost(context.Background(), Host{
+		PublicKey: hostKey.PublicKey(),
+		RHP4NetAddresses: []chain.NetAddress{
+			{Protocol: siamux.Protocol, Address: addr},
+			{Protocol: quic.Protocol, Address: conn.LocalAddr().String()},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	} else if len(result.RHP4) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(result.RHP4))
+	}
+
+	if res := result.RHP4[0]; !res.Scanned {
+		t.Fatalf("expected siamux to be scanned, got errors %v", res.Errors)
+	} else if len(res.Notes) != 0 {
+		t.Fatalf("expected no siamux notes, got %v", res.Notes)
+	}
+	if res := result.RHP4[1]; res.Handshake || len(res.Errors) == 0 {
+		t.Fatalf("expected quic to fail, got %+v", res)
+	} else if !hasIssue(res.Notes, "siamux to the same host ("+addr+") succeeded") {
+		t.Fatalf("expected a firewall note, got %v", res.Notes)
+	}
+}
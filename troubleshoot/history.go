@@ -0,0 +1,164 @@
+package troubleshoot
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+const (
+	defaultHistorySize     = 10
+	defaultHistoryMaxHosts = 1000
+
+	defaultFlapTransitions = 3
+	defaultFlapWindow      = time.Hour
+)
+
+// A resultRing holds the most recent results of a host, overwriting the
+// oldest result once it is full.
+type resultRing struct {
+	hostKey types.PublicKey
+	results []Result
+	// next is the index the next result is written to
+	next int
+	full bool
+}
+
+func (r *resultRing) add(res Result) {
+	r.results[r.next] = res
+	r.next = (r.next + 1) % len(r.results)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// recent returns the results from newest to oldest.
+func (r *resultRing) recent() []Result {
+	n := r.next
+	if r.full {
+		n = len(r.results)
+	}
+	recent := make([]Result, 0, n)
+	for i := 1; i <= n; i++ {
+		recent = append(recent, r.results[(r.next-i+len(r.results))%len(r.results)])
+	}
+	return recent
+}
+
+// A history stores the recent results of each host in memory. Once it holds
+// maxHosts hosts, the least recently tested host is evicted.
+type history struct {
+	size     int
+	maxHosts int
+
+	mu sync.Mutex
+	// lru is ordered from most to least recently tested and holds
+	// *resultRing values
+	lru   *list.List
+	hosts map[types.PublicKey]*list.Element
+}
+
+// add records the result of testing a host. A nil history discards the
+// result.
+func (h *history) add(res Result) {
+	if h == nil || h.size <= 0 || h.maxHosts <= 0 {
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	el, ok := h.hosts[res.PublicKey]
+	if ok {
+		h.lru.MoveToFront(el)
+	} else {
+		if h.lru.Len() >= h.maxHosts {
+			oldest := h.lru.Back()
+			delete(h.hosts, oldest.Value.(*resultRing).hostKey)
+			h.lru.Remove(oldest)
+		}
+		el = h.lru.PushFront(&resultRing{hostKey: res.PublicKey, results: make([]Result, h.size)})
+		h.hosts[res.PublicKey] = el
+	}
+	el.Value.(*resultRing).add(res)
+}
+
+// recent returns the host's recent results from newest to oldest.
+func (h *history) recent(hostKey types.PublicKey) []Result {
+	if h == nil {
+		return nil
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	el, ok := h.hosts[hostKey]
+	if !ok {
+		return nil
+	}
+	return el.Value.(*resultRing).recent()
+}
+
+func newHistory(size, maxHosts int) *history {
+	return &history{
+		size:     size,
+		maxHosts: maxHosts,
+
+		lru:   list.New(),
+		hosts: make(map[types.PublicKey]*list.Element),
+	}
+}
+
+// RecentResults returns the results of the host's most recent tests, from
+// newest to oldest. Only results of tests run since the server started are
+// returned.
+func (m *Manager) RecentResults(hostKey types.PublicKey) []Result {
+	return m.history.recent(hostKey)
+}
+
+// reachableAny returns true if any of the result's addresses were reached.
+func (r Result) reachableAny() bool {
+	for _, res := range r.RHP4 {
+		if res.reached() {
+			return true
+		}
+	}
+	return false
+}
+
+// reachabilityChanges returns the number of times the host went from
+// reachable to unreachable or back across the current result and the
+// recent results, ordered from newest to oldest, that started within the
+// window.
+func reachabilityChanges(current Result, recent []Result, window time.Duration) int {
+	var changes int
+	prev := current.reachableAny()
+	for _, r := range recent {
+		if current.ScannedAt.Sub(r.ScannedAt) > window {
+			break
+		}
+		reachable := r.reachableAny()
+		if reachable != prev {
+			changes++
+		}
+		prev = reachable
+	}
+	return changes
+}
+
+// checkFlapping warns if the host's reachability has changed at least the
+// configured number of times within the window. Flapping is usually
+// caused by a crashing host process or an overloaded router.
+func (m *Manager) checkFlapping(current Result) []string {
+	if m.flapTransitions <= 0 {
+		return nil
+	}
+	changes := reachabilityChanges(current, m.history.recent(current.PublicKey), m.flapWindow)
+	if changes < m.flapTransitions {
+		return nil
+	}
+	return []string{fmt.Sprintf("host's reachability changed %d times in the last %s: check that the host process is not restarting and its network connection is stable", changes, m.flapWindow)}
+}
@@ -0,0 +1,124 @@
+package troubleshoot
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+)
+
+func TestHistory(t *testing.T) {
+	result := func(hostKey types.PublicKey, version string) Result {
+		return Result{PublicKey: hostKey, Version: version}
+	}
+	versions := func(results []Result) []string {
+		var v []string
+		for _, r := range results {
+			v = append(v, r.Version)
+		}
+		return v
+	}
+
+	t.Run("ordering", func(t *testing.T) {
+		h := newHistory(3, 10)
+		hostKey := types.GeneratePrivateKey().PublicKey()
+		if recent := h.recent(hostKey); recent != nil {
+			t.Fatalf("expected no results, got %v", recent)
+		}
+
+		h.add(result(hostKey, "1"))
+		h.add(result(hostKey, "2"))
+		if v := versions(h.recent(hostKey)); !slices.Equal(v, []string{"2", "1"}) {
+			t.Fatalf("expected [2 1], got %v", v)
+		}
+
+		// the oldest results should be overwritten once the ring is full
+		for _, version := range []string{"3", "4", "5"} {
+			h.add(result(hostKey, version))
+		}
+		if v := versions(h.recent(hostKey)); !slices.Equal(v, []string{"5", "4", "3"}) {
+			t.Fatalf("expected [5 4 3], got %v", v)
+		}
+	})
+
+	t.Run("eviction", func(t *testing.T) {
+		h := newHistory(2, 2)
+		a := types.GeneratePrivateKey().PublicKey()
+		b := types.GeneratePrivateKey().PublicKey()
+		c := types.GeneratePrivateKey().PublicKey()
+
+		h.add(result(a, "a1"))
+		h.add(result(b, "b1"))
+		// testing a again makes b the least recently tested
+		h.add(result(a, "a2"))
+		h.add(result(c, "c1"))
+
+		if recent := h.recent(b); recent != nil {
+			t.Fatalf("expected b to be evicted, got %v", versions(recent))
+		} else if v := versions(h.recent(a)); !slices.Equal(v, []string{"a2", "a1"}) {
+			t.Fatalf("expected [a2 a1], got %v", v)
+		} else if v := versions(h.recent(c)); !slices.Equal(v, []string{"c1"}) {
+			t.Fatalf("expected [c1], got %v", v)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		hostKey := types.GeneratePrivateKey().PublicKey()
+		for _, h := range []*history{nil, newHistory(0, 10), newHistory(10, 0)} {
+			h.add(result(hostKey, "1"))
+			if recent := h.recent(hostKey); recent != nil {
+				t.Fatalf("expected no results, got %v", recent)
+			}
+		}
+	})
+}
+
+func TestFlapping(t *testing.T) {
+	hostKey := types.GeneratePrivateKey().PublicKey()
+	start := time.Now()
+	result := func(age time.Duration, reachable bool) Result {
+		return Result{
+			PublicKey: hostKey,
+			ScannedAt: start.Add(-age),
+			RHP4:      []RHP4Result{{Scanned: reachable}},
+		}
+	}
+
+	m := &Manager{
+		history:         newHistory(10, 10),
+		flapTransitions: 3,
+		flapWindow:      time.Hour,
+	}
+
+	// the host alternates between reachable and unreachable
+	for i := 5; i > 0; i-- {
+		m.history.add(result(time.Duration(i)*time.Minute, i%2 == 0))
+	}
+	current := result(0, true)
+	if n := reachabilityChanges(current, m.history.recent(hostKey), time.Hour); n != 5 {
+		t.Fatalf("expected 5 changes, got %d", n)
+	} else if warnings := m.checkFlapping(current); len(warnings) != 1 || !hasIssue(warnings, "reachability changed 5 times") {
+		t.Fatalf("expected flapping warning, got %v", warnings)
+	}
+
+	// results outside the window are ignored
+	if n := reachabilityChanges(current, m.history.recent(hostKey), 150*time.Second); n != 2 {
+		t.Fatalf("expected 2 changes, got %d", n)
+	}
+
+	// a host that is consistently reachable is not flapping
+	stable := &Manager{history: newHistory(10, 10), flapTransitions: 3, flapWindow: time.Hour}
+	for i := 5; i > 0; i-- {
+		stable.history.add(result(time.Duration(i)*time.Minute, true))
+	}
+	if warnings := stable.checkFlapping(current); len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", warnings)
+	}
+
+	// detection can be disabled
+	m.flapTransitions = 0
+	if warnings := m.checkFlapping(current); len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", warnings)
+	}
+}
@@ -0,0 +1,53 @@
+package troubleshoot
+
+import (
+	"context"
+	"crypto/tls"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+)
+
+const (
+	// smallPacketSize is the minimum initial packet size allowed by QUIC.
+	smallPacketSize = 1200
+	// largePacketSize is the largest initial packet size supported by
+	// quic-go. It fits within a standard 1500 byte Ethernet MTU.
+	largePacketSize = 1452
+
+	mtuProbeTimeout = 5 * time.Second
+)
+
+// probeQUIC attempts a QUIC handshake with the host using a fixed packet size.
+// Path MTU discovery is disabled so every packet is sent at packetSize. The
+// certificate is not verified since only reachability is being tested.
+func probeQUIC(ctx context.Context, addr string, packetSize uint16) error {
+	ctx, cancel := context.WithTimeout(ctx, mtuProbeTimeout)
+	defer cancel()
+
+	conn, err := quicgo.DialAddr(ctx, addr, &tls.Config{
+		InsecureSkipVerify: true,
+		NextProtos:         []string{quic.TLSNextProtoRHP4},
+	}, &quicgo.Config{
+		HandshakeIdleTimeout:    mtuProbeTimeout,
+		InitialPacketSize:       packetSize,
+		DisablePathMTUDiscovery: true,
+	})
+	if err != nil {
+		return err
+	}
+	return conn.CloseWithError(0, "probe complete")
+}
+
+// checkMTU probes the host with small and large QUIC packets. If the small
+// probe succeeds but the large one fails, large UDP datagrams are likely
+// being dropped along the path.
+func checkMTU(ctx context.Context, addr string, res *RHP4Result) {
+	if err := probeQUIC(ctx, addr, smallPacketSize); err != nil {
+		return // host is unreachable regardless of packet size
+	} else if err := probeQUIC(ctx, addr, largePacketSize); err == nil {
+		return
+	}
+	res.Warnings = append(res.Warnings, "QUIC handshake succeeded with small packets but failed with large packets: a router or firewall along the path is likely dropping large or fragmented UDP packets, check the MTU settings of your network")
+}
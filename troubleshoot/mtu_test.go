@@ -0,0 +1,128 @@
+package troubleshoot
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+)
+
+// newQUICRelay starts a QUIC listener behind a UDP relay that drops
+// datagrams from clients larger than maxSize bytes, like a path with a
+// small MTU. It returns the relay's address.
+func newQUICRelay(t *testing.T, maxSize int) string {
+	t.Helper()
+
+	l, err := quicgo.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{newQUICCertificate(t)},
+		NextProtos:   []string{quic.TLSNextProtoRHP4},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			if _, err := l.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	relay, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { relay.Close() })
+
+	// each client gets its own connection to the listener so that
+	// responses can be relayed back to it
+	var mu sync.Mutex
+	upstreams := make(map[string]net.Conn)
+	t.Cleanup(func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for _, upstream := range upstreams {
+			upstream.Close()
+		}
+	})
+	go func() {
+		buf := make([]byte, 65536)
+		for {
+			n, client, err := relay.ReadFrom(buf)
+			if err != nil {
+				return
+			} else if n > maxSize {
+				continue
+			}
+
+			mu.Lock()
+			upstream, ok := upstreams[client.String()]
+			if !ok {
+				upstream, err = net.Dial("udp", l.Addr().String())
+				if err != nil {
+					mu.Unlock()
+					return
+				}
+				upstreams[client.String()] = upstream
+				go func() {
+					buf := make([]byte, 65536)
+					for {
+						n, err := upstream.Read(buf)
+						if err != nil {
+							return
+						}
+						relay.WriteTo(buf[:n], client)
+					}
+				}()
+			}
+			mu.Unlock()
+			upstream.Write(buf[:n])
+		}
+	}()
+	return relay.LocalAddr().String()
+}
+
+func TestCheckMTU(t *testing.T) {
+	tests := []struct {
+		name    string
+		maxSize int
+		warning bool
+	}{
+		{"no limit", 65535, false},
+		{"large packets dropped", smallPacketSize, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := newQUICRelay(t, test.maxSize)
+
+			// the dropped probe fails once the context expires
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+			var res RHP4Result
+			checkMTU(ctx, addr, &res)
+			if warned := hasIssue(res.Warnings, "succeeded with small packets but failed with large packets"); warned != test.warning {
+				t.Fatalf("expected warning %v, got %v", test.warning, res.Warnings)
+			}
+		})
+	}
+
+	// an unreachable host is not warned
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	var res RHP4Result
+	checkMTU(ctx, conn.LocalAddr().String(), &res)
+	if len(res.Warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", res.Warnings)
+	}
+}
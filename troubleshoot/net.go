@@ -0,0 +1,586 @@
+package troubleshoot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.sia.tech/troubleshootd/internal/dns"
+	"go.sia.tech/troubleshootd/internal/rdap"
+	"golang.org/x/net/proxy"
+)
+
+var (
+	// errConnectionRefused is returned by dialContext if the host refused
+	// the connection.
+	errConnectionRefused = errors.New("connection refused")
+	// errDialTimeout is returned by dialContext if the connection timed
+	// out.
+	errDialTimeout = errors.New("timeout connecting")
+)
+
+// dialError returns a more user-friendly version of a dial error
+// if possible.
+func dialError(address string, err error) error {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return fmt.Errorf("failed to resolve host %q: check DNS setup", address)
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		if syscallErr, ok := opErr.Err.(*os.SyscallError); ok {
+			if syscallErr.Err == syscall.ECONNREFUSED {
+				return fmt.Errorf("%w at %q: check if the service is running and port is forwarded", errConnectionRefused, address)
+			}
+		}
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w to %q: check port forwarding or firewall", errDialTimeout, address)
+	}
+
+	return fmt.Errorf("failed to connect to host at %q: %w", address, err)
+}
+
+// dialContext dials the address, retrying transient failures according to
+// the retry policy. Each attempt is limited by the timeout or the context's
+// deadline, whichever is sooner. It returns the number of attempts made.
+// Connections are made from the source address if one is configured,
+// unless they are proxied.
+func dialContext(ctx context.Context, cfg scanConfig, network, address string) (net.Conn, int, error) {
+	d := &net.Dialer{}
+	if cfg.sourceAddr != nil {
+		d.LocalAddr = &net.TCPAddr{IP: cfg.sourceAddr}
+	}
+	if cfg.restrictsNetworks() {
+		// check the address actually connected to, since the hostname
+		// may resolve differently than when its addresses were checked
+		d.Control = networkControl(cfg)
+	}
+	var dialer proxy.ContextDialer = d
+	if cfg.proxy != nil {
+		dialer = cfg.proxy
+		if cfg.restrictsNetworks() {
+			// the proxy makes the connection, so the dialer's control
+			// never runs. A hostname would also be resolved again by the
+			// proxy, so only checked IPs may be dialed.
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return nil, 0, dialError(address, err)
+			}
+			ip := dns.ParseIP(host)
+			if ip == nil {
+				return nil, 0, fmt.Errorf("refusing to connect to %q through the proxy: only resolved IP addresses can be checked against the allowed networks", address)
+			} else if err := checkNetworks(cfg, []net.IP{ip}); err != nil {
+				return nil, 0, fmt.Errorf("refusing to connect to %q: %w", address, err)
+			}
+		}
+	}
+
+	var conn net.Conn
+	attempts, err := cfg.retry.do(ctx, func() (err error) {
+		ctx := ctx
+		if cfg.dialTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cfg.dialTimeout)
+			defer cancel()
+		}
+		conn, err = dialer.DialContext(ctx, network, address)
+		return err
+	})
+	if err != nil {
+		return nil, attempts, dialError(address, err)
+	}
+	return conn, attempts, nil
+}
+
+// A byteCounter counts the bytes exchanged over one or more connections.
+type byteCounter struct {
+	sent     atomic.Uint64
+	received atomic.Uint64
+}
+
+// wrap returns a connection that counts the bytes read from and written
+// to conn.
+func (bc *byteCounter) wrap(conn net.Conn) net.Conn {
+	return &countingConn{Conn: conn, bc: bc}
+}
+
+// record sets the result's byte counts.
+func (bc *byteCounter) record(res *RHP4Result) {
+	res.BytesSent = bc.sent.Load()
+	res.BytesReceived = bc.received.Load()
+}
+
+// A countingConn is a net.Conn that counts the bytes exchanged.
+type countingConn struct {
+	net.Conn
+	bc *byteCounter
+}
+
+// Read implements net.Conn.
+func (c *countingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.bc.received.Add(uint64(n))
+	return n, err
+}
+
+// Write implements net.Conn.
+func (c *countingConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.bc.sent.Add(uint64(n))
+	return n, err
+}
+
+// fallbackResolver is the DNS server used for supplementary DNS checks if
+// no other DNS server is configured.
+const fallbackResolver = "1.1.1.1:53"
+
+// resolverAnswerTimeout is the maximum time to wait for every resolver
+// to answer.
+const resolverAnswerTimeout = 5 * time.Second
+
+// systemResolverName is the name used to configure the system resolver.
+const systemResolverName = "system"
+
+// A resolver looks up the IP addresses of a hostname.
+type resolver struct {
+	name string
+	// lookup resolves hostname to addresses of the network "ip", "ip4",
+	// or "ip6", following at most maxCNAMEDepth CNAME records if the
+	// resolver supports it.
+	lookup func(ctx context.Context, network, hostname string, maxCNAMEDepth int) ([]net.IP, error)
+}
+
+// defaultResolvers returns the system resolver and the fallback resolver.
+func defaultResolvers() []resolver {
+	return []resolver{systemResolver(), dnsResolver(fallbackResolver)}
+}
+
+// dnsServer returns the DNS server used for supplementary DNS checks: the
+// first configured resolver other than the system's, or fallbackResolver
+// if there is none. The system resolver cannot be queried directly.
+func (cfg scanConfig) dnsServer() string {
+	for _, r := range cfg.resolvers {
+		if r.name != systemResolverName {
+			return r.name
+		}
+	}
+	return fallbackResolver
+}
+
+// configuredResolver returns true if server is the resolver used for
+// supplementary DNS checks or one of the resolvers the server is configured
+// with.
+func (cfg scanConfig) configuredResolver(server string) bool {
+	return server == cfg.dnsServer() || slices.ContainsFunc(cfg.resolvers, func(r resolver) bool {
+		return r.name == server
+	})
+}
+
+// dnsContext returns a context for querying server over its own transport,
+// including DNS over TLS and HTTPS. A server that is not configured may only
+// be queried on the networks hosts may be tested on.
+func (cfg scanConfig) dnsContext(ctx context.Context, server string) context.Context {
+	if cfg.restrictsNetworks() && !cfg.configuredResolver(server) {
+		return dns.WithDialControl(ctx, networkControl(cfg))
+	}
+	return ctx
+}
+
+// systemResolver returns a resolver that uses the system's DNS
+// configuration.
+func systemResolver() resolver {
+	return resolver{
+		name: systemResolverName,
+		lookup: func(ctx context.Context, network, hostname string, _ int) ([]net.IP, error) {
+			ips, err := net.DefaultResolver.LookupIP(ctx, network, hostname)
+			var dnsErr *net.DNSError
+			if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+				return nil, dns.ErrNotFound
+			}
+			return ips, err
+		},
+	}
+}
+
+// dnsResolver returns a resolver that queries the DNS server at addr.
+func dnsResolver(addr string) resolver {
+	return resolver{
+		name: addr,
+		lookup: func(ctx context.Context, network, hostname string, maxCNAMEDepth int) ([]net.IP, error) {
+			ips, _, err := dns.LookupIPChain(ctx, addr, network, hostname, maxCNAMEDepth)
+			return ips, err
+		},
+	}
+}
+
+// A resolution is the result of resolving a hostname.
+type resolution struct {
+	ips        []net.IP
+	resolvedBy string
+	// elapsed is how long the lookup took, including retries. It is zero
+	// if the address is an IP literal, which needs no DNS queries.
+	elapsed time.Duration
+	// answers receives every resolver's answer once all of the lookups
+	// have completed
+	answers <-chan []ResolverAnswer
+}
+
+func lookupIPs(ctx context.Context, cfg scanConfig, family AddressFamily, addr string) (r resolution, attempts int, err error) {
+	resolvers := cfg.resolvers
+	if len(resolvers) == 0 {
+		resolvers = defaultResolvers()
+	}
+	start := time.Now()
+	attempts, err = cfg.retry.do(ctx, func() (err error) {
+		r, err = resolveIPs(ctx, resolvers, family.network(), addr, cfg.maxCNAMEDepth)
+		return err
+	})
+	if dns.ParseIP(addr) == nil {
+		r.elapsed = time.Since(start)
+	}
+	return
+}
+
+// resolveIPs queries each resolver concurrently and returns the first
+// non-empty answer. The remaining lookups continue in the background so
+// that every resolver's answer can be compared.
+func resolveIPs(ctx context.Context, resolvers []resolver, network, addr string, maxCNAMEDepth int) (resolution, error) {
+	ctx, cancel := context.WithTimeout(ctx, resolverAnswerTimeout)
+
+	type answer struct {
+		i   int
+		ips []net.IP
+		err error
+	}
+	answers := make(chan answer, len(resolvers))
+	for i, r := range resolvers {
+		go func(i int, r resolver) {
+			ips, err := r.lookup(ctx, network, addr, maxCNAMEDepth)
+			if err == nil && len(ips) == 0 {
+				err = dns.ErrNotFound
+			}
+			answers <- answer{i, ips, err}
+		}(i, r)
+	}
+
+	first := make(chan answer, 1)
+	all := make(chan []ResolverAnswer, 1)
+	go func() {
+		defer cancel()
+
+		collected := make([]ResolverAnswer, len(resolvers))
+		var answered bool
+		var errs []error
+		for range resolvers {
+			a := <-answers
+			collected[a.i].Resolver = resolvers[a.i].name
+			if a.err != nil {
+				collected[a.i].Error = a.err.Error()
+				errs = append(errs, a.err)
+				continue
+			}
+			for _, ip := range a.ips {
+				collected[a.i].Addresses = append(collected[a.i].Addresses, ip.String())
+			}
+			if !answered {
+				answered = true
+				first <- a
+			}
+		}
+
+		if !answered {
+			// prefer reporting a missing record over other failures
+			err := errs[len(errs)-1]
+			for _, e := range errs {
+				if errors.Is(e, dns.ErrNotFound) {
+					err = e
+					break
+				}
+			}
+			first <- answer{err: err}
+		}
+		all <- collected
+	}()
+
+	a := <-first
+	if a.err != nil {
+		return resolution{answers: all}, fmt.Errorf("failed to resolve host %q: %w", addr, a.err)
+	}
+	return resolution{ips: a.ips, resolvedBy: resolvers[a.i].name, answers: all}, nil
+}
+
+// checkResolverAnswers warns if the resolvers returned different addresses
+// for the same hostname.
+func checkResolverAnswers(hostname string, answers []ResolverAnswer, res *RHP4Result) {
+	sets := make(map[string]bool)
+	var descriptions []string
+	for _, a := range answers {
+		if a.Error != "" {
+			continue
+		}
+		addrs := slices.Clone(a.Addresses)
+		slices.Sort(addrs)
+		sets[strings.Join(addrs, ",")] = true
+		descriptions = append(descriptions, fmt.Sprintf("%s returned %s", a.Resolver, strings.Join(addrs, ", ")))
+	}
+	if len(sets) > 1 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("DNS resolvers disagree on the addresses of %q (%s): this may be caused by split-horizon DNS or records that are still propagating", hostname, strings.Join(descriptions, "; ")))
+	}
+}
+
+// nonRoutableKind returns a description of ip if it is not reachable from
+// the public internet, or an empty string otherwise.
+func nonRoutableKind(ip net.IP) string {
+	switch {
+	case ip.IsLoopback():
+		return "loopback"
+	case ip.IsPrivate():
+		return "private"
+	case ip.IsLinkLocalUnicast():
+		return "link-local"
+	case ip.IsUnspecified():
+		return "unspecified"
+	}
+	return ""
+}
+
+// checkRoutable warns for each resolved IP that renters will not be able
+// to reach.
+func checkRoutable(hostname string, ips []net.IP, res *RHP4Result) {
+	for _, ip := range ips {
+		if kind := nonRoutableKind(ip); kind != "" {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("%q resolves to %s address %s: renters will not be able to connect", hostname, kind, ip))
+		}
+	}
+}
+
+// restrictsNetworks returns true if hosts may only be tested on some
+// networks.
+func (cfg scanConfig) restrictsNetworks() bool {
+	return cfg.denyInternal || len(cfg.allowedNetworks) > 0 || len(cfg.deniedNetworks) > 0
+}
+
+// networkControl returns a dialer control function that checks the address
+// being connected to against the networks hosts may be tested on.
+func networkControl(cfg scanConfig) func(network, address string, c syscall.RawConn) error {
+	return func(_, address string, _ syscall.RawConn) error {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		// the host may include an IPv6 zone
+		return checkNetworks(cfg, []net.IP{dns.ParseIP(host)})
+	}
+}
+
+// checkNetworks returns an error if any of the addresses is internal and
+// internal addresses are denied, outside the allowed networks, or inside a
+// denied network.
+func checkNetworks(cfg scanConfig, ips []net.IP) error {
+	for _, ip := range ips {
+		if kind := nonRoutableKind(ip); cfg.denyInternal && kind != "" {
+			return fmt.Errorf("%s address %s is not allowed to be tested", kind, ip)
+		} else if len(cfg.allowedNetworks) > 0 && !slices.ContainsFunc(cfg.allowedNetworks, func(n *net.IPNet) bool { return n.Contains(ip) }) {
+			return fmt.Errorf("address %s is not in a network this server is allowed to test", ip)
+		}
+		for _, n := range cfg.deniedNetworks {
+			if n.Contains(ip) {
+				return fmt.Errorf("address %s is in %s, which this server is not allowed to test", ip, n)
+			}
+		}
+	}
+	return nil
+}
+
+// checkedDialAddress returns the address to dial for a host whose resolved
+// addresses have been checked. IPv4 addresses are preferred, matching how
+// the hostname would otherwise be resolved when dialing UDP.
+func checkedDialAddress(ips []net.IP, port string) string {
+	ip := ips[0]
+	if i := slices.IndexFunc(ips, func(ip net.IP) bool { return ip.To4() != nil }); i >= 0 {
+		ip = ips[i]
+	}
+	return net.JoinHostPort(ip.String(), port)
+}
+
+// parseExpectedIPs parses the IP addresses a host's hostnames are expected
+// to resolve to.
+func parseExpectedIPs(addrs []string) ([]net.IP, error) {
+	var ips []net.IP
+	for _, addr := range addrs {
+		ip := net.ParseIP(strings.Trim(addr, "[]"))
+		if ip == nil {
+			return nil, fmt.Errorf("expected IP %q is not a valid IP address", addr)
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// checkExpectedIPs adds an error for each expected IP in the family that
+// hostname did not resolve to, and for each resolved IP that was not
+// expected. Either usually means a DNS record is stale.
+func checkExpectedIPs(hostname string, ips, expected []net.IP, family AddressFamily, res *RHP4Result) {
+	if len(expected) == 0 {
+		return
+	}
+	for _, want := range expected {
+		if family.contains(want) && !slices.ContainsFunc(ips, want.Equal) {
+			res.Errors = append(res.Errors, fmt.Sprintf("%q does not resolve to the expected address %s: check DNS records or wait for propagation", hostname, want))
+		}
+	}
+	for _, ip := range ips {
+		if !slices.ContainsFunc(expected, ip.Equal) {
+			res.Errors = append(res.Errors, fmt.Sprintf("%q resolves to the unexpected address %s: check for stale DNS records", hostname, ip))
+		}
+	}
+}
+
+// dnsReport contains the results of the supplementary DNS checks.
+type dnsReport struct {
+	reverse    map[string][]string
+	cnameChain []string
+	warnings   []string
+}
+
+// longCNAMEChain is the number of CNAME records after which a chain is
+// considered unusually long. It is below dns.DefaultMaxCNAMEDepth so that
+// chains close to the limit are reported before they fail to resolve.
+const longCNAMEChain = 2
+
+// cnameConflictWarning returns a warning describing the records that
+// conflict with hostname's CNAME record, or an empty string if there are no
+// conflicts.
+func cnameConflictWarning(hostname string, conflicts []string) string {
+	switch {
+	case len(conflicts) == 0:
+		return ""
+	case slices.Contains(conflicts, "SOA") || slices.Contains(conflicts, "NS"):
+		return fmt.Sprintf("%q has a CNAME record at the zone apex: a CNAME cannot coexist with the zone's SOA and NS records, so some renters' resolvers will fail to resolve it. Use A and AAAA records or your DNS provider's CNAME flattening instead", hostname)
+	default:
+		return fmt.Sprintf("%q has a CNAME record alongside %s records: a CNAME must be the only record for a name, so some renters' resolvers will fail to resolve it", hostname, strings.Join(conflicts, ", "))
+	}
+}
+
+// dnssecWarning describes a hostname whose DNSSEC signatures do not
+// validate.
+func dnssecWarning(hostname string) string {
+	return fmt.Sprintf("DNSSEC validation failed for %q: validating resolvers, such as 1.1.1.1 and 8.8.8.8, will not resolve it. Check that the DS records at your registrar match the zone's signing keys, or remove them to disable DNSSEC", hostname)
+}
+
+// checkDNS runs supplementary DNS checks for the resolved IPs using the
+// given DNS server. The checks are best-effort and lookup errors are
+// ignored.
+func checkDNS(ctx context.Context, server, hostname string, ips []net.IP, maxCNAMEDepth int) (report dnsReport) {
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	report.reverse = make(map[string][]string)
+	for _, ip := range ips {
+		wg.Add(1)
+		go func(ip string) {
+			defer wg.Done()
+			ptr, err := dns.QueryPTR(ctx, server, ip)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			report.reverse[ip] = ptr
+			mu.Unlock()
+		}(ip.String())
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if wildcard, err := dns.HasWildcard(ctx, server, hostname, ips); err == nil && wildcard {
+			mu.Lock()
+			report.warnings = append(report.warnings, fmt.Sprintf("the DNS zone for %q has a wildcard record: resolution may succeed even if the record for this host is misconfigured", hostname))
+			mu.Unlock()
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		conflicts, err := dns.CNAMEConflicts(ctx, server, hostname)
+		if err != nil {
+			return
+		} else if warning := cnameConflictWarning(hostname, conflicts); warning != "" {
+			mu.Lock()
+			report.warnings = append(report.warnings, warning)
+			mu.Unlock()
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		if failure, err := dns.DNSSECFailure(ctx, server, hostname); err == nil && failure {
+			mu.Lock()
+			report.warnings = append(report.warnings, dnssecWarning(hostname))
+			mu.Unlock()
+		}
+	}()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_, chain, err := dns.LookupIPChain(ctx, server, "ip", hostname, maxCNAMEDepth)
+		if err != nil || len(chain) < 2 {
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		report.cnameChain = chain
+		if n := len(chain) - 1; n > longCNAMEChain {
+			report.warnings = append(report.warnings, fmt.Sprintf("%q is resolved through %d CNAME records (%s): long chains slow down lookups and are more likely to break", hostname, n, strings.Join(chain, " -> ")))
+		}
+	}()
+	wg.Wait()
+	return report
+}
+
+// lookupNetworkOwners looks up the registered owner of each IP's network.
+// The lookups are best-effort and errors are ignored.
+func lookupNetworkOwners(ctx context.Context, client *rdap.Client, ips []net.IP) map[string]NetworkOwner {
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	owners := make(map[string]NetworkOwner)
+	for _, ip := range ips {
+		wg.Add(1)
+		go func(ip net.IP) {
+			defer wg.Done()
+			network, err := client.Lookup(ctx, ip)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			owners[ip.String()] = NetworkOwner{
+				Network:      network.Name,
+				Organization: network.Organization,
+				AbuseContact: network.AbuseContact,
+			}
+			mu.Unlock()
+		}(ip)
+	}
+	wg.Wait()
+	return owners
+}
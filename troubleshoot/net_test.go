@@ -0,0 +1,721 @@
+package troubleshoot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	mdns "github.com/miekg/dns"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+	"go.sia.tech/troubleshootd/internal/dns"
+	"go.sia.tech/troubleshootd/internal/rdap"
+)
+
+func TestDialError(t *testing.T) {
+	const addr = "host.sia.tech:9984"
+
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"dns", &net.OpError{Op: "dial", Err: &net.DNSError{Err: "no such host", Name: "host.sia.tech", IsNotFound: true}}, "check DNS setup"},
+		{"refused", &net.OpError{Op: "dial", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED)}, "connection refused"},
+		{"timeout", dialTimeout(t), "timeout connecting"},
+		{"unknown", errors.New("unknown"), "failed to connect to host"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := dialError(addr, test.err)
+			if !strings.Contains(err.Error(), test.expected) {
+				t.Fatalf("expected error containing %q, got %q", test.expected, err)
+			} else if !strings.Contains(err.Error(), addr) {
+				t.Fatalf("expected error to contain the address, got %q", err)
+			}
+		})
+	}
+}
+
+func TestDialContextRefused(t *testing.T) {
+	// grab a free port and close the listener so the dial is refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	cfg := scanConfig{retry: retryPolicy{Attempts: 3}, dialTimeout: time.Second}
+	_, attempts, err := dialContext(context.Background(), cfg, "tcp", addr)
+	if err == nil {
+		t.Fatal("expected error")
+	} else if !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("expected connection refused, got %q", err)
+	} else if attempts != 1 {
+		t.Fatalf("expected refused connection to not be retried, got %d attempts", attempts)
+	}
+}
+
+func TestDialContextSourceAddress(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	remote := make(chan net.Addr, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		remote <- conn.RemoteAddr()
+		conn.Close()
+	}()
+
+	source := net.ParseIP("127.0.0.1")
+	cfg := scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second, sourceAddr: source}
+	conn, _, err := dialContext(context.Background(), cfg, "tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if local := conn.LocalAddr().(*net.TCPAddr); !local.IP.Equal(source) {
+		t.Fatalf("expected connection from %s, got %s", source, local.IP)
+	} else if addr := (<-remote).(*net.TCPAddr); !addr.IP.Equal(source) {
+		t.Fatalf("expected listener to see %s, got %s", source, addr.IP)
+	}
+
+	// an address of the other family cannot be dialed from the source
+	cfg.sourceAddr = net.ParseIP("::1")
+	if _, _, err := dialContext(context.Background(), cfg, "tcp", l.Addr().String()); err == nil {
+		t.Fatal("expected dialing an IPv4 address from an IPv6 source to fail")
+	}
+}
+
+type mockProxy struct {
+	addrs []string
+}
+
+func (mp *mockProxy) DialContext(_ context.Context, network, addr string) (net.Conn, error) {
+	mp.addrs = append(mp.addrs, addr)
+	return nil, errors.New("proxy unreachable")
+}
+
+func TestDialContextProxy(t *testing.T) {
+	mp := new(mockProxy)
+	cfg := scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second, proxy: mp}
+	if _, _, err := dialContext(context.Background(), cfg, "tcp", "203.0.113.10:9984"); err == nil {
+		t.Fatal("expected error")
+	} else if len(mp.addrs) != 1 || mp.addrs[0] != "203.0.113.10:9984" {
+		t.Fatalf("expected dial through proxy, got %v", mp.addrs)
+	}
+
+	// the proxy would resolve hostnames itself, so only checked IPs are
+	// proxied when networks are restricted
+	mp.addrs = nil
+	cfg.denyInternal = true
+	if _, _, err := dialContext(context.Background(), cfg, "tcp", "localhost:9984"); err == nil || !strings.Contains(err.Error(), "only resolved IP addresses") {
+		t.Fatalf("expected the hostname to be rejected, got %v", err)
+	} else if _, _, err := dialContext(context.Background(), cfg, "tcp", "127.0.0.1:9984"); err == nil || !strings.Contains(err.Error(), "loopback address 127.0.0.1 is not allowed") {
+		t.Fatalf("expected the loopback address to be rejected, got %v", err)
+	} else if len(mp.addrs) != 0 {
+		t.Fatalf("expected nothing to be proxied, got %v", mp.addrs)
+	}
+
+	// resolved hostnames are proxied by their checked IP
+	var res RHP4Result
+	p := scanParams{scanConfig: cfg}
+	p.resolvers = []resolver{{name: "mock", lookup: func(context.Context, string, string, int) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("203.0.113.10")}, nil
+	}}}
+	testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, &res)
+	if len(mp.addrs) != 1 || mp.addrs[0] != "203.0.113.10:9984" {
+		t.Fatalf("expected the resolved IP to be proxied, got %v", mp.addrs)
+	}
+}
+
+func TestLookupNetworkOwners(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ip/203.0.113.10" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"name":"TEST-NET-3","startAddress":"203.0.113.0","endAddress":"203.0.113.255"}`))
+	}))
+	t.Cleanup(srv.Close)
+
+	owners := lookupNetworkOwners(context.Background(), rdap.NewClient(srv.URL), []net.IP{net.ParseIP("203.0.113.10"), net.ParseIP("198.51.100.1")})
+	if len(owners) != 1 {
+		t.Fatalf("expected 1 owner, got %v", owners)
+	} else if owners["203.0.113.10"].Network != "TEST-NET-3" {
+		t.Fatalf("expected network %q, got %q", "TEST-NET-3", owners["203.0.113.10"].Network)
+	}
+}
+
+func TestCheckRoutable(t *testing.T) {
+	tests := []struct {
+		ip   string
+		kind string
+	}{
+		{"127.0.0.1", "loopback"},
+		{"::1", "loopback"},
+		{"10.0.0.5", "private"},
+		{"172.16.0.1", "private"},
+		{"192.168.1.10", "private"},
+		{"fd00::1", "private"},
+		{"169.254.1.1", "link-local"},
+		{"fe80::1", "link-local"},
+		{"0.0.0.0", "unspecified"},
+		{"203.0.113.10", ""},
+		{"2001:db8::1", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			var res RHP4Result
+			checkRoutable("host.sia.tech", []net.IP{net.ParseIP(test.ip)}, &res)
+			if test.kind == "" {
+				if len(res.Warnings) != 0 {
+					t.Fatalf("expected no warnings, got %v", res.Warnings)
+				}
+			} else if len(res.Warnings) != 1 || !strings.Contains(res.Warnings[0], test.kind+" address "+test.ip) {
+				t.Fatalf("expected %s warning, got %v", test.kind, res.Warnings)
+			}
+		})
+	}
+}
+
+func TestCheckNetworks(t *testing.T) {
+	parse := func(cidrs ...string) (networks []*net.IPNet) {
+		for _, cidr := range cidrs {
+			_, n, err := net.ParseCIDR(cidr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			networks = append(networks, n)
+		}
+		return
+	}
+
+	tests := []struct {
+		name    string
+		cfg     scanConfig
+		ips     []string
+		allowed bool
+	}{
+		{"unrestricted", scanConfig{}, []string{"10.0.0.5"}, true},
+		{"metadata", scanConfig{denyInternal: true}, []string{"169.254.169.254"}, false},
+		{"ipv6 metadata", scanConfig{denyInternal: true}, []string{"fd00:ec2::254"}, false},
+		{"loopback", scanConfig{denyInternal: true}, []string{"127.0.0.1"}, false},
+		{"ipv6 loopback", scanConfig{denyInternal: true}, []string{"::1"}, false},
+		{"private", scanConfig{denyInternal: true}, []string{"203.0.113.10", "192.168.1.10"}, false},
+		{"unspecified", scanConfig{denyInternal: true}, []string{"0.0.0.0"}, false},
+		{"public", scanConfig{denyInternal: true}, []string{"203.0.113.10", "2001:db8::1"}, true},
+		{"denied", scanConfig{deniedNetworks: parse("10.0.0.0/8", "127.0.0.0/8")}, []string{"203.0.113.10", "127.0.0.1"}, false},
+		{"inside allowlist", scanConfig{allowedNetworks: parse("203.0.113.0/24")}, []string{"203.0.113.10"}, true},
+		{"outside allowlist", scanConfig{allowedNetworks: parse("203.0.113.0/24")}, []string{"203.0.113.10", "198.51.100.1"}, false},
+		{"allowed but denied", scanConfig{allowedNetworks: parse("203.0.113.0/24"), deniedNetworks: parse("203.0.113.128/25")}, []string{"203.0.113.200"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var ips []net.IP
+			for _, ip := range test.ips {
+				ips = append(ips, net.ParseIP(ip))
+			}
+			if err := checkNetworks(test.cfg, ips); test.allowed && err != nil {
+				t.Fatalf("expected %v to be allowed, got %v", test.ips, err)
+			} else if !test.allowed && err == nil {
+				t.Fatalf("expected %v to be rejected", test.ips)
+			}
+		})
+	}
+}
+
+func TestTestRHP4DeniedNetwork(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			accepted <- struct{}{}
+			conn.Close()
+		}
+	}()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	_, loopback, _ := net.ParseCIDR("127.0.0.0/8")
+	p := scanParams{scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second, deniedNetworks: []*net.IPNet{loopback}}}
+	netAddr := chain.NetAddress{Protocol: siamux.Protocol, Address: l.Addr().String()}
+
+	var res RHP4Result
+	testRHP4(context.Background(), p, netAddr, &res)
+	if !hasIssue(res.Errors, "refusing to test") || !hasIssue(res.Errors, "not allowed to test") {
+		t.Fatalf("expected the address to be rejected, got %v", res.Errors)
+	} else if res.Connected {
+		t.Fatal("expected the address not to be dialed")
+	}
+
+	// override addresses are checked too
+	p.overrideIP = net.ParseIP("127.0.0.1")
+	res = RHP4Result{}
+	testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:" + port}, &res)
+	if !hasIssue(res.Errors, "refusing to test override address") {
+		t.Fatalf("expected the override address to be rejected, got %v", res.Errors)
+	}
+
+	if _, err := fetchSettings(context.Background(), p.scanConfig, types.PublicKey{}, netAddr, nil); err == nil || !strings.Contains(err.Error(), "refusing to connect") {
+		t.Fatalf("expected fetching settings to be refused, got %v", err)
+	}
+
+	select {
+	case <-accepted:
+		t.Fatal("expected no connections")
+	default:
+	}
+}
+
+func TestInternalAddressesDenied(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	accepted := make(chan struct{}, 1)
+	go func() {
+		if conn, err := l.Accept(); err == nil {
+			accepted <- struct{}{}
+			conn.Close()
+		}
+	}()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	cfg := scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second, denyInternal: true}
+	var res RHP4Result
+	testRHP4(context.Background(), scanParams{scanConfig: cfg}, chain.NetAddress{Protocol: siamux.Protocol, Address: "127.0.0.1:" + port}, &res)
+	if !hasIssue(res.Errors, "loopback address 127.0.0.1 is not allowed to be tested") {
+		t.Fatalf("expected the loopback address to be rejected, got %v", res.Errors)
+	}
+
+	// the address is checked again when connecting, in case the hostname
+	// resolves to a different address than when it was checked
+	if _, _, err := dialContext(context.Background(), cfg, "tcp", "localhost:"+port); err == nil || !strings.Contains(err.Error(), "is not allowed to be tested") {
+		t.Fatalf("expected the connection to be refused, got %v", err)
+	}
+
+	select {
+	case <-accepted:
+		t.Fatal("expected no connections")
+	default:
+	}
+
+	// trusted deployments can allow internal addresses
+	cfg.denyInternal = false
+	conn, _, err := dialContext(context.Background(), cfg, "tcp", "127.0.0.1:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+}
+
+func TestCheckedDialAddress(t *testing.T) {
+	tests := []struct {
+		ips      []string
+		expected string
+	}{
+		{[]string{"203.0.113.10"}, "203.0.113.10:9984"},
+		{[]string{"2001:db8::1"}, "[2001:db8::1]:9984"},
+		{[]string{"2001:db8::1", "203.0.113.10"}, "203.0.113.10:9984"},
+	}
+	for _, test := range tests {
+		var ips []net.IP
+		for _, ip := range test.ips {
+			ips = append(ips, net.ParseIP(ip))
+		}
+		if addr := checkedDialAddress(ips, "9984"); addr != test.expected {
+			t.Errorf("expected %v to dial %q, got %q", test.ips, test.expected, addr)
+		}
+	}
+}
+
+func TestCheckExpectedIPs(t *testing.T) {
+	ips := func(addrs ...string) []net.IP {
+		parsed, err := parseExpectedIPs(addrs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+
+	tests := []struct {
+		name     string
+		resolved []net.IP
+		expected []net.IP
+		family   AddressFamily
+		errors   []string
+	}{
+		{"match", ips("203.0.113.10", "2001:db8::1"), ips("2001:db8::1", "203.0.113.10"), AddressFamilyAny, nil},
+		{"not checked", ips("203.0.113.10"), nil, AddressFamilyAny, nil},
+		{"missing", ips("203.0.113.10"), ips("203.0.113.10", "203.0.113.11"), AddressFamilyAny, []string{"does not resolve to the expected address 203.0.113.11"}},
+		{"unexpected", ips("203.0.113.10", "198.51.100.1"), ips("203.0.113.10"), AddressFamilyAny, []string{"resolves to the unexpected address 198.51.100.1"}},
+		{"stale", ips("198.51.100.1"), ips("203.0.113.10"), AddressFamilyAny, []string{"expected address 203.0.113.10", "unexpected address 198.51.100.1"}},
+		// expected addresses of the other family are not resolved
+		{"family", ips("203.0.113.10"), ips("203.0.113.10", "2001:db8::1"), AddressFamilyIPv4, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var res RHP4Result
+			checkExpectedIPs("host.sia.tech", test.resolved, test.expected, test.family, &res)
+			if len(res.Errors) != len(test.errors) {
+				t.Fatalf("expected %d errors, got %v", len(test.errors), res.Errors)
+			}
+			for _, want := range test.errors {
+				if !hasIssue(res.Errors, want) {
+					t.Fatalf("expected error containing %q, got %v", want, res.Errors)
+				}
+			}
+		})
+	}
+
+	if _, err := parseExpectedIPs([]string{"host.sia.tech"}); err == nil {
+		t.Fatal("expected an invalid IP to be rejected")
+	}
+}
+
+func TestResolveIPs(t *testing.T) {
+	answer := func(name string, delay time.Duration, ips ...string) resolver {
+		return resolver{
+			name: name,
+			lookup: func(ctx context.Context, _, _ string, _ int) ([]net.IP, error) {
+				select {
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				case <-time.After(delay):
+				}
+				var parsed []net.IP
+				for _, ip := range ips {
+					parsed = append(parsed, net.ParseIP(ip))
+				}
+				return parsed, nil
+			},
+		}
+	}
+	failing := resolver{
+		name: "failing",
+		lookup: func(context.Context, string, string, int) ([]net.IP, error) {
+			return nil, errors.New("server misbehaving")
+		},
+	}
+
+	fast := answer("fast", 0, "203.0.113.10")
+	slow := answer("slow", 500*time.Millisecond, "203.0.113.10")
+	empty := answer("empty", 0)
+
+	start := time.Now()
+	lookup, err := resolveIPs(context.Background(), []resolver{slow, empty, fast}, "ip", "host.sia.tech", dns.DefaultMaxCNAMEDepth)
+	if err != nil {
+		t.Fatal(err)
+	} else if lookup.resolvedBy != "fast" {
+		t.Fatalf("expected fast resolver to answer, got %q", lookup.resolvedBy)
+	} else if len(lookup.ips) != 1 || !lookup.ips[0].Equal(net.ParseIP("203.0.113.10")) {
+		t.Fatalf("expected fast resolver's answer, got %v", lookup.ips)
+	} else if time.Since(start) >= 500*time.Millisecond {
+		t.Fatal("expected lookup to not wait for the slow resolver")
+	}
+
+	// every resolver's answer should be collected in order
+	answers := <-lookup.answers
+	if len(answers) != 3 {
+		t.Fatalf("expected 3 answers, got %v", answers)
+	} else if answers[0].Resolver != "slow" || len(answers[0].Addresses) != 1 {
+		t.Fatalf("expected slow resolver's answer, got %+v", answers[0])
+	} else if answers[1].Resolver != "empty" || answers[1].Error == "" {
+		t.Fatalf("expected empty resolver to report an error, got %+v", answers[1])
+	}
+
+	var res RHP4Result
+	checkResolverAnswers("host.sia.tech", answers, &res)
+	if len(res.Warnings) != 0 {
+		t.Fatalf("expected matching answers to not warn, got %v", res.Warnings)
+	}
+
+	// a missing record should be reported over other failures
+	if _, err := resolveIPs(context.Background(), []resolver{empty, failing}, "ip", "host.sia.tech", dns.DefaultMaxCNAMEDepth); !errors.Is(err, dns.ErrNotFound) {
+		t.Fatalf("expected %q, got %v", dns.ErrNotFound, err)
+	} else if _, err := resolveIPs(context.Background(), []resolver{failing}, "ip", "host.sia.tech", dns.DefaultMaxCNAMEDepth); err == nil || !strings.Contains(err.Error(), "server misbehaving") {
+		t.Fatalf("expected resolver error, got %v", err)
+	}
+}
+
+func TestCheckResolverAnswers(t *testing.T) {
+	tests := []struct {
+		name    string
+		answers []ResolverAnswer
+		warn    bool
+	}{
+		{"single", []ResolverAnswer{{Resolver: "system", Addresses: []string{"203.0.113.10"}}}, false},
+		{"matching", []ResolverAnswer{
+			{Resolver: "system", Addresses: []string{"203.0.113.10", "2001:db8::1"}},
+			{Resolver: "1.1.1.1:53", Addresses: []string{"2001:db8::1", "203.0.113.10"}},
+		}, false},
+		{"conflicting", []ResolverAnswer{
+			{Resolver: "system", Addresses: []string{"192.168.1.10"}},
+			{Resolver: "1.1.1.1:53", Addresses: []string{"203.0.113.10"}},
+		}, true},
+		{"failed", []ResolverAnswer{
+			{Resolver: "system", Addresses: []string{"203.0.113.10"}},
+			{Resolver: "1.1.1.1:53", Error: "i/o timeout"},
+		}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var res RHP4Result
+			checkResolverAnswers("host.sia.tech", test.answers, &res)
+			if !test.warn && len(res.Warnings) != 0 {
+				t.Fatalf("expected no warnings, got %v", res.Warnings)
+			} else if test.warn && !hasIssue(res.Warnings, "system returned 192.168.1.10; 1.1.1.1:53 returned 203.0.113.10") {
+				t.Fatalf("expected conflicting answers to be listed, got %v", res.Warnings)
+			}
+		})
+	}
+}
+
+func TestCNAMEConflictWarning(t *testing.T) {
+	tests := []struct {
+		name      string
+		conflicts []string
+		contains  string
+	}{
+		{"none", nil, ""},
+		{"apex", []string{"SOA", "NS"}, "CNAME record at the zone apex"},
+		{"other records", []string{"A", "TXT"}, "alongside A, TXT records"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			warning := cnameConflictWarning("sia.tech", test.conflicts)
+			if test.contains == "" && warning != "" {
+				t.Fatalf("expected no warning, got %q", warning)
+			} else if !strings.Contains(warning, test.contains) {
+				t.Fatalf("expected warning containing %q, got %q", test.contains, warning)
+			}
+		})
+	}
+}
+
+// newTestDNSServer starts a local DNS server with a CNAME, a wildcard
+// zone, a CNAME at a zone apex, and a name with invalid DNSSEC signatures,
+// and returns its address.
+func newTestDNSServer(t *testing.T) string {
+	t.Helper()
+
+	records := []mdns.RR{
+		&mdns.CNAME{Hdr: mdns.RR_Header{Name: "www.sia.test.", Rrtype: mdns.TypeCNAME, Class: mdns.ClassINET}, Target: "host.sia.test."},
+		&mdns.A{Hdr: mdns.RR_Header{Name: "host.sia.test.", Rrtype: mdns.TypeA, Class: mdns.ClassINET}, A: net.ParseIP("203.0.113.10")},
+		&mdns.AAAA{Hdr: mdns.RR_Header{Name: "host.sia.test.", Rrtype: mdns.TypeAAAA, Class: mdns.ClassINET}, AAAA: net.ParseIP("2001:db8::10")},
+		&mdns.CNAME{Hdr: mdns.RR_Header{Name: "apex.test.", Rrtype: mdns.TypeCNAME, Class: mdns.ClassINET}, Target: "host.sia.test."},
+		&mdns.CNAME{Hdr: mdns.RR_Header{Name: "long.sia.test.", Rrtype: mdns.TypeCNAME, Class: mdns.ClassINET}, Target: "lb.sia.test."},
+		&mdns.CNAME{Hdr: mdns.RR_Header{Name: "lb.sia.test.", Rrtype: mdns.TypeCNAME, Class: mdns.ClassINET}, Target: "www.sia.test."},
+		&mdns.SOA{Hdr: mdns.RR_Header{Name: "apex.test.", Rrtype: mdns.TypeSOA, Class: mdns.ClassINET}, Ns: "ns.apex.test.", Mbox: "admin.apex.test."},
+	}
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &mdns.Server{
+		PacketConn: pc,
+		Handler: mdns.HandlerFunc(func(w mdns.ResponseWriter, req *mdns.Msg) {
+			resp := new(mdns.Msg)
+			resp.SetReply(req)
+			q := req.Question[0]
+			// bogus.sia.test only resolves if validation is disabled
+			if q.Name == "bogus.sia.test." {
+				if !req.CheckingDisabled {
+					resp.Rcode = mdns.RcodeServerFailure
+				} else if q.Qtype == mdns.TypeA {
+					resp.Answer = append(resp.Answer, &mdns.A{Hdr: mdns.RR_Header{Name: q.Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET}, A: net.ParseIP("203.0.113.30")})
+				}
+				w.WriteMsg(resp)
+				return
+			}
+			for _, rr := range records {
+				if hdr := rr.Header(); hdr.Name == q.Name && hdr.Rrtype == q.Qtype {
+					resp.Answer = append(resp.Answer, rr)
+				}
+			}
+			// every name in wild.test resolves
+			if strings.HasSuffix(q.Name, ".wild.test.") && q.Qtype == mdns.TypeA {
+				resp.Answer = append(resp.Answer, &mdns.A{Hdr: mdns.RR_Header{Name: q.Name, Rrtype: mdns.TypeA, Class: mdns.ClassINET}, A: net.ParseIP("203.0.113.20")})
+			}
+			w.WriteMsg(resp)
+		}),
+	}
+	started := make(chan struct{})
+	srv.NotifyStartedFunc = func() { close(started) }
+	go srv.ActivateAndServe()
+	t.Cleanup(func() { srv.Shutdown() })
+	<-started
+	return pc.LocalAddr().String()
+}
+
+func TestLookupDNS(t *testing.T) {
+	resolver := newTestDNSServer(t)
+	m := newTestManager(t, fakeExplorer{}, "v2.1.0")
+
+	tests := []struct {
+		hostname   string
+		recordType string
+		addresses  []string
+		chain      []string
+		warning    string
+		err        string
+	}{
+		{hostname: "host.sia.test", addresses: []string{"203.0.113.10", "2001:db8::10"}},
+		{hostname: "host.sia.test", recordType: "aaaa", addresses: []string{"2001:db8::10"}},
+		{hostname: "www.sia.test.", recordType: "A", addresses: []string{"203.0.113.10"}, chain: []string{"www.sia.test", "host.sia.test"}},
+		{hostname: "long.sia.test", recordType: "A", addresses: []string{"203.0.113.10"}, chain: []string{"long.sia.test", "lb.sia.test", "www.sia.test", "host.sia.test"}, warning: "resolved through 3 CNAME records"},
+		{hostname: "host.wild.test", addresses: []string{"203.0.113.20"}, warning: "wildcard record"},
+		{hostname: "apex.test", addresses: []string{"203.0.113.10", "2001:db8::10"}, chain: []string{"apex.test", "host.sia.test"}, warning: "CNAME record at the zone apex"},
+		{hostname: "missing.sia.test", err: "no such host"},
+		{hostname: "bogus.sia.test", err: "DNSSEC validation failed"},
+		{hostname: "host.sia.test", recordType: "MX", err: "unsupported record type"},
+	}
+	for _, test := range tests {
+		t.Run(test.hostname+"/"+test.recordType, func(t *testing.T) {
+			lookup, err := m.LookupDNS(context.Background(), test.hostname, resolver, test.recordType)
+			if test.err != "" {
+				if err == nil || !strings.Contains(err.Error(), test.err) {
+					t.Fatalf("expected error containing %q, got %v", test.err, err)
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			} else if lookup.Resolver != resolver || lookup.RecordType != strings.ToUpper(test.recordType) {
+				t.Fatalf("unexpected lookup %+v", lookup)
+			} else if !slices.Equal(lookup.Addresses, test.addresses) {
+				t.Fatalf("expected addresses %v, got %v", test.addresses, lookup.Addresses)
+			} else if !slices.Equal(lookup.CNAMEChain, test.chain) {
+				t.Fatalf("expected CNAME chain %v, got %v", test.chain, lookup.CNAMEChain)
+			} else if lookup.ResolveTime <= 0 {
+				t.Fatal("expected the resolve time to be set")
+			} else if test.warning == "" && len(lookup.Warnings) != 0 {
+				t.Fatalf("expected no warnings, got %v", lookup.Warnings)
+			} else if test.warning != "" && !hasIssue(lookup.Warnings, test.warning) {
+				t.Fatalf("expected warning containing %q, got %v", test.warning, lookup.Warnings)
+			}
+		})
+	}
+
+	if _, err := m.LookupDNS(context.Background(), "host.sia.test", "1.1.1.1", ""); err == nil {
+		t.Fatal("expected an invalid resolver to be rejected")
+	}
+
+	// resolvers chosen by the caller are subject to the same network
+	// restrictions as hosts
+	restricted := newTestManager(t, fakeExplorer{}, "v2.1.0", WithInternalAddresses(false))
+	if _, err := restricted.LookupDNS(context.Background(), "host.sia.test", resolver, ""); err == nil || !strings.Contains(err.Error(), "loopback address 127.0.0.1 is not allowed") {
+		t.Fatalf("expected the loopback resolver to be rejected, got %v", err)
+	} else if _, err := restricted.LookupDNS(context.Background(), "host.sia.test", "https://127.0.0.1/dns-query", ""); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Fatalf("expected the loopback DNS over HTTPS resolver to be rejected, got %v", err)
+	}
+	// configured resolvers are trusted
+	WithResolvers(resolver)(restricted)
+	if _, err := restricted.LookupDNS(context.Background(), "host.sia.test", resolver, ""); err != nil {
+		t.Fatalf("expected a configured resolver to be allowed, got %v", err)
+	}
+}
+
+func TestDNSServer(t *testing.T) {
+	tests := []struct {
+		name      string
+		resolvers []resolver
+		expected  string
+	}{
+		{"none", nil, fallbackResolver},
+		{"system", []resolver{systemResolver()}, fallbackResolver},
+		{"configured", []resolver{systemResolver(), dnsResolver("tls://9.9.9.9"), dnsResolver("8.8.8.8:53")}, "tls://9.9.9.9"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if server := (scanConfig{resolvers: test.resolvers}).dnsServer(); server != test.expected {
+				t.Fatalf("expected %q, got %q", test.expected, server)
+			}
+		})
+	}
+
+	// the supplementary checks of a host test query the configured
+	// resolver
+	server := newTestDNSServer(t)
+	p := scanParams{scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: 100 * time.Millisecond, resolvers: []resolver{dnsResolver(server)}}}
+	var res RHP4Result
+	testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.wild.test:9984"}, &res)
+	if !hasIssue(res.Warnings, `the DNS zone for "host.wild.test" has a wildcard record`) {
+		t.Fatalf("expected a wildcard warning from the configured resolver, got %v", res.Warnings)
+	}
+}
+
+func TestDNSContext(t *testing.T) {
+	server := newTestDNSServer(t)
+	cfg := scanConfig{denyInternal: true}
+	// a server that is not configured is subject to the network
+	// restrictions
+	if _, err := dns.QueryA(cfg.dnsContext(context.Background(), server), server, "host.sia.test"); err == nil || !strings.Contains(err.Error(), "is not allowed") {
+		t.Fatalf("expected the loopback server to be rejected, got %v", err)
+	}
+	// configured servers, including the one used for the supplementary
+	// checks, are not
+	cfg.resolvers = []resolver{systemResolver(), dnsResolver(server)}
+	if cfg.dnsServer() != server {
+		t.Fatalf("expected the supplementary checks to use %q, got %q", server, cfg.dnsServer())
+	} else if _, err := dns.QueryA(cfg.dnsContext(context.Background(), server), server, "host.sia.test"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckDNSSEC(t *testing.T) {
+	resolver := newTestDNSServer(t)
+	if report := checkDNS(context.Background(), resolver, "host.sia.test", nil, dns.DefaultMaxCNAMEDepth); hasIssue(report.warnings, "DNSSEC") {
+		t.Fatalf("expected no DNSSEC warning, got %v", report.warnings)
+	} else if report := checkDNS(context.Background(), resolver, "bogus.sia.test", nil, dns.DefaultMaxCNAMEDepth); !hasIssue(report.warnings, `DNSSEC validation failed for "bogus.sia.test"`) {
+		t.Fatalf("expected a DNSSEC warning, got %v", report.warnings)
+	}
+}
+
+func TestByteCounter(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	go func() {
+		buf := make([]byte, 5)
+		io.ReadFull(server, buf)
+		server.Write([]byte("abc"))
+	}()
+
+	var bc byteCounter
+	conn := bc.wrap(client)
+	if _, err := conn.Write([]byte("hello")); err != nil {
+		t.Fatal(err)
+	} else if _, err := io.ReadFull(conn, make([]byte, 3)); err != nil {
+		t.Fatal(err)
+	}
+
+	var res RHP4Result
+	bc.record(&res)
+	if res.BytesSent != 5 || res.BytesReceived != 3 {
+		t.Fatalf("expected 5 bytes sent and 3 received, got %d and %d", res.BytesSent, res.BytesReceived)
+	}
+}
@@ -0,0 +1,284 @@
+package troubleshoot
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/troubleshootd/internal/rdap"
+	"golang.org/x/net/proxy"
+)
+
+// An Option configures a Manager.
+type Option func(*Manager)
+
+// WithMaxConcurrentScans sets the maximum number of address tests that can
+// run concurrently across all requests. Tests beyond the limit wait for a
+// slot to become available. If n is not positive, the default limit is
+// kept.
+func WithMaxConcurrentScans(n int) Option {
+	return func(m *Manager) {
+		if n <= 0 {
+			return
+		}
+		m.scanSem = make(chan struct{}, n)
+	}
+}
+
+// WithMaxAddresses sets the maximum number of RHP4 addresses tested per
+// request. Addresses beyond the limit are not tested and the result is
+// warned. If n is zero, the number of addresses is not limited.
+func WithMaxAddresses(n int) Option {
+	return func(m *Manager) {
+		m.maxAddresses = n
+	}
+}
+
+// WithRetries sets the maximum number of attempts for resolving and
+// connecting to a host. Only transient failures, such as timeouts, are
+// retried. The delay between attempts starts at backoff and doubles after
+// each attempt.
+func WithRetries(attempts int, backoff time.Duration) Option {
+	return func(m *Manager) {
+		m.cfg.retry = retryPolicy{Attempts: attempts, Backoff: backoff}
+	}
+}
+
+// WithDefaultPorts enables assuming the protocol's default port when an
+// address does not specify one. When disabled, addresses without a port
+// are rejected.
+func WithDefaultPorts(enabled bool) Option {
+	return func(m *Manager) {
+		m.cfg.defaultPorts = enabled
+	}
+}
+
+// WithDialTimeout sets the timeout for each TCP connection attempt. If the
+// request's deadline is sooner, it takes precedence.
+func WithDialTimeout(d time.Duration) Option {
+	return func(m *Manager) {
+		m.cfg.dialTimeout = d
+	}
+}
+
+// WithStepTimeouts sets the timeouts for the transport handshake and the
+// settings scan of each address. A step reaching its timeout is reported
+// as the host not responding, separately from the request's deadline. If
+// a timeout is zero, the step is only bounded by the request's deadline.
+func WithStepTimeouts(handshake, settings time.Duration) Option {
+	return func(m *Manager) {
+		m.cfg.handshakeTimeout = handshake
+		m.cfg.settingsTimeout = settings
+	}
+}
+
+// WithCooldown sets the minimum time between tests of the same host.
+func WithCooldown(d time.Duration) Option {
+	return func(m *Manager) {
+		m.cooldownPeriod = d
+	}
+}
+
+// WithResultCacheTTL sets how long the result of a test is reused for
+// repeat requests for the same host and addresses. Cached results are
+// returned instead of a [CooldownError] unless the request sets
+// [Host.Force]. If ttl is zero, results are not cached.
+func WithResultCacheTTL(ttl time.Duration) Option {
+	return func(m *Manager) {
+		m.cache = newResultCache(ttl)
+	}
+}
+
+// WithPriceLimits sets the prices above which a host's pricing is
+// considered unreasonable.
+func WithPriceLimits(limits PriceLimits) Option {
+	return func(m *Manager) {
+		m.cfg.priceLimits = limits
+	}
+}
+
+// WithThresholds sets the thresholds at which a host's settings are
+// considered unfavorable for renters.
+func WithThresholds(thresholds Thresholds) Option {
+	return func(m *Manager) {
+		m.cfg.thresholds = thresholds
+	}
+}
+
+// WithScoreWeights sets the points deducted from a result's score for
+// each problem found.
+func WithScoreWeights(weights ScoreWeights) Option {
+	return func(m *Manager) {
+		m.cfg.scoreWeights = weights
+	}
+}
+
+// WithHistory sets the number of recent results kept for each host and
+// the maximum number of hosts whose results are kept. When the limit is
+// reached, the least recently tested host is evicted. If either is zero,
+// no results are kept.
+func WithHistory(size, maxHosts int) Option {
+	return func(m *Manager) {
+		m.history = newHistory(size, maxHosts)
+	}
+}
+
+// WithFlapDetection adds a warning to a host's result when its
+// reachability has changed at least transitions times within the window,
+// including the current test. If transitions is zero, flapping is not
+// detected. Detection requires the host's recent results to be kept, see
+// [WithHistory].
+func WithFlapDetection(transitions int, window time.Duration) Option {
+	return func(m *Manager) {
+		m.flapTransitions = transitions
+		m.flapWindow = window
+	}
+}
+
+// WithExplorerRetries sets the maximum number of attempts for explorer
+// requests, the delay before the first retry, and the timeout of each
+// attempt. The delay doubles after each attempt. If timeout is zero,
+// attempts are not bounded.
+func WithExplorerRetries(attempts int, backoff, timeout time.Duration) Option {
+	return func(m *Manager) {
+		m.explorerRetry = retryPolicy{Attempts: attempts, Backoff: backoff}
+		m.explorerTimeout = timeout
+	}
+}
+
+// WithHardforkWarningWindow sets how many blocks before the v2 hardfork's
+// require height a warning is added for hosts without a working RHP4
+// address. If blocks is zero, no warning is added.
+func WithHardforkWarningWindow(blocks uint64) Option {
+	return func(m *Manager) {
+		m.cfg.hardforkWindow = blocks
+	}
+}
+
+// WithPrereleases includes hostd pre-releases when determining the latest
+// release hosts are compared against. Hosts running a release newer than
+// the latest are never considered outdated.
+func WithPrereleases(enabled bool) Option {
+	return func(m *Manager) {
+		m.prereleases = enabled
+	}
+}
+
+// WithDisabledProtocols disables testing the given protocols, for example if
+// the server's network blocks UDP. Addresses using them are skipped with a
+// note instead of failing.
+func WithDisabledProtocols(protocols ...chain.Protocol) Option {
+	return func(m *Manager) {
+		m.cfg.disabledProtocols = make(map[chain.Protocol]bool)
+		for _, proto := range protocols {
+			m.cfg.disabledProtocols[proto] = true
+		}
+	}
+}
+
+// WithProxy routes TCP connections to hosts through the given dialer, such
+// as a SOCKS5 proxy. QUIC connections cannot be proxied and are always made
+// directly.
+func WithProxy(dialer proxy.ContextDialer) Option {
+	return func(m *Manager) {
+		m.cfg.proxy = dialer
+	}
+}
+
+// WithSourceAddress makes TCP connections to hosts from the given local
+// address, for example to test reachability from a specific network on a
+// multi-homed server. Hosts resolving only to the other address family
+// cannot be reached. The address does not apply to proxied connections, and
+// QUIC connections always use the default interface.
+func WithSourceAddress(ip net.IP) Option {
+	return func(m *Manager) {
+		m.cfg.sourceAddr = ip
+	}
+}
+
+// WithInternalAddresses allows testing hosts that resolve to loopback,
+// private, or link-local addresses. It is disabled by default, since it
+// lets callers reach the server's internal network and cloud metadata
+// endpoints, and should only be enabled for trusted deployments.
+func WithInternalAddresses(allowed bool) Option {
+	return func(m *Manager) {
+		m.cfg.denyInternal = !allowed
+	}
+}
+
+// WithNetworkRestrictions limits the networks hosts are tested on. If
+// allowed is not empty, hosts must only resolve to addresses inside it.
+// Hosts resolving to an address inside denied are never dialed. Internal
+// addresses are denied separately, see [WithInternalAddresses].
+func WithNetworkRestrictions(allowed, denied []*net.IPNet) Option {
+	return func(m *Manager) {
+		m.cfg.allowedNetworks = allowed
+		m.cfg.deniedNetworks = denied
+	}
+}
+
+// WithNetworkOwnerLookup enables looking up the registered owner and abuse
+// contact of each resolved address using RDAP. Lookups are best-effort and
+// do not affect the result of the test.
+func WithNetworkOwnerLookup(enabled bool) Option {
+	return func(m *Manager) {
+		if !enabled {
+			m.cfg.rdap = nil
+			return
+		}
+		m.cfg.rdap = rdap.NewClient(rdap.DefaultBaseURL)
+	}
+}
+
+// WithResolvers sets the DNS servers used to resolve host addresses. Each
+// server is an address such as "1.1.1.1:53", a DNS over TLS server such as
+// "tls://1.1.1.1", a DNS over HTTPS URL such as
+// "https://cloudflare-dns.com/dns-query", or "system" to use the system's
+// resolver. The servers are queried concurrently and the first answer is
+// used.
+func WithResolvers(servers ...string) Option {
+	return func(m *Manager) {
+		m.cfg.resolvers = nil
+		for _, server := range servers {
+			server = strings.TrimSpace(server)
+			if server == "" {
+				continue
+			} else if server == systemResolverName {
+				m.cfg.resolvers = append(m.cfg.resolvers, systemResolver())
+			} else {
+				m.cfg.resolvers = append(m.cfg.resolvers, dnsResolver(server))
+			}
+		}
+	}
+}
+
+// WithMaxCNAMEDepth sets the maximum number of CNAME records followed when
+// resolving a hostname. Hostnames with longer chains fail to resolve.
+func WithMaxCNAMEDepth(n int) Option {
+	return func(m *Manager) {
+		m.cfg.maxCNAMEDepth = n
+	}
+}
+
+// WithWebhook posts the result of each test that finds errors to url. If
+// warnings is true, results that only contain warnings are posted too.
+// Delivery is best-effort.
+func WithWebhook(url string, warnings bool) Option {
+	return func(m *Manager) {
+		m.webhook = &webhook{
+			url:      url,
+			warnings: warnings,
+			client:   &http.Client{},
+		}
+	}
+}
+
+// WithECSResolver sets the DNS server used for client subnet lookups. It
+// must support the EDNS0 client subnet option.
+func WithECSResolver(addr string) Option {
+	return func(m *Manager) {
+		m.ecsResolver = addr
+	}
+}
@@ -0,0 +1,81 @@
+package troubleshoot
+
+import (
+	"fmt"
+
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+)
+
+const (
+	bytesPerTB     = 1e12
+	blocksPerMonth = 144 * 30
+)
+
+// defaultPriceLimits are well above the prices of hosts on the network.
+// Prices above them are almost certainly a misconfiguration.
+var defaultPriceLimits = PriceLimits{
+	StoragePrice: types.Siacoins(10000),
+	IngressPrice: types.Siacoins(10000),
+	EgressPrice:  types.Siacoins(10000),
+}
+
+// mulSaturating returns c*v, or types.MaxCurrency if the result
+// overflows.
+func mulSaturating(c types.Currency, v uint64) types.Currency {
+	r, overflow := c.Mul64WithOverflow(v)
+	if overflow {
+		return types.MaxCurrency
+	}
+	return r
+}
+
+// convertPrices converts the host's prices to per-TB and per-month values.
+func convertPrices(prices proto4.HostPrices) *Pricing {
+	return &Pricing{
+		StoragePrice: mulSaturating(prices.StoragePrice, bytesPerTB*blocksPerMonth),
+		Collateral:   mulSaturating(prices.Collateral, bytesPerTB*blocksPerMonth),
+		IngressPrice: mulSaturating(prices.IngressPrice, bytesPerTB),
+		EgressPrice:  mulSaturating(prices.EgressPrice, bytesPerTB),
+	}
+}
+
+// checkPriceLimits adds a warning for each price that exceeds its limit.
+func checkPriceLimits(pricing Pricing, limits PriceLimits, res *RHP4Result) {
+	check := func(name string, price, limit types.Currency, unit string) {
+		if !limit.IsZero() && price.Cmp(limit) > 0 {
+			res.Warnings = append(res.Warnings, fmt.Sprintf("host's %s of %s/%s is higher than %s/%s", name, price, unit, limit, unit))
+		}
+	}
+	check("storage price", pricing.StoragePrice, limits.StoragePrice, "TB/month")
+	check("ingress price", pricing.IngressPrice, limits.IngressPrice, "TB")
+	check("egress price", pricing.EgressPrice, limits.EgressPrice, "TB")
+}
+
+// formatSize formats a number of bytes in TB.
+func formatSize(size uint64) string {
+	return fmt.Sprintf("%g TB", float64(size)/bytesPerTB)
+}
+
+// contractCost returns the cost of storing size bytes with the host for
+// duration blocks.
+func contractCost(prices proto4.HostPrices, duration, size uint64) *ContractCost {
+	return &ContractCost{
+		Duration:    duration,
+		DataSize:    size,
+		StorageCost: mulSaturating(mulSaturating(prices.StoragePrice, size), duration),
+		UploadCost:  mulSaturating(prices.IngressPrice, size),
+		Collateral:  mulSaturating(mulSaturating(prices.Collateral, size), duration),
+	}
+}
+
+// checkContractCost warns if the host cannot form the requested contract.
+func checkContractCost(settings proto4.HostSettings, cost ContractCost, res *RHP4Result) {
+	if settings.MaxContractDuration < cost.Duration {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("host's max contract duration of %d blocks is less than the requested %d blocks", settings.MaxContractDuration, cost.Duration))
+	}
+	// missing collateral is already reported by checkSettings
+	if !settings.MaxCollateral.IsZero() && cost.Collateral.Cmp(settings.MaxCollateral) > 0 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("host's max collateral of %s is less than the %s required to store %s for %d blocks: the requested contract cannot be formed", settings.MaxCollateral, cost.Collateral, formatSize(cost.DataSize), cost.Duration))
+	}
+}
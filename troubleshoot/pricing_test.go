@@ -0,0 +1,126 @@
+package troubleshoot
+
+import (
+	"testing"
+
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+)
+
+func TestConvertPrices(t *testing.T) {
+	const tbMonth = bytesPerTB * blocksPerMonth
+
+	prices := proto4.HostPrices{
+		StoragePrice: types.Siacoins(100).Div64(tbMonth),
+		Collateral:   types.Siacoins(200).Div64(tbMonth),
+		IngressPrice: types.Siacoins(10).Div64(bytesPerTB),
+		EgressPrice:  types.Siacoins(50).Div64(bytesPerTB),
+	}
+	pricing := convertPrices(prices)
+
+	tests := []struct {
+		name     string
+		got      types.Currency
+		expected types.Currency
+	}{
+		{"storage", pricing.StoragePrice, prices.StoragePrice.Mul64(tbMonth)},
+		{"collateral", pricing.Collateral, prices.Collateral.Mul64(tbMonth)},
+		{"ingress", pricing.IngressPrice, types.Siacoins(10)},
+		{"egress", pricing.EgressPrice, types.Siacoins(50)},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if !test.got.Equals(test.expected) {
+				t.Fatalf("expected %v, got %v", test.expected, test.got)
+			}
+		})
+	}
+
+	// prices that overflow should saturate rather than panic
+	pricing = convertPrices(proto4.HostPrices{StoragePrice: types.MaxCurrency})
+	if !pricing.StoragePrice.Equals(types.MaxCurrency) {
+		t.Fatalf("expected max currency, got %v", pricing.StoragePrice)
+	}
+}
+
+func TestCheckPriceLimits(t *testing.T) {
+	limits := PriceLimits{
+		StoragePrice: types.Siacoins(1000),
+		EgressPrice:  types.Siacoins(1000),
+	}
+
+	tests := []struct {
+		name     string
+		pricing  Pricing
+		warnings []string
+	}{
+		{"reasonable", Pricing{StoragePrice: types.Siacoins(100), EgressPrice: types.Siacoins(100)}, nil},
+		{"at limit", Pricing{StoragePrice: types.Siacoins(1000), EgressPrice: types.Siacoins(1000)}, nil},
+		{"storage", Pricing{StoragePrice: types.Siacoins(1001)}, []string{"storage price"}},
+		{"egress", Pricing{EgressPrice: types.MaxCurrency}, []string{"egress price"}},
+		{"no limit", Pricing{IngressPrice: types.MaxCurrency}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var res RHP4Result
+			checkPriceLimits(test.pricing, limits, &res)
+			if len(res.Warnings) != len(test.warnings) {
+				t.Fatalf("expected %d warnings, got %v", len(test.warnings), res.Warnings)
+			}
+			for _, w := range test.warnings {
+				if !hasIssue(res.Warnings, w) {
+					t.Fatalf("expected warning containing %q, got %v", w, res.Warnings)
+				}
+			}
+		})
+	}
+}
+
+func TestContractCost(t *testing.T) {
+	prices := proto4.HostPrices{
+		StoragePrice: types.NewCurrency64(10),
+		Collateral:   types.NewCurrency64(20),
+		IngressPrice: types.NewCurrency64(3),
+	}
+
+	tests := []struct {
+		duration   uint64
+		size       uint64
+		storage    types.Currency
+		upload     types.Currency
+		collateral types.Currency
+	}{
+		{blocksPerMonth, bytesPerTB, types.NewCurrency64(10 * bytesPerTB * blocksPerMonth), types.NewCurrency64(3 * bytesPerTB), types.NewCurrency64(20 * bytesPerTB * blocksPerMonth)},
+		{2 * blocksPerMonth, bytesPerTB, types.NewCurrency64(20 * bytesPerTB * blocksPerMonth), types.NewCurrency64(3 * bytesPerTB), types.NewCurrency64(40 * bytesPerTB * blocksPerMonth)},
+		{blocksPerMonth, bytesPerTB / 2, types.NewCurrency64(5 * bytesPerTB * blocksPerMonth), types.NewCurrency64(3 * bytesPerTB / 2), types.NewCurrency64(10 * bytesPerTB * blocksPerMonth)},
+	}
+	for _, test := range tests {
+		cost := contractCost(prices, test.duration, test.size)
+		if cost.Duration != test.duration || cost.DataSize != test.size {
+			t.Fatalf("expected %d blocks and %d bytes, got %d and %d", test.duration, test.size, cost.Duration, cost.DataSize)
+		} else if !cost.StorageCost.Equals(test.storage) {
+			t.Fatalf("expected storage cost %v, got %v", test.storage, cost.StorageCost)
+		} else if !cost.UploadCost.Equals(test.upload) {
+			t.Fatalf("expected upload cost %v, got %v", test.upload, cost.UploadCost)
+		} else if !cost.Collateral.Equals(test.collateral) {
+			t.Fatalf("expected collateral %v, got %v", test.collateral, cost.Collateral)
+		}
+	}
+
+	settings := proto4.HostSettings{
+		MaxContractDuration: blocksPerMonth,
+		MaxCollateral:       types.NewCurrency64(20 * bytesPerTB * blocksPerMonth),
+		Prices:              prices,
+	}
+	var res RHP4Result
+	checkContractCost(settings, *contractCost(prices, blocksPerMonth, bytesPerTB), &res)
+	if len(res.Warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", res.Warnings)
+	}
+	checkContractCost(settings, *contractCost(prices, 2*blocksPerMonth, bytesPerTB), &res)
+	if !hasIssue(res.Warnings, "max contract duration of 4320 blocks is less than the requested 8640 blocks") {
+		t.Fatalf("expected duration warning, got %v", res.Warnings)
+	} else if !hasIssue(res.Warnings, "required to store 1 TB for 8640 blocks") {
+		t.Fatalf("expected collateral warning, got %v", res.Warnings)
+	}
+}
@@ -0,0 +1,160 @@
+package troubleshoot
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	colorReset  = "\x1b[0m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+)
+
+// reportWriter writes a human-readable report, optionally highlighting
+// statuses and issues with ANSI colors.
+type reportWriter struct {
+	w      *bufio.Writer
+	colors bool
+}
+
+func (rw *reportWriter) printf(format string, args ...any) {
+	fmt.Fprintf(rw.w, format, args...)
+}
+
+func (rw *reportWriter) colorize(color, s string) string {
+	if !rw.colors {
+		return s
+	}
+	return color + s + colorReset
+}
+
+func (rw *reportWriter) issues(indent string, errors, warnings []string) {
+	for _, e := range errors {
+		rw.printf("%s%s %s\n", indent, rw.colorize(colorRed, "error:"), e)
+	}
+	for _, w := range warnings {
+		rw.printf("%s%s %s\n", indent, rw.colorize(colorYellow, "warning:"), w)
+	}
+}
+
+// status returns a short description of whether the address passed.
+func (r RHP4Result) status() (string, string) {
+	switch {
+	case r.Skipped:
+		return "SKIPPED", colorYellow
+	case r.NetAddress.Address == "":
+		return "INVALID", colorRed
+	case !r.reached() || len(r.Errors) > 0:
+		return "FAIL", colorRed
+	case len(r.Warnings) > 0:
+		return "WARN", colorYellow
+	default:
+		return "PASS", colorGreen
+	}
+}
+
+// formatDuration rounds d for display.
+func formatDuration(d time.Duration) string {
+	if d >= time.Second {
+		return d.Round(10 * time.Millisecond).String()
+	}
+	return d.Round(time.Millisecond).String()
+}
+
+// WriteReport writes a human-readable report of the result to w. If colors
+// is true, statuses and issues are highlighted using ANSI escape codes.
+func (r Result) WriteReport(w io.Writer, colors bool) error {
+	rw := &reportWriter{w: bufio.NewWriter(w), colors: colors}
+
+	rw.printf("Host:    %s\n", r.PublicKey)
+	version := r.Version
+	if version == "" {
+		version = "unknown"
+	}
+	rw.printf("Version: %s\n", version)
+	if !r.ScannedAt.IsZero() {
+		rw.printf("Tested:  %s in %s\n", r.ScannedAt.UTC().Format(time.RFC3339), formatDuration(r.Elapsed))
+	}
+	if r.OverrideAddress != "" {
+		rw.printf("Override address: %s\n", r.OverrideAddress)
+	}
+	if r.AddressFamily != AddressFamilyAny {
+		rw.printf("Address family: %s only\n", r.AddressFamily)
+	}
+	if len(r.Transports) > 0 {
+		offered := make([]string, len(r.Transports))
+		for i, proto := range r.Transports {
+			offered[i] = string(proto)
+		}
+		if len(r.MissingTransports) > 0 {
+			rw.printf("Transports: %s only, consider also announcing a %s address\n", strings.Join(offered, ", "), r.MissingTransports[0])
+		} else {
+			rw.printf("Transports: %s\n", strings.Join(offered, ", "))
+		}
+	}
+	if r.Diagnosis != "" {
+		rw.printf("\n%s\n", r.Diagnosis)
+	}
+
+	for _, res := range r.RHP4 {
+		status, color := res.status()
+		rw.printf("\n%s %s: %s\n", res.NetAddress.Protocol, res.NetAddress.Address, rw.colorize(color, status))
+		if res.Skipped {
+			for _, note := range res.Notes {
+				rw.printf("  note: %s\n", note)
+			}
+			continue
+		}
+		if len(res.ResolvedAddresses) > 0 {
+			rw.printf("  Resolved: %s\n", strings.Join(res.ResolvedAddresses, ", "))
+		}
+		for _, ip := range res.IPResults {
+			if ip.Connected && ip.Handshake {
+				rw.printf("    %s: %s\n", ip.Address, rw.colorize(colorGreen, "reachable"))
+			} else {
+				rw.printf("    %s: %s\n", ip.Address, rw.colorize(colorRed, "unreachable"))
+			}
+		}
+
+		var timings []string
+		if res.ResolveTime > 0 {
+			timings = append(timings, "resolve "+formatDuration(res.ResolveTime))
+		}
+		if res.Connected && res.DialTime > 0 {
+			timings = append(timings, "dial "+formatDuration(res.DialTime))
+		}
+		if res.Handshake {
+			timings = append(timings, "handshake "+formatDuration(res.HandshakeTime))
+		}
+		if res.Scanned {
+			timings = append(timings, "scan "+formatDuration(res.ScanTime))
+		}
+		if len(timings) > 0 {
+			rw.printf("  Timings:  %s\n", strings.Join(timings, ", "))
+		}
+		rw.issues("  ", res.Errors, res.Warnings)
+	}
+
+	// issues found on individual addresses are already listed above
+	var general []string
+	for _, w := range r.Warnings {
+		if w.Protocol == "" {
+			general = append(general, w.Message)
+		}
+	}
+	if len(general) > 0 {
+		rw.printf("\n")
+		rw.issues("", nil, general)
+	}
+
+	rw.printf("\nErrors: %d, Warnings: %d\n", len(r.Errors), len(r.Warnings))
+	if r.Grade != "" {
+		rw.printf("Grade: %s (%d/100)\n", r.Grade, r.Score)
+	}
+	return rw.w.Flush()
+}
@@ -0,0 +1,117 @@
+package troubleshoot
+
+import (
+	"bytes"
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+)
+
+var updateGolden = flag.Bool("update", false, "update golden files")
+
+func TestWriteReport(t *testing.T) {
+	var hostKey types.PublicKey
+	hostKey[0] = 1
+	scannedAt := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	healthy := Result{
+		PublicKey: hostKey,
+		Version:   "hostd v2.5.0",
+		ScannedAt: scannedAt,
+		Elapsed:   1234 * time.Millisecond,
+		RHP4: []RHP4Result{
+			{
+				NetAddress:        chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"},
+				ResolvedAddresses: []string{"203.0.113.10"},
+				PortOpen:          true,
+				Connected:         true,
+				DialTime:          31 * time.Millisecond,
+				Handshake:         true,
+				HandshakeTime:     12 * time.Millisecond,
+				Scanned:           true,
+				ScanTime:          45 * time.Millisecond,
+			},
+			{
+				NetAddress:        chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech:9984"},
+				ResolvedAddresses: []string{"203.0.113.10"},
+				PortOpen:          true,
+				Connected:         true,
+				Handshake:         true,
+				HandshakeTime:     52 * time.Millisecond,
+				Scanned:           true,
+				ScanTime:          20 * time.Millisecond,
+			},
+		},
+		Transports: []chain.Protocol{siamux.Protocol, quic.Protocol},
+	}
+
+	siamuxOnly := healthy
+	siamuxOnly.RHP4 = healthy.RHP4[:1]
+	siamuxOnly.Transports, siamuxOnly.MissingTransports = offeredTransports([]chain.NetAddress{siamuxOnly.RHP4[0].NetAddress})
+
+	failing := Result{
+		PublicKey: hostKey,
+		Version:   "hostd v2.4.0",
+		ScannedAt: scannedAt,
+		Elapsed:   15 * time.Second,
+		RHP4: []RHP4Result{
+			{
+				NetAddress:        chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"},
+				ResolvedAddresses: []string{"203.0.113.10"},
+				PortOpen:          true,
+				Connected:         true,
+				DialTime:          31 * time.Millisecond,
+				Handshake:         true,
+				HandshakeTime:     12 * time.Millisecond,
+				Scanned:           true,
+				ScanTime:          45 * time.Millisecond,
+				Warnings:          []string{`host is running an outdated version "2.4.0", latest is "2.5.0"`},
+			},
+			{
+				NetAddress:        chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech:9984"},
+				ResolvedAddresses: []string{"203.0.113.10"},
+				Errors:            []string{`failed to connect to quic: check port forwarding and firewall settings for UDP port "9984"`},
+			},
+		},
+	}
+	summarize(&failing, checkTransports(failing.RHP4))
+
+	tests := []struct {
+		name   string
+		result Result
+		colors bool
+	}{
+		{"healthy", healthy, false},
+		{"siamux_only", siamuxOnly, false},
+		{"failing", failing, false},
+		{"failing_colors", failing, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := test.result.WriteReport(&buf, test.colors); err != nil {
+				t.Fatal(err)
+			}
+
+			path := filepath.Join("testdata", test.name+".golden")
+			if *updateGolden {
+				if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			} else if !bytes.Equal(buf.Bytes(), expected) {
+				t.Fatalf("report does not match %s:\n%s", path, buf.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package troubleshoot
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"time"
+
+	"go.sia.tech/troubleshootd/internal/dns"
+)
+
+// A retryPolicy controls how transient connection failures are retried.
+type retryPolicy struct {
+	// Attempts is the maximum number of attempts, including the first.
+	Attempts int
+	// Backoff is the delay before the first retry. It doubles after each
+	// subsequent attempt.
+	Backoff time.Duration
+}
+
+// isRetryable returns true if the error is likely to be transient.
+// Timeouts and temporary DNS failures are retryable, refused connections
+// and missing DNS records are not. Dial timeouts match
+// context.DeadlineExceeded, so whether the request itself is done must be
+// checked with the caller's context instead.
+func isRetryable(err error) bool {
+	switch {
+	case errors.Is(err, context.Canceled):
+		return false
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, dns.ErrNotFound):
+		return false
+	}
+
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return !dnsErr.IsNotFound && (dnsErr.IsTemporary || dnsErr.IsTimeout)
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// do calls fn until it succeeds, returns a permanent error, or the maximum
+// number of attempts is reached. It returns the number of attempts made and
+// the last error.
+func (rp retryPolicy) do(ctx context.Context, fn func() error) (attempts int, err error) {
+	backoff := rp.Backoff
+	for attempts = 1; ; attempts++ {
+		err = fn()
+		if err == nil || attempts >= rp.Attempts || ctx.Err() != nil || !isRetryable(err) {
+			return attempts, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempts, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
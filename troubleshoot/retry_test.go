@@ -0,0 +1,92 @@
+package troubleshoot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// dialTimeout returns the error of a dial that timed out before the
+// connection was established.
+func dialTimeout(t *testing.T) error {
+	t.Helper()
+
+	// a routable address that is not expected to answer within 1ns
+	d := net.Dialer{Timeout: 1}
+	conn, err := d.Dial("tcp", "192.0.2.1:9984")
+	if err == nil {
+		conn.Close()
+		t.Fatal("expected the dial to time out")
+	}
+	var netErr net.Error
+	if !errors.As(err, &netErr) || !netErr.Timeout() {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	return err
+}
+
+func TestRetryPolicy(t *testing.T) {
+	rp := retryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	timeoutErr := dialTimeout(t)
+	if !errors.Is(timeoutErr, context.DeadlineExceeded) {
+		t.Fatalf("expected the dial timeout to match %v", context.DeadlineExceeded)
+	}
+
+	tests := []struct {
+		name     string
+		errs     []error
+		attempts int
+		err      bool
+	}{
+		{"success", []error{nil}, 1, false},
+		{"timeout then success", []error{timeoutErr, nil}, 2, false},
+		{"timeout exhausted", []error{timeoutErr, timeoutErr, timeoutErr}, 3, true},
+		{"refused", []error{&net.OpError{Op: "dial", Err: syscall.ECONNREFUSED}}, 1, true},
+		{"not found", []error{&net.DNSError{Err: "no such host", IsNotFound: true}}, 1, true},
+		{"temporary dns", []error{&net.DNSError{Err: "server misbehaving", IsTemporary: true}, nil}, 2, false},
+		{"unknown", []error{errors.New("unknown")}, 1, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls int
+			attempts, err := rp.do(context.Background(), func() error {
+				err := test.errs[calls]
+				calls++
+				return err
+			})
+			if attempts != test.attempts {
+				t.Fatalf("expected %d attempts, got %d", test.attempts, attempts)
+			} else if attempts != calls {
+				t.Fatalf("expected %d calls, got %d", attempts, calls)
+			} else if test.err != (err != nil) {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		})
+	}
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		var calls int
+		attempts, err := rp.do(ctx, func() error {
+			calls++
+			cancel()
+			return timeoutErr
+		})
+		if attempts != 1 || calls != 1 {
+			t.Fatalf("expected 1 attempt after the context was canceled, got %d", attempts)
+		} else if err == nil {
+			t.Fatal("expected an error")
+		}
+	})
+
+	t.Run("wrapped", func(t *testing.T) {
+		if !isRetryable(fmt.Errorf("failed to resolve: %w", timeoutErr)) {
+			t.Fatal("expected wrapped timeout to be retryable")
+		}
+	})
+}
@@ -2,14 +2,21 @@ package troubleshoot
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"net"
-	"os"
+	"slices"
+	"strconv"
 	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	quicgo "github.com/quic-go/quic-go"
+	"go.sia.tech/core/consensus"
+	proto4 "go.sia.tech/core/rhp/v4"
 	"go.sia.tech/core/types"
 	"go.sia.tech/coreutils/chain"
 	rhp4 "go.sia.tech/coreutils/rhp/v4"
@@ -19,7 +26,34 @@ import (
 	"golang.org/x/exp/constraints"
 )
 
-const minContractDuration = 144 * 30 // 30 days
+// defaultThresholds are the default thresholds for settings warnings.
+var defaultThresholds = Thresholds{
+	MinCollateralRatio:  2,
+	MinContractDuration: 144 * 30, // 30 days
+}
+
+const (
+	// defaultRHP4Port is the default port hostd listens on for both the
+	// siamux and QUIC transports.
+	defaultRHP4Port = "9984"
+
+	// certExpiryWarning is the remaining validity period at which a
+	// warning is emitted for a host's TLS certificate.
+	certExpiryWarning = 14 * 24 * time.Hour
+
+	// minPriceValidity is the minimum remaining validity of a host's prices
+	// before a warning is emitted. Renters need time to use the prices
+	// before they expire.
+	minPriceValidity = 5 * time.Minute
+)
+
+// legacyPorts are hostd's default ports for the older RHP2 and RHP3
+// protocols. RHP4 addresses using them are likely a typo or left over from
+// an old configuration.
+var legacyPorts = map[string]string{
+	"9982": "RHP2",
+	"9983": "RHP3",
+}
 
 // badPorts is the set of ports blocked by browsers for QUIC/WebTransport
 // connections. Hosts announcing on these ports will be unreachable from
@@ -62,48 +96,86 @@ func parseReleaseString(versionStr string) (SemVer, error) {
 	return version, nil
 }
 
-func dialContext(ctx context.Context, network, address string) (net.Conn, error) {
-	conn, err := (&net.Dialer{
-		Timeout: 2 * time.Minute,
-	}).DialContext(ctx, network, address)
-	if err != nil {
-		// return more user-friendly errors if possible
-		var dnsErr *net.DNSError
-		if errors.As(err, &dnsErr) {
-			return nil, fmt.Errorf("failed to resolve host %q: check DNS setup", address)
-		}
-
-		var opErr *net.OpError
-		if errors.As(err, &opErr) {
-			if syscallErr, ok := opErr.Err.(*os.SyscallError); ok {
-				if syscallErr.Err == syscall.ECONNREFUSED {
-					return nil, fmt.Errorf("connection refused at %q: check if the service is running and port is forwarded", address)
-				}
-			}
-		}
+// checkPrices validates the signature and expiration of the host's prices.
+func checkPrices(prices proto4.HostPrices, hostKey types.PublicKey, res *RHP4Result) {
+	if !hostKey.VerifyHash(prices.SigHash(), prices.Signature) {
+		res.Errors = append(res.Errors, "host's prices have an invalid signature")
+	}
 
-		var netErr net.Error
-		if errors.As(err, &netErr) && netErr.Timeout() {
-			return nil, fmt.Errorf("timeout connecting to %q: check port forwarding or firewall", address)
-		}
+	if remaining := time.Until(prices.ValidUntil); remaining <= 0 {
+		res.Errors = append(res.Errors, fmt.Sprintf("host's prices already expired at %s", prices.ValidUntil.Format(time.RFC3339)))
+	} else if remaining < minPriceValidity {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("host's prices expire in %s", remaining.Round(time.Second)))
+	}
+}
 
-		return nil, fmt.Errorf("failed to connect to host at %q: %w", address, err)
+// checkTipHeight compares the host's tip height against the server's. If the
+// server's own consensus state is stale, the comparison is skipped rather
+// than blaming the host.
+func checkTipHeight(hostHeight uint64, p scanParams, res *RHP4Result) {
+	if p.stateAge > maxStateAge {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("server's consensus state was last updated %s ago: the host's tip height was not checked", p.stateAge.Round(time.Second)))
+		return
+	}
+
+	if delta(hostHeight, p.tip.Height) >= 3 {
+		res.Errors = append(res.Errors, fmt.Sprintf("host's tip height %d is less than the current tip height %d", hostHeight, p.tip.Height))
 	}
-	return conn, nil
 }
 
-func testRHP4Transport(ctx context.Context, t rhp4.TransportClient, currentVersion SemVer, tip types.ChainIndex, res *RHP4Result) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
-	start := time.Now()
-	settings, err := rhp4.RPCSettings(ctx, t)
-	if err != nil {
-		res.Errors = append(res.Errors, fmt.Sprintf("failed to get settings: %s", err))
+// expired returns true if the context is done or its deadline has passed.
+// Connection deadlines are set from the context's deadline, so a read can
+// time out shortly before the context itself is done.
+func expired(ctx context.Context) bool {
+	if ctx.Err() != nil {
+		return true
 	}
-	res.ScanTime = time.Since(start)
-	res.Scanned = true
-	res.Settings = &settings
+	deadline, ok := ctx.Deadline()
+	return ok && !time.Now().Before(deadline)
+}
+
+// checkTimeout marks the result as timed out if the test's context has
+// expired. This distinguishes the server's time limit being reached from
+// failures caused by the host. It returns true if the test timed out.
+func checkTimeout(ctx context.Context, step string, res *RHP4Result) bool {
+	if !expired(ctx) {
+		return false
+	}
+	res.TimedOut = true
+	res.Errors = append(res.Errors, fmt.Sprintf("test timed out during %s: the server's time limit was reached before the host responded", step))
+	return true
+}
+
+// stepContext returns a context for a single step of an address test. It
+// expires after timeout or when the test's context does, whichever is
+// sooner. If timeout is zero, only the test's context bounds the step.
+func stepContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// checkStepTimeout is like checkTimeout, but also reports the step's own
+// deadline being reached. Unlike the server's time limit, a step timing out
+// means the host stopped responding partway through the test. It returns
+// true if either timed out.
+func checkStepTimeout(ctx, stepCtx context.Context, step string, timeout time.Duration, res *RHP4Result) bool {
+	if checkTimeout(ctx, step, res) {
+		return true
+	} else if !expired(stepCtx) {
+		return false
+	}
+	res.Errors = append(res.Errors, fmt.Sprintf("%s timed out after %s: the host stopped responding", step, timeout))
+	return true
+}
+
+// collateralRatioPrecision is the number of decimal places of the
+// collateral ratio that are compared.
+const collateralRatioPrecision = 100
 
+// checkSettings checks the host's settings against the thresholds.
+func checkSettings(settings proto4.HostSettings, thresholds Thresholds, res *RHP4Result) {
 	if !settings.AcceptingContracts {
 		res.Warnings = append(res.Warnings, "host is not accepting contracts")
 	}
@@ -112,67 +184,348 @@ func testRHP4Transport(ctx context.Context, t rhp4.TransportClient, currentVersi
 		res.Errors = append(res.Errors, "host has no max collateral")
 	}
 
-	if settings.MaxContractDuration < minContractDuration {
-		res.Warnings = append(res.Warnings, "host has a max contract duration less than 1 month")
+	if settings.MaxContractDuration < thresholds.MinContractDuration {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("host has a max contract duration of %d blocks, less than the recommended %d blocks", settings.MaxContractDuration, thresholds.MinContractDuration))
 	}
 
-	if settings.Prices.Collateral.IsZero() {
+	// the ratio is compared as a fixed-point value to avoid converting the
+	// prices to floats
+	ratio := uint64(thresholds.MinCollateralRatio * collateralRatioPrecision)
+	prices := settings.Prices
+	if prices.Collateral.IsZero() {
 		res.Errors = append(res.Errors, "host has no collateral price")
-	} else if settings.Prices.Collateral.Cmp(settings.Prices.StoragePrice) < 0 {
+	} else if prices.Collateral.Cmp(prices.StoragePrice) < 0 {
 		res.Errors = append(res.Errors, "host's collateral price is less than storage price")
-	} else if settings.Prices.StoragePrice.Mul64(2).Cmp(settings.Prices.Collateral) > 0 {
-		res.Warnings = append(res.Warnings, "host's collateral price is less than double the storage price")
+	} else if mulSaturating(prices.StoragePrice, ratio).Cmp(mulSaturating(prices.Collateral, collateralRatioPrecision)) > 0 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("host's collateral price is less than %g times the storage price", thresholds.MinCollateralRatio))
 	}
+}
+
+// representativeContractSize is the amount of data, in bytes, a
+// representative contract stores when checking that the host's max
+// collateral covers a contract at its prices.
+const representativeContractSize = bytesPerTB
+
+// checkContractCollateral warns if the host's max collateral is less than
+// the collateral required to store a representative amount of data for the
+// host's max contract duration. Renters cannot form such contracts, even
+// though each setting looks reasonable on its own.
+func checkContractCollateral(settings proto4.HostSettings, res *RHP4Result) {
+	// missing collateral is already reported by checkSettings
+	if settings.MaxCollateral.IsZero() || settings.Prices.Collateral.IsZero() || settings.MaxContractDuration == 0 {
+		return
+	}
+	required := mulSaturating(mulSaturating(settings.Prices.Collateral, representativeContractSize), settings.MaxContractDuration)
+	if required.Cmp(settings.MaxCollateral) > 0 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("host's max collateral of %s is less than the %s required to store 1 TB for its max contract duration of %d blocks: renters cannot form full-duration contracts, lower the collateral price or increase the max collateral", settings.MaxCollateral, required, settings.MaxContractDuration))
+	}
+}
+
+// scanSettings returns true if the host's settings should be scanned. Quick
+// tests and addresses whose resolved IPs are already being tested stop once
+// the host is reachable.
+func scanSettings(p scanParams, res *RHP4Result) bool {
+	if p.quick {
+		// quick tests only check that the host is reachable
+		return false
+	} else if p.claim != nil && !p.claim() {
+		// another resolved address is being tested
+		res.Quick = true
+		return false
+	}
+	return true
+}
 
-	if delta(settings.Prices.TipHeight, tip.Height) >= 3 {
-		res.Errors = append(res.Errors, fmt.Sprintf("host's tip height %d is less than the current tip height %d", settings.Prices.TipHeight, tip.Height))
+func testRHP4Transport(ctx context.Context, t rhp4.TransportClient, p scanParams, res *RHP4Result) {
+	if !scanSettings(p, res) {
+		return
 	}
 
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	start := time.Now()
+	stepCtx, stepCancel := stepContext(ctx, p.settingsTimeout)
+	defer stepCancel()
+	settings, err := rhp4.RPCSettings(stepCtx, t)
+	res.ScanTime = time.Since(start)
+	checkTransportSettings(ctx, stepCtx, p, settings, err, res)
+}
+
+// checkTransportSettings checks the response to the settings RPC, which was
+// sent with stepCtx.
+func checkTransportSettings(ctx, stepCtx context.Context, p scanParams, settings proto4.HostSettings, err error, res *RHP4Result) {
+	if err != nil {
+		if checkStepTimeout(ctx, stepCtx, "settings scan", p.settingsTimeout, res) {
+			return
+		}
+		res.Errors = append(res.Errors, fmt.Sprintf("failed to get settings: %s", err))
+		return
+	}
+	res.Scanned = true
+	res.Settings = &settings
+
+	checkSettings(settings, p.thresholds, res)
+	checkContractCollateral(settings, res)
+	checkPrices(settings.Prices, p.hostKey, res)
+
+	res.Pricing = convertPrices(settings.Prices)
+	checkPriceLimits(*res.Pricing, p.priceLimits, res)
+	if p.contractDuration > 0 && p.contractSize > 0 {
+		res.Contract = contractCost(settings.Prices, p.contractDuration, p.contractSize)
+		if p.customContract {
+			// the default contract is already covered by the settings
+			// and collateral checks
+			checkContractCost(settings, *res.Contract, res)
+		}
+	}
+
+	checkTipHeight(settings.Prices.TipHeight, p, res)
+
+	if p.skipVersionCheck {
+		return
+	}
 	release, err := parseReleaseString(settings.Release)
 	if err != nil {
 		res.Warnings = append(res.Warnings, fmt.Sprintf("host is running an unknown version %q, which may not be stable", settings.Release))
-	} else if release.Cmp(currentVersion) < 0 {
-		res.Warnings = append(res.Warnings, fmt.Sprintf("host is running an outdated version %q, latest is %q", release, currentVersion))
+	} else if drift := release.Drift(p.currentVersion); drift == VersionMajorBehind {
+		res.Errors = append(res.Errors, fmt.Sprintf("host is running %q, a major version behind the latest %q: update hostd as soon as possible", release, p.currentVersion))
+	} else if drift != VersionUpToDate {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("host is running an outdated version %q, latest is %q", release, p.currentVersion))
+	}
+}
+
+// siamuxVersion is the siamux protocol version sent during the handshake.
+const siamuxVersion = 3
+
+// A versionConn records the peer's siamux version, which is the first byte
+// it sends during the handshake.
+type versionConn struct {
+	net.Conn
+	read    bool
+	version byte
+}
+
+// Read implements net.Conn.
+func (c *versionConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if !c.read && n > 0 {
+		c.read = true
+		c.version = b[0]
 	}
+	return n, err
 }
 
-func testRHP4SiaMux(ctx context.Context, currentVersion SemVer, tip types.ChainIndex, hostKey types.PublicKey, addr chain.NetAddress, res *RHP4Result) {
+// quicTransportVersion describes the application protocol and TLS version
+// negotiated during a QUIC handshake.
+func quicTransportVersion(cs tls.ConnectionState) string {
+	proto := cs.NegotiatedProtocol
+	if proto == "" {
+		proto = "unknown protocol"
+	}
+	return fmt.Sprintf("%s (%s)", proto, tls.VersionName(cs.Version))
+}
+
+func testRHP4SiaMux(ctx context.Context, p scanParams, dialAddr string, res *RHP4Result) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
 	start := time.Now()
-	conn, err := dialContext(ctx, "tcp", addr.Address)
+	conn, attempts, err := dialContext(ctx, p.scanConfig, "tcp", dialAddr)
+	res.DialAttempts = attempts
 	if err != nil {
-		res.Errors = append(res.Errors, err.Error())
+		if !checkTimeout(ctx, "dial", res) {
+			res.Errors = append(res.Errors, err.Error())
+			// the dialer tries every resolved address before failing
+			switch {
+			case errors.Is(err, errConnectionRefused):
+				res.dialFailure = dialRefused
+			case errors.Is(err, errDialTimeout):
+				res.dialFailure = dialTimedOut
+			}
+		}
 		return
 	}
 	defer conn.Close()
 	res.DialTime = time.Since(start)
+	res.PortOpen = true
 	res.Connected = true
 
+	var bc byteCounter
+	defer bc.record(res)
+
 	start = time.Now()
-	t, err := siamux.Upgrade(ctx, conn, hostKey)
+	vc := &versionConn{Conn: bc.wrap(conn)}
+	stepCtx, stepCancel := stepContext(ctx, p.handshakeTimeout)
+	defer stepCancel()
+	t, err := siamux.Upgrade(stepCtx, vc, p.hostKey)
 	if err != nil {
-		res.Errors = append(res.Errors, fmt.Sprintf("failed to connect to siamux: %s", err))
+		if checkStepTimeout(ctx, stepCtx, "siamux handshake", p.handshakeTimeout, res) {
+			return
+		} else if vc.read && vc.version < siamuxVersion {
+			res.Errors = append(res.Errors, fmt.Sprintf("failed to connect to siamux: host only supports siamux version %d, version %d is required: update the host", vc.version, siamuxVersion))
+		} else {
+			res.Errors = append(res.Errors, fmt.Sprintf("failed to connect to siamux: %s", err))
+		}
 		return
 	}
 	defer t.Close()
 	res.HandshakeTime = time.Since(start)
 	res.Handshake = true
+	// the lower of the two versions is used
+	res.TransportVersion = fmt.Sprintf("siamux/%d", min(vc.version, siamuxVersion))
+
+	testRHP4Transport(ctx, t, p, res)
+}
+
+func certificateDetails(leaf *x509.Certificate, hostname string) *Certificate {
+	return &Certificate{
+		Subject:       leaf.Subject.String(),
+		Issuer:        leaf.Issuer.String(),
+		NotBefore:     leaf.NotBefore,
+		NotAfter:      leaf.NotAfter,
+		Names:         certificateNames(leaf),
+		ServerName:    hostname,
+		HostnameMatch: leaf.VerifyHostname(hostname) == nil,
+	}
+}
+
+// certificateNames returns the DNS names and IP addresses in the
+// certificate's subject alternative names.
+func certificateNames(leaf *x509.Certificate) []string {
+	names := slices.Clone(leaf.DNSNames)
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+// hostnameMismatch describes a certificate whose subject alternative names
+// do not cover the hostname. Clients verify the certificate against the
+// server name (SNI) they send, which is the announced hostname.
+func hostnameMismatch(leaf *x509.Certificate, hostname string) string {
+	names := certificateNames(leaf)
+	if len(names) == 0 {
+		return fmt.Sprintf("certificate is not valid for %q: it has no subject alternative names and clients ignore its common name %q, reissue it with %q as a DNS name", hostname, leaf.Subject.CommonName, hostname)
+	}
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = strconv.Quote(name)
+	}
+	return fmt.Sprintf("certificate is not valid for %q, it only covers %s: renters that verify the server name will reject it, reissue it with %q as a DNS name", hostname, strings.Join(quoted, ", "), hostname)
+}
+
+// checkCertificate records the details of the host's leaf certificate and
+// warns if it does not cover the hostname or is close to expiring.
+func checkCertificate(leaf *x509.Certificate, hostname string, res *RHP4Result) {
+	res.Certificate = certificateDetails(leaf, hostname)
+
+	if !res.Certificate.HostnameMatch {
+		res.Warnings = append(res.Warnings, hostnameMismatch(leaf, hostname))
+	}
+
+	if remaining := time.Until(leaf.NotAfter); remaining <= 0 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("certificate expired at %s", leaf.NotAfter.Format(time.RFC3339)))
+	} else if remaining < certExpiryWarning {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("certificate expires in %s: check that it is being renewed", remaining.Round(time.Hour)))
+	}
+}
 
-	testRHP4Transport(ctx, t, currentVersion, tip, res)
+// certificateError returns a user-friendly description of a certificate
+// verification error.
+func certificateError(err error) string {
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &hostnameErr) && hostnameErr.Certificate != nil:
+		return hostnameMismatch(hostnameErr.Certificate, hostnameErr.Host)
+	case errors.As(err, &hostnameErr):
+		return fmt.Sprintf("certificate is not valid for %q", hostnameErr.Host)
+	case errors.As(err, &authorityErr):
+		return "certificate is not signed by a trusted authority: check that a publicly trusted certificate is configured"
+	case errors.As(err, &invalidErr) && invalidErr.Reason == x509.Expired:
+		return "certificate has expired: check that it is being renewed"
+	default:
+		return fmt.Sprintf("certificate verification failed: %s", err)
+	}
 }
 
-func testRHP4Quic(ctx context.Context, currentVersion SemVer, tip types.ChainIndex, hostKey types.PublicKey, addr chain.NetAddress, res *RHP4Result) {
+func testRHP4Quic(ctx context.Context, p scanParams, addr chain.NetAddress, dialAddr string, res *RHP4Result) {
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
+	hostname, _, _ := net.SplitHostPort(addr.Address)
+
+	var bc byteCounter
+	defer bc.record(res)
+
 	start := time.Now()
-	t, err := quic.Dial(ctx, addr.Address, hostKey)
+	var t rhp4.TransportClient
+	// peer is the connection state of the last attempt that received the
+	// host's certificate
+	var peer *tls.ConnectionState
+	// stepTimedOut is set if the last attempt reached the handshake timeout
+	var stepTimedOut bool
+	attempts, err := p.retry.do(ctx, func() (err error) {
+		stepCtx, cancel := stepContext(ctx, p.handshakeTimeout)
+		defer cancel()
+		defer func() { stepTimedOut = err != nil && stepCtx.Err() != nil }()
+		// the callback runs during the handshake, so the state is only
+		// recorded once the dial returns
+		var state atomic.Pointer[tls.ConnectionState]
+		t, err = quic.Dial(stepCtx, dialAddr, p.hostKey, quic.WithStreamMiddleware(bc.wrap), quic.WithTLSConfig(func(tc *tls.Config) {
+			// the dialed address may be an override IP, always
+			// verify the certificate against the announced hostname
+			tc.ServerName = hostname
+			tc.VerifyConnection = func(cs tls.ConnectionState) error {
+				state.Store(&cs)
+				return nil
+			}
+		}))
+		if cs := state.Load(); cs != nil {
+			peer = cs
+		}
+		return err
+	})
+	res.DialAttempts = attempts
+	var version string
+	if peer != nil {
+		// the host has responded with its certificate
+		res.PortOpen = true
+		version = quicTransportVersion(*peer)
+		if len(peer.PeerCertificates) > 0 {
+			checkCertificate(peer.PeerCertificates[0], hostname, res)
+		}
+	}
 	if err != nil {
-		if strings.Contains(err.Error(), "no recent network activity") {
-			_, port, _ := net.SplitHostPort(addr.Address)
+		if quicPeerResponded(err) {
+			res.PortOpen = true
+		}
+		if checkTimeout(ctx, "QUIC handshake", res) {
+			return
+		} else if !res.PortOpen && (stepTimedOut || quicIdleTimeout(err)) {
+			// UDP ports cannot refuse connections, an unreachable host
+			// never responds
+			res.dialFailure = dialTimedOut
+		}
+		if stepTimedOut && res.PortOpen {
+			res.Errors = append(res.Errors, fmt.Sprintf("QUIC handshake timed out after %s: the host stopped responding", p.handshakeTimeout))
+			return
+		}
+
+		var certErr *tls.CertificateVerificationError
+		if errors.As(err, &certErr) {
+			if len(certErr.UnverifiedCertificates) > 0 {
+				res.Certificate = certificateDetails(certErr.UnverifiedCertificates[0], hostname)
+			}
+			res.Errors = append(res.Errors, fmt.Sprintf("failed to connect to quic: %s", certificateError(certErr.Err)))
+		} else if quicALPNMismatch(err) {
+			_, port, _ := net.SplitHostPort(dialAddr)
+			res.Errors = append(res.Errors, fmt.Sprintf("failed to connect to quic: the host did not accept the %q application protocol (ALPN): check that any load balancer or proxy in front of UDP port %q forwards QUIC to hostd without terminating TLS", quic.TLSNextProtoRHP4, port))
+		} else if quicIdleTimeout(err) {
+			_, port, _ := net.SplitHostPort(dialAddr)
 			res.Errors = append(res.Errors, fmt.Sprintf("failed to connect to quic: check port forwarding and firewall settings for UDP port %q", port))
+			checkMTU(ctx, dialAddr, res)
 		} else {
 			res.Errors = append(res.Errors, fmt.Sprintf("failed to connect to quic: %s", err))
 		}
@@ -182,60 +535,677 @@ func testRHP4Quic(ctx context.Context, currentVersion SemVer, tip types.ChainInd
 	// dialing UDP is kind of annoying, so we don't have a singular dial time
 	// for QUIC. we just assume it's instant.
 	res.HandshakeTime = time.Since(start)
-	res.Connected = true
+	res.PortOpen = true
 	res.Handshake = true
+	res.TransportVersion = version
+
+	// the handshake can complete without hostd handling any streams, such
+	// as behind a proxy that terminates the connection. The host is only
+	// connected once it responds to the settings RPC, which is also used
+	// for the settings scan.
+	start = time.Now()
+	stepCtx, stepCancel := stepContext(ctx, p.settingsTimeout)
+	defer stepCancel()
+	settings, responded, err := rpcSettings(stepCtx, t)
+	if !responded {
+		if checkTimeout(ctx, "QUIC stream check", res) {
+			return
+		} else if stepCtx.Err() != nil {
+			err = errors.New("no response before the timeout")
+		}
+		_, port, _ := net.SplitHostPort(dialAddr)
+		res.Errors = append(res.Errors, fmt.Sprintf("QUIC handshake completed, but the host did not respond on a stream: UDP port %q is reachable but hostd is not handling RHP4 requests, check that hostd is running and any proxy forwards QUIC streams to it: %s", port, err))
+		return
+	}
+	res.Connected = true
+
+	if !scanSettings(p, res) {
+		return
+	}
+	res.ScanTime = time.Since(start)
+	checkTransportSettings(ctx, stepCtx, p, settings, err, res)
+}
 
-	testRHP4Transport(ctx, t, currentVersion, tip, res)
+// A responseConn records whether the host has sent any data on a stream.
+type responseConn struct {
+	net.Conn
+	responded *atomic.Bool
 }
 
-func lookupIPs(ctx context.Context, addr string) ([]net.IP, error) {
-	// try system resolver first
-	ips, err := net.LookupIP(addr)
-	if err == nil {
-		return ips, nil
+// Read implements net.Conn.
+func (c *responseConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		c.responded.Store(true)
 	}
+	return n, err
+}
+
+// A responseTransport wraps the streams of a transport in responseConns.
+type responseTransport struct {
+	rhp4.TransportClient
+	responded atomic.Bool
+}
 
-	// fallback to DNS resolver
-	ips, err = dns.LookupIP(ctx, "1.1.1.1:53", addr)
+// DialStream implements rhp4.TransportClient.
+func (t *responseTransport) DialStream(ctx context.Context) (net.Conn, error) {
+	s, err := t.TransportClient.DialStream(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("failed to resolve host %q: %w", addr, err)
+		return nil, err
 	}
-	return ips, nil
+	return &responseConn{Conn: s, responded: &t.responded}, nil
 }
 
-func testRHP4(ctx context.Context, currentVersion SemVer, tip types.ChainIndex, hostKey types.PublicKey, netAddr chain.NetAddress, res *RHP4Result) {
-	ctx, cancel := context.WithCancel(ctx)
-	defer cancel()
+// rpcSettings sends the settings RPC over the transport. responded is
+// true if the host sent any data in response, even if the RPC failed,
+// which confirms that requests reach the host's RHP4 server.
+func rpcSettings(ctx context.Context, t rhp4.TransportClient) (settings proto4.HostSettings, responded bool, err error) {
+	rt := &responseTransport{TransportClient: t}
+	settings, err = rhp4.RPCSettings(ctx, rt)
+	return settings, rt.responded.Load(), err
+}
+
+// quicIdleTimeout returns true if a QUIC dial failed because the host
+// never responded.
+func quicIdleTimeout(err error) bool {
+	return strings.Contains(err.Error(), "no recent network activity")
+}
+
+// quicPeerResponded returns true if a QUIC dial error was sent by the peer,
+// meaning the UDP port is reachable even though the connection failed.
+func quicPeerResponded(err error) bool {
+	var transportErr *quicgo.TransportError
+	var appErr *quicgo.ApplicationError
+	var versionErr *quicgo.VersionNegotiationError
+	switch {
+	case errors.As(err, &transportErr):
+		return transportErr.Remote
+	case errors.As(err, &appErr):
+		return appErr.Remote
+	case errors.As(err, &versionErr):
+		return true
+	}
+	return false
+}
+
+// alertNoApplicationProtocol is the QUIC transport error code for the TLS
+// no_application_protocol alert. It is sent by whichever side could not
+// agree on an application protocol.
+const alertNoApplicationProtocol = quicgo.TransportErrorCode(0x100 + 120)
+
+// quicALPNMismatch returns true if a QUIC dial error was caused by the
+// client and server failing to negotiate an application protocol.
+func quicALPNMismatch(err error) bool {
+	var transportErr *quicgo.TransportError
+	return errors.As(err, &transportErr) && transportErr.ErrorCode == alertNoApplicationProtocol
+}
+
+// checkAnnouncement warns if the tested address does not match any of the
+// addresses the host has announced on-chain for the same protocol.
+func checkAnnouncement(announced []chain.NetAddress, res *RHP4Result) {
+	var protoAddrs []string
+	for _, addr := range announced {
+		addr, _ = normalizeAddress(addr)
+		if addr.Protocol != res.NetAddress.Protocol {
+			continue
+		} else if strings.EqualFold(addr.Address, res.NetAddress.Address) {
+			return
+		}
+		protoAddrs = append(protoAddrs, addr.Address)
+	}
 
+	if len(protoAddrs) == 0 {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("host has not announced a %q address on-chain", res.NetAddress.Protocol))
+		return
+	}
+	res.Warnings = append(res.Warnings, fmt.Sprintf("address %q does not match the host's announced %q addresses %q: check if the host needs to re-announce", res.NetAddress.Address, res.NetAddress.Protocol, protoAddrs))
+}
+
+// canonicalProtocol returns the canonical name of the protocol and true if
+// it is a known spelling of siamux or quic. Some older announcements use a
+// different case or separate the words, such as "SiaMux" or "sia-mux".
+func canonicalProtocol(proto chain.Protocol) (chain.Protocol, bool) {
+	name := strings.ToLower(strings.TrimSpace(string(proto)))
+	name = strings.NewReplacer("-", "", "_", "", " ", "").Replace(name)
+	switch name {
+	case "siamux":
+		return siamux.Protocol, true
+	case "quic":
+		return quic.Protocol, true
+	}
+	return proto, false
+}
+
+// normalizeAddress converts a known non-canonical spelling of the address's
+// protocol to its canonical name and removes a "siamux://" or "quic://"
+// scheme from the address. It returns the normalized address and a warning
+// for each change so the host can fix its announcement. Renters may reject
+// non-canonical addresses even though they can be tested.
+func normalizeAddress(netAddr chain.NetAddress) (chain.NetAddress, []string) {
+	var warnings []string
+	if proto, ok := canonicalProtocol(netAddr.Protocol); ok && proto != netAddr.Protocol {
+		warnings = append(warnings, fmt.Sprintf("protocol %q is not canonical and was tested as %q: re-announce the address with the protocol %q", netAddr.Protocol, proto, proto))
+		netAddr.Protocol = proto
+	}
+	if netAddr.Protocol != siamux.Protocol && netAddr.Protocol != quic.Protocol {
+		return netAddr, warnings
+	}
+	scheme := string(netAddr.Protocol) + "://"
+	if len(netAddr.Address) > len(scheme) && strings.EqualFold(netAddr.Address[:len(scheme)], scheme) {
+		warnings = append(warnings, fmt.Sprintf("address %q should not include a scheme: re-announce it as %q", netAddr.Address, netAddr.Address[len(scheme):]))
+		netAddr.Address = netAddr.Address[len(scheme):]
+	}
+	return netAddr, warnings
+}
+
+// withDefaultPort adds the protocol's default port to the address if it
+// does not specify one. It returns false if the address was not changed.
+func withDefaultPort(netAddr chain.NetAddress) (chain.NetAddress, bool) {
+	if _, _, err := net.SplitHostPort(netAddr.Address); err == nil {
+		return netAddr, false
+	}
+
+	var port string
+	switch netAddr.Protocol {
+	case siamux.Protocol, quic.Protocol:
+		port = defaultRHP4Port
+	default:
+		return netAddr, false
+	}
+
+	host := strings.TrimSuffix(strings.TrimPrefix(netAddr.Address, "["), "]")
+	if host == "" || strings.Contains(host, "]") {
+		return netAddr, false
+	} else if strings.Contains(host, ":") && dns.ParseIP(host) == nil {
+		// not an IPv6 literal, the address is malformed
+		return netAddr, false
+	}
+	netAddr.Address = net.JoinHostPort(host, port)
+	return netAddr, true
+}
+
+// unbracketedIPv6 returns the IPv6 address and port of an address that is
+// missing the brackets around its IPv6 literal, such as
+// "2001:db8::1:9984". The last colon is assumed to separate the port.
+func unbracketedIPv6(addr string) (host, port string, ok bool) {
+	i := strings.LastIndexByte(addr, ':')
+	if i < 0 || strings.ContainsAny(addr, "[]") {
+		return "", "", false
+	}
+	host, port = addr[:i], addr[i+1:]
+	if ip := dns.ParseIP(host); ip == nil || ip.To4() != nil {
+		return "", "", false
+	} else if _, err := strconv.ParseUint(port, 10, 16); err != nil {
+		return "", "", false
+	}
+	return host, port, true
+}
+
+// checkAddress checks the address before it is tested, adding the default
+// port if enabled. It returns the address to test, its hostname and port,
+// and false if the address cannot be tested.
+func checkAddress(cfg scanConfig, netAddr chain.NetAddress, res *RHP4Result) (_ chain.NetAddress, hostname, port string, ok bool) {
 	res.NetAddress = netAddr
-	addr, port, err := net.SplitHostPort(netAddr.Address)
+	if netAddr.Protocol != siamux.Protocol && netAddr.Protocol != quic.Protocol {
+		res.Errors = append(res.Errors, fmt.Sprintf("unknown protocol %q", netAddr.Protocol))
+		return netAddr, "", "", false
+	}
+	if cfg.defaultPorts {
+		var added bool
+		if netAddr, added = withDefaultPort(netAddr); added {
+			res.Notes = append(res.Notes, fmt.Sprintf("no port specified, assuming the default %s port %s", netAddr.Protocol, defaultRHP4Port))
+		}
+	}
+	hostname, port, err := net.SplitHostPort(netAddr.Address)
 	if err != nil {
-		res.Errors = append(res.Errors, fmt.Sprintf("failed to parse net address %q: %v", netAddr.Address, err))
-		return
+		if host, port, ok := unbracketedIPv6(netAddr.Address); ok {
+			res.Errors = append(res.Errors, fmt.Sprintf("failed to parse net address %q: IPv6 addresses must be enclosed in brackets, such as %q", netAddr.Address, net.JoinHostPort(host, port)))
+		} else {
+			res.Errors = append(res.Errors, fmt.Sprintf("failed to parse net address %q: %v", netAddr.Address, err))
+		}
+		return netAddr, "", "", false
 	}
 
 	if netAddr.Protocol == quic.Protocol && badPorts[port] {
 		res.Errors = append(res.Errors, fmt.Sprintf("port %s is blocked by browsers for QUIC/WebTransport connections", port))
 	}
+	if proto, ok := legacyPorts[port]; ok {
+		res.Warnings = append(res.Warnings, fmt.Sprintf("port %s is the default %s port, RHP4 uses port %s by default: check that the announced port is correct", port, proto, defaultRHP4Port))
+	}
+	return netAddr, hostname, port, true
+}
 
-	ips, err := lookupIPs(ctx, addr)
-	if err != nil {
-		if errors.Is(err, dns.ErrNotFound) {
-			res.Errors = append(res.Errors, fmt.Sprintf("DNS lookup %q failed: check DNS records or wait for propagation", addr))
-		} else {
-			res.Errors = append(res.Errors, fmt.Sprintf("failed to resolve host %q: %s", addr, err))
+func testRHP4(ctx context.Context, p scanParams, netAddr chain.NetAddress, res *RHP4Result) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	res.Quick = p.quick
+	netAddr, addr, port, ok := checkAddress(p.scanConfig, netAddr, res)
+	if !ok {
+		return
+	}
+
+	dialAddr := netAddr.Address
+	// dialIPs are the resolved addresses to dial individually
+	var ips, dialIPs []net.IP
+	if p.overrideIP != nil {
+		// dial the override IP directly, skipping DNS
+		dialAddr = net.JoinHostPort(p.overrideIP.String(), port)
+		ips = []net.IP{p.overrideIP}
+		res.ResolvedAddresses = []string{p.overrideIP.String()}
+		res.Notes = append(res.Notes, fmt.Sprintf("override address %s was tested instead of resolving %q", p.overrideIP, addr))
+		if err := checkNetworks(p.scanConfig, ips); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("refusing to test override address: %s", err))
+			return
+		}
+	} else {
+		lookup, attempts, err := lookupIPs(ctx, p.scanConfig, p.family, addr)
+		res.ResolveAttempts, res.ResolveTime = attempts, lookup.elapsed
+		if lookup.answers != nil {
+			defer func() {
+				res.ResolverAnswers = <-lookup.answers
+				checkResolverAnswers(addr, res.ResolverAnswers, res)
+			}()
+		}
+		if err != nil {
+			if checkTimeout(ctx, "DNS lookup", res) {
+				return
+			}
+
+			if errors.Is(err, dns.ErrNotFound) && p.family != AddressFamilyAny {
+				res.Errors = append(res.Errors, fmt.Sprintf("DNS lookup %q found no %s addresses: check DNS records or wait for propagation", addr, p.family))
+			} else if errors.Is(err, dns.ErrNotFound) {
+				res.Errors = append(res.Errors, fmt.Sprintf("DNS lookup %q failed: check DNS records or wait for propagation", addr))
+			} else {
+				res.Errors = append(res.Errors, fmt.Sprintf("failed to resolve host %q: %s", addr, err))
+			}
+			return
+		}
+		ips, res.Resolver = lookup.ips, lookup.resolvedBy
+		for _, ip := range ips {
+			res.ResolvedAddresses = append(res.ResolvedAddresses, ip.String())
 		}
+		if err := checkNetworks(p.scanConfig, ips); err != nil {
+			res.Errors = append(res.Errors, fmt.Sprintf("refusing to test %q: %s", addr, err))
+			return
+		}
+		checkRoutable(addr, ips, res)
+		checkExpectedIPs(addr, ips, p.expectedIPs, p.family, res)
+		literal := dns.ParseIP(addr) != nil
+		switch {
+		case literal:
+			// IP literals are dialed as given, keeping any IPv6 zone
+		case len(ips) > 1:
+			// the dialer would pick one of the addresses, so a host with
+			// a broken address would pass or fail depending on which.
+			// Dial each of them instead.
+			dialIPs = ips
+			if p.maxIPs > 0 && len(dialIPs) > p.maxIPs {
+				dialIPs = dialIPs[:p.maxIPs]
+				res.Warnings = append(res.Warnings, fmt.Sprintf("only the first %d of the %d addresses %q resolved to were dialed: this server tests at most %d addresses per host", p.maxIPs, len(ips), addr, p.maxIPs))
+			}
+		case p.family != AddressFamilyAny, p.restrictsNetworks() && (netAddr.Protocol == quic.Protocol || p.proxy != nil):
+			// dial the resolved address directly so that the other family
+			// is never used. QUIC and proxies resolve the hostname again
+			// when dialing, so a second lookup could also return an
+			// address that is not allowed.
+			dialAddr = net.JoinHostPort(ips[0].String(), port)
+		}
+		if !literal && p.family != AddressFamilyAny {
+			res.Notes = append(res.Notes, fmt.Sprintf("only %s addresses were resolved and dialed", p.family))
+		}
+
+		// run the supplementary DNS checks while the transport is tested
+		dnsDone := make(chan dnsReport, 1)
+		server := p.dnsServer()
+		go func() { dnsDone <- checkDNS(p.dnsContext(ctx, server), server, addr, ips, p.maxCNAMEDepth) }()
+		defer func() {
+			report := <-dnsDone
+			if len(report.reverse) > 0 {
+				res.ReverseDNS = report.reverse
+			}
+			res.CNAMEChain = report.cnameChain
+			res.Warnings = append(res.Warnings, report.warnings...)
+		}()
+	}
+
+	if p.rdap != nil {
+		ownersDone := make(chan map[string]NetworkOwner, 1)
+		go func() { ownersDone <- lookupNetworkOwners(ctx, p.rdap, ips) }()
+		defer func() {
+			if owners := <-ownersDone; len(owners) > 0 {
+				res.NetworkOwners = owners
+			}
+		}()
+	}
+
+	if len(dialIPs) > 0 {
+		testResolvedIPs(ctx, p, netAddr, dialIPs, port, res)
 		return
 	}
-	for _, ip := range ips {
-		res.ResolvedAddresses = append(res.ResolvedAddresses, ip.String())
+	testRHP4Transports(ctx, p, netAddr, dialAddr, res)
+}
+
+// testResolvedIPs tests the handshake with each of the addresses the
+// hostname resolved to, so that a host with one broken address is
+// diagnosed regardless of which address a renter's dialer picks. The first
+// address to complete the handshake continues with the rest of the test,
+// and its result becomes res. The others are warned about. If no address
+// completed the handshake, their failures are recorded in res and it
+// returns false.
+//
+// The addresses are tested on the address's scan slot, and concurrently
+// on any additional slots that are free, so that the resolved addresses
+// never exceed the limit on concurrent scans.
+func testResolvedIPs(ctx context.Context, p scanParams, netAddr chain.NetAddress, ips []net.IP, port string, res *RHP4Result) bool {
+	var claimed atomic.Bool
+	probe := p
+	probe.claim = func() bool { return claimed.CompareAndSwap(false, true) }
+
+	next := make(chan int, len(ips))
+	for i := range ips {
+		next <- i
+	}
+	close(next)
+	results := make([]RHP4Result, len(ips))
+	test := func() {
+		for i := range next {
+			testRHP4Transports(ctx, probe, netAddr, net.JoinHostPort(ips[i].String(), port), &results[i])
+		}
+	}
+	var wg sync.WaitGroup
+	for range len(ips) - 1 {
+		if p.tryAcquireScan == nil {
+			break
+		}
+		release, ok := p.tryAcquireScan()
+		if !ok {
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer release()
+			test()
+		}()
+	}
+	test()
+	wg.Wait()
+
+	tested := -1
+	var unreachable []string
+	for i, r := range results {
+		res.IPResults = append(res.IPResults, IPResult{
+			Address:       ips[i].String(),
+			PortOpen:      r.PortOpen,
+			Connected:     r.Connected,
+			Handshake:     r.Handshake,
+			HandshakeTime: r.HandshakeTime,
+			Errors:        r.Errors,
+		})
+		if !r.Connected || !r.Handshake {
+			unreachable = append(unreachable, ips[i].String())
+		} else if tested == -1 && (p.quick || !r.Quick) {
+			// only the address that claimed the test continued past the
+			// handshake
+			tested = i
+		}
+	}
+
+	hostname, _, _ := net.SplitHostPort(netAddr.Address)
+	resolved := len(res.ResolvedAddresses)
+	if tested != -1 {
+		r := results[tested]
+		if len(ips) == resolved {
+			r.Notes = append(r.Notes, fmt.Sprintf("%q resolved to %d addresses: each was dialed and %s was tested", hostname, resolved, net.JoinHostPort(ips[tested].String(), port)))
+		} else {
+			r.Notes = append(r.Notes, fmt.Sprintf("%q resolved to %d addresses: the first %d were dialed and %s was tested", hostname, resolved, len(ips), net.JoinHostPort(ips[tested].String(), port)))
+		}
+		if len(unreachable) > 0 {
+			r.Warnings = append(r.Warnings, fmt.Sprintf("%q resolved to %d addresses, but %s could not be reached: renters that connect to them will fail, fix or remove their DNS records", hostname, resolved, strings.Join(unreachable, ", ")))
+		}
+		mergeResolvedResult(res, r)
+		return true
+	}
+
+	// the address failed on every resolved address
+	for i, r := range results {
+		res.PortOpen = res.PortOpen || r.PortOpen
+		res.Connected = res.Connected || r.Connected
+		res.TimedOut = res.TimedOut || r.TimedOut
+		res.DialAttempts += r.DialAttempts
+		res.BytesSent += r.BytesSent
+		res.BytesReceived += r.BytesReceived
+		if res.Certificate == nil {
+			res.Certificate = r.Certificate
+		}
+		for _, err := range r.Errors {
+			if !slices.Contains(res.Errors, err) {
+				res.Errors = append(res.Errors, err)
+			}
+		}
+		for _, w := range r.Warnings {
+			if !slices.Contains(res.Warnings, w) {
+				res.Warnings = append(res.Warnings, w)
+			}
+		}
+		// the host is only diagnosed if every address failed the same way
+		if i == 0 || r.dialFailure == res.dialFailure {
+			res.dialFailure = r.dialFailure
+		} else {
+			res.dialFailure = 0
+		}
+	}
+	return false
+}
+
+// mergeResolvedResult replaces res with the result of testing one of its
+// resolved addresses, keeping the results of resolving the hostname and
+// the issues found before it was dialed.
+func mergeResolvedResult(res *RHP4Result, r RHP4Result) {
+	r.NetAddress, r.Skipped, r.Quick = res.NetAddress, res.Skipped, res.Quick
+	r.ResolvedAddresses, r.ResolveAttempts, r.ResolveTime = res.ResolvedAddresses, res.ResolveAttempts, res.ResolveTime
+	r.Resolver, r.IPResults = res.Resolver, res.IPResults
+	r.Errors = append(slices.Clone(res.Errors), r.Errors...)
+	r.Warnings = append(slices.Clone(res.Warnings), r.Warnings...)
+	r.Notes = append(slices.Clone(res.Notes), r.Notes...)
+	*res = r
+}
+
+// reached returns true if the address was reached: its settings were
+// scanned or, in a quick test, the handshake completed.
+func (r RHP4Result) reached() bool {
+	return r.Scanned || (r.Quick && r.Connected && r.Handshake)
+}
+
+// checkTransports warns if the host offers both the siamux and QUIC
+// transports but only one of them is reachable.
+func checkTransports(results []RHP4Result) (warnings []string) {
+	offered := make(map[chain.Protocol]bool)
+	reachable := make(map[chain.Protocol]bool)
+	for _, res := range results {
+		if res.Skipped {
+			continue
+		}
+		proto := res.NetAddress.Protocol
+		offered[proto] = true
+		reachable[proto] = reachable[proto] || (res.Connected && res.Handshake)
+	}
+
+	if !offered[siamux.Protocol] || !offered[quic.Protocol] {
+		return nil
+	}
+
+	switch {
+	case reachable[siamux.Protocol] && !reachable[quic.Protocol]:
+		return []string{"host is reachable over siamux but not quic: check UDP port forwarding and firewall settings, browser-based renters will not be able to connect"}
+	case reachable[quic.Protocol] && !reachable[siamux.Protocol]:
+		return []string{"host is reachable over quic but not siamux: check TCP port forwarding and firewall settings"}
+	}
+	return nil
+}
+
+// correlateTransports adds a note to each QUIC result that timed out when a
+// siamux address of the same host was reachable. The host is running, so
+// the UDP traffic is most likely being dropped.
+func correlateTransports(results []RHP4Result) {
+	for i := range results {
+		res := &results[i]
+		if res.Skipped || res.NetAddress.Protocol != quic.Protocol || res.Handshake || res.dialFailure != dialTimedOut {
+			continue
+		}
+		for _, other := range results {
+			if other.Skipped || other.NetAddress.Protocol != siamux.Protocol || !other.Connected || !other.Handshake || !sameHost(*res, other) {
+				continue
+			}
+			res.Notes = append(res.Notes, fmt.Sprintf("siamux to the same host (%s) succeeded, so this is likely a UDP/firewall issue rather than the host being offline", other.NetAddress.Address))
+			break
+		}
 	}
+}
+
+// sameHost returns true if the results' addresses have the same hostname
+// or resolved to a common IP.
+func sameHost(a, b RHP4Result) bool {
+	aHost, _, errA := net.SplitHostPort(a.NetAddress.Address)
+	bHost, _, errB := net.SplitHostPort(b.NetAddress.Address)
+	if errA == nil && errB == nil && strings.EqualFold(aHost, bHost) {
+		return true
+	}
+	return slices.ContainsFunc(a.ResolvedAddresses, func(ip string) bool {
+		return slices.Contains(b.ResolvedAddresses, ip)
+	})
+}
 
+// offeredTransports returns the RHP4 transports the addresses offer and the
+// ones they do not. Addresses with unknown protocols are ignored.
+func offeredTransports(addrs []chain.NetAddress) (offered, missing []chain.Protocol) {
+	offered = []chain.Protocol{}
+	for _, proto := range []chain.Protocol{siamux.Protocol, quic.Protocol} {
+		if slices.ContainsFunc(addrs, func(addr chain.NetAddress) bool {
+			canonical, _ := canonicalProtocol(addr.Protocol)
+			return canonical == proto
+		}) {
+			offered = append(offered, proto)
+		} else {
+			missing = append(missing, proto)
+		}
+	}
+	return offered, missing
+}
+
+// A dialFailure is the reason connecting to a host failed.
+type dialFailure int
+
+const (
+	dialRefused dialFailure = iota + 1
+	dialTimedOut
+)
+
+// diagnoseUnreachable explains the results if the host's hostnames
+// resolved but no connection could be made because every dialed address
+// refused the connection or timed out. Hostnames that resolved to more
+// than one address had each of them dialed, up to the server's limit, and
+// the dialed addresses are listed. The diagnosis is based on the siamux
+// addresses, since UDP ports cannot refuse connections, so QUIC addresses
+// only need to have timed out. It returns an empty string if the results
+// do not match either pattern.
+func diagnoseUnreachable(results []RHP4Result) string {
+	var refused, timedOut bool
+	var hostnames, resolved, ports []string
+	for _, res := range results {
+		if res.Skipped || res.NetAddress.Address == "" {
+			continue
+		}
+		hostname, port, err := net.SplitHostPort(res.NetAddress.Address)
+		if err != nil || dns.ParseIP(hostname) != nil || len(res.ResolvedAddresses) == 0 {
+			// the failure cannot be attributed to a resolved hostname
+			return ""
+		} else if res.NetAddress.Protocol == quic.Protocol {
+			if res.dialFailure != dialTimedOut {
+				return ""
+			}
+			continue
+		}
+
+		switch res.dialFailure {
+		case dialRefused:
+			refused = true
+		case dialTimedOut:
+			timedOut = true
+		default:
+			return ""
+		}
+		dialed := res.ResolvedAddresses
+		if len(res.IPResults) > 0 {
+			dialed = nil
+			for _, ip := range res.IPResults {
+				dialed = append(dialed, ip.Address)
+			}
+		}
+		for _, addr := range dialed {
+			if !slices.Contains(resolved, addr) {
+				resolved = append(resolved, addr)
+			}
+		}
+		if !slices.Contains(hostnames, hostname) {
+			hostnames = append(hostnames, hostname)
+		}
+		if !slices.Contains(ports, port) {
+			ports = append(ports, port)
+		}
+	}
+
+	if len(resolved) == 0 || refused == timedOut {
+		return ""
+	}
+	addresses := "every address"
+	if len(resolved) == 1 {
+		addresses = "the address"
+	}
+	if refused {
+		return fmt.Sprintf("DNS is working, but the host is not accepting connections: %s resolved to %s and %s refused the connection. Check that hostd is running and listening on TCP port %s and that the port is forwarded to it", strings.Join(hostnames, ", "), strings.Join(resolved, ", "), addresses, strings.Join(ports, ", "))
+	}
+	return fmt.Sprintf("DNS is working, but the host is not reachable: %s resolved to %s and connections to %s timed out. Check that TCP port %s is forwarded to hostd and not blocked by a firewall", strings.Join(hostnames, ", "), strings.Join(resolved, ", "), addresses, strings.Join(ports, ", "))
+}
+
+// defaultHardforkWindow is how many blocks before the v2 require height
+// hosts without a working RHP4 address are warned.
+const defaultHardforkWindow = 144 * 7 // 1 week
+
+// checkHardfork warns if the v2 hardfork's require height is within window
+// blocks and none of the host's RHP4 addresses could be scanned. After the
+// require height, only RHP4 can be used to form contracts. If an address
+// was skipped, it may still work, so no warning is given.
+func checkHardfork(cs consensus.State, window uint64, results []RHP4Result) []string {
+	if cs.Network == nil || window == 0 {
+		return nil
+	}
+	height, requireHeight := cs.Index.Height, cs.Network.HardforkV2.RequireHeight
+	if height >= requireHeight || requireHeight-height > window {
+		return nil
+	}
+	for _, res := range results {
+		if res.reached() || res.Skipped {
+			return nil
+		}
+	}
+	return []string{fmt.Sprintf("the v2 hardfork requires RHP4 in %d blocks, but no RHP4 address could be reached: update the host and announce a working RHP4 address", requireHeight-height)}
+}
+
+// testRHP4Transports tests the address using its protocol's transport.
+func testRHP4Transports(ctx context.Context, p scanParams, netAddr chain.NetAddress, dialAddr string, res *RHP4Result) {
 	switch netAddr.Protocol {
 	case siamux.Protocol:
-		testRHP4SiaMux(ctx, currentVersion, tip, hostKey, netAddr, res)
+		testRHP4SiaMux(ctx, p, dialAddr, res)
 	case quic.Protocol:
-		testRHP4Quic(ctx, currentVersion, tip, hostKey, netAddr, res)
+		if p.proxy != nil {
+			res.Notes = append(res.Notes, "QUIC connections cannot be proxied: the host was dialed directly")
+		}
+		if p.sourceAddr != nil {
+			res.Notes = append(res.Notes, fmt.Sprintf("QUIC connections cannot be bound to the source address %s: the default interface was used", p.sourceAddr))
+		}
+		testRHP4Quic(ctx, p, netAddr, dialAddr, res)
 	default:
 		res.Errors = append(res.Errors, fmt.Sprintf("unknown protocol %q", netAddr.Protocol))
 	}
@@ -0,0 +1,1517 @@
+package troubleshoot
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	quicgo "github.com/quic-go/quic-go"
+	"go.sia.tech/core/consensus"
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	rhp4 "go.sia.tech/coreutils/rhp/v4"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+	"go.sia.tech/coreutils/threadgroup"
+	"go.sia.tech/mux"
+	"go.uber.org/zap"
+)
+
+func newTestCertificate(t *testing.T, hostname string, notAfter time.Time) *x509.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: hostname},
+		DNSNames:     []string{hostname},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     notAfter,
+	}
+	buf, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func hasIssue(issues []string, substr string) bool {
+	for _, issue := range issues {
+		if strings.Contains(issue, substr) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCheckCertificate(t *testing.T) {
+	hasWarning := func(res RHP4Result, substr string) bool {
+		return hasIssue(res.Warnings, substr)
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		var res RHP4Result
+		checkCertificate(newTestCertificate(t, "host.sia.tech", time.Now().Add(90*24*time.Hour)), "host.sia.tech", &res)
+		if res.Certificate == nil {
+			t.Fatal("expected certificate details")
+		} else if !res.Certificate.HostnameMatch {
+			t.Fatal("expected hostname to match")
+		} else if len(res.Warnings) != 0 {
+			t.Fatalf("expected no warnings, got %v", res.Warnings)
+		}
+	})
+
+	t.Run("hostname mismatch", func(t *testing.T) {
+		var res RHP4Result
+		checkCertificate(newTestCertificate(t, "other.sia.tech", time.Now().Add(90*24*time.Hour)), "host.sia.tech", &res)
+		if res.Certificate.HostnameMatch {
+			t.Fatal("expected hostname mismatch")
+		} else if res.Certificate.ServerName != "host.sia.tech" || !slices.Equal(res.Certificate.Names, []string{"other.sia.tech"}) {
+			t.Fatalf("expected the server name and certificate names, got %+v", res.Certificate)
+		} else if !hasWarning(res, `certificate is not valid for "host.sia.tech", it only covers "other.sia.tech"`) {
+			t.Fatalf("expected hostname warning, got %v", res.Warnings)
+		} else if hasWarning(res, "expire") {
+			t.Fatalf("expected no expiry warning, got %v", res.Warnings)
+		}
+	})
+
+	t.Run("common name only", func(t *testing.T) {
+		cert := newTestCertificate(t, "host.sia.tech", time.Now().Add(90*24*time.Hour))
+		cert.DNSNames = nil
+		var res RHP4Result
+		checkCertificate(cert, "host.sia.tech", &res)
+		if res.Certificate.HostnameMatch {
+			t.Fatal("expected the common name to be ignored")
+		} else if !hasWarning(res, `no subject alternative names and clients ignore its common name "host.sia.tech"`) {
+			t.Fatalf("expected common name warning, got %v", res.Warnings)
+		}
+	})
+
+	t.Run("expiring", func(t *testing.T) {
+		var res RHP4Result
+		checkCertificate(newTestCertificate(t, "host.sia.tech", time.Now().Add(7*24*time.Hour)), "host.sia.tech", &res)
+		if !hasWarning(res, "expires in") {
+			t.Fatalf("expected expiry warning, got %v", res.Warnings)
+		}
+	})
+}
+
+func TestCertificateError(t *testing.T) {
+	cert := newTestCertificate(t, "other.sia.tech", time.Now().Add(time.Hour))
+	tests := []struct {
+		name     string
+		err      error
+		expected string
+	}{
+		{"hostname", x509.HostnameError{Certificate: cert, Host: "host.sia.tech"}, `certificate is not valid for "host.sia.tech", it only covers "other.sia.tech"`},
+		{"hostname without certificate", x509.HostnameError{Host: "host.sia.tech"}, `certificate is not valid for "host.sia.tech"`},
+		{"authority", x509.UnknownAuthorityError{}, "not signed by a trusted authority"},
+		{"expired", x509.CertificateInvalidError{Reason: x509.Expired}, "certificate has expired"},
+	}
+	for _, test := range tests {
+		if msg := certificateError(fmt.Errorf("tls: %w", test.err)); !strings.Contains(msg, test.expected) {
+			t.Errorf("%s: expected %q, got %q", test.name, test.expected, msg)
+		}
+	}
+}
+
+func TestTestRHP4QuicHostnameMismatch(t *testing.T) {
+	l, err := quicgo.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{newQUICCertificate(t)},
+		NextProtos:   []string{quic.TLSNextProtoRHP4},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	p := scanParams{
+		scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, handshakeTimeout: 5 * time.Second},
+		hostKey:    types.GeneratePrivateKey().PublicKey(),
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	var res RHP4Result
+	// the certificate only covers localhost
+	testRHP4Quic(context.Background(), p, chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech:" + port}, l.Addr().String(), &res)
+	if res.Handshake {
+		t.Fatal("expected the handshake to fail")
+	} else if res.Certificate == nil || res.Certificate.HostnameMatch || res.Certificate.ServerName != "host.sia.tech" {
+		t.Fatalf("expected the certificate not to match the server name, got %+v", res.Certificate)
+	} else if !hasIssue(res.Errors, `certificate is not valid for "host.sia.tech", it only covers "localhost"`) {
+		t.Fatalf("expected hostname error, got %v", res.Errors)
+	}
+}
+
+func TestCheckPrices(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+
+	signedPrices := func(validity time.Duration) proto4.HostPrices {
+		prices := proto4.HostPrices{
+			StoragePrice: types.Siacoins(1),
+			ValidUntil:   time.Now().Add(validity),
+		}
+		prices.Signature = hostKey.SignHash(prices.SigHash())
+		return prices
+	}
+
+	t.Run("valid", func(t *testing.T) {
+		var res RHP4Result
+		checkPrices(signedPrices(time.Hour), hostKey.PublicKey(), &res)
+		if len(res.Errors) != 0 || len(res.Warnings) != 0 {
+			t.Fatalf("expected no issues, got errors %v warnings %v", res.Errors, res.Warnings)
+		}
+	})
+
+	t.Run("near expiry", func(t *testing.T) {
+		var res RHP4Result
+		checkPrices(signedPrices(2*time.Second), hostKey.PublicKey(), &res)
+		if !hasIssue(res.Warnings, "prices expire in") {
+			t.Fatalf("expected expiry warning, got %v", res.Warnings)
+		} else if len(res.Errors) != 0 {
+			t.Fatalf("expected no errors, got %v", res.Errors)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		var res RHP4Result
+		checkPrices(signedPrices(-time.Minute), hostKey.PublicKey(), &res)
+		if !hasIssue(res.Errors, "already expired") {
+			t.Fatalf("expected expired error, got %v", res.Errors)
+		}
+	})
+
+	t.Run("invalid signature", func(t *testing.T) {
+		var res RHP4Result
+		prices := signedPrices(time.Hour)
+		prices.StoragePrice = types.Siacoins(2)
+		checkPrices(prices, hostKey.PublicKey(), &res)
+		if !hasIssue(res.Errors, "invalid signature") {
+			t.Fatalf("expected signature error, got %v", res.Errors)
+		}
+	})
+}
+
+func TestCheckSettings(t *testing.T) {
+	settings := proto4.HostSettings{
+		AcceptingContracts:  true,
+		MaxCollateral:       types.Siacoins(1000),
+		MaxContractDuration: 144 * 14,
+		Prices: proto4.HostPrices{
+			StoragePrice: types.Siacoins(1),
+			Collateral:   types.Siacoins(3),
+		},
+	}
+
+	tests := []struct {
+		name       string
+		thresholds Thresholds
+		warnings   []string
+	}{
+		{"default", defaultThresholds, []string{"max contract duration of 2016 blocks"}},
+		{"short duration", Thresholds{MinCollateralRatio: 2, MinContractDuration: 144 * 7}, nil},
+		{"high ratio", Thresholds{MinCollateralRatio: 3.5, MinContractDuration: 144 * 7}, []string{"less than 3.5 times the storage price"}},
+		{"exact ratio", Thresholds{MinCollateralRatio: 3, MinContractDuration: 144 * 7}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var res RHP4Result
+			checkSettings(settings, tt.thresholds, &res)
+			if len(res.Errors) != 0 {
+				t.Fatalf("expected no errors, got %v", res.Errors)
+			} else if len(res.Warnings) != len(tt.warnings) {
+				t.Fatalf("expected warnings %v, got %v", tt.warnings, res.Warnings)
+			}
+			for _, w := range tt.warnings {
+				if !hasIssue(res.Warnings, w) {
+					t.Fatalf("expected warning %q, got %v", w, res.Warnings)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckContractCollateral(t *testing.T) {
+	// 1 TB for a month at the collateral price
+	monthlyCollateral := types.Siacoins(100)
+	collateralPrice := monthlyCollateral.Div64(bytesPerTB * blocksPerMonth)
+
+	tests := []struct {
+		name          string
+		maxCollateral types.Currency
+		collateral    types.Currency
+		duration      uint64
+		warning       bool
+	}{
+		{"sufficient", types.Siacoins(1000), collateralPrice, blocksPerMonth * 6, false},
+		{"exact", monthlyCollateral, collateralPrice, blocksPerMonth, false},
+		{"long duration", types.Siacoins(1000), collateralPrice, blocksPerMonth * 12, true},
+		{"low max collateral", types.Siacoins(50), collateralPrice, blocksPerMonth, true},
+		{"overflow", types.Siacoins(1000), types.MaxCurrency, blocksPerMonth, true},
+		// missing values are reported by checkSettings instead
+		{"no max collateral", types.ZeroCurrency, collateralPrice, blocksPerMonth, false},
+		{"no collateral", types.Siacoins(1000), types.ZeroCurrency, blocksPerMonth, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			settings := proto4.HostSettings{
+				MaxCollateral:       tt.maxCollateral,
+				MaxContractDuration: tt.duration,
+				Prices:              proto4.HostPrices{Collateral: tt.collateral},
+			}
+			var res RHP4Result
+			checkContractCollateral(settings, &res)
+			if len(res.Errors) != 0 {
+				t.Fatalf("expected no errors, got %v", res.Errors)
+			} else if tt.warning && !hasIssue(res.Warnings, "renters cannot form full-duration contracts") {
+				t.Fatalf("expected collateral warning, got %v", res.Warnings)
+			} else if !tt.warning && len(res.Warnings) != 0 {
+				t.Fatalf("expected no warnings, got %v", res.Warnings)
+			}
+		})
+	}
+}
+
+func TestCheckTipHeight(t *testing.T) {
+	p := scanParams{
+		tip: types.ChainIndex{Height: 100},
+	}
+
+	var res RHP4Result
+	checkTipHeight(90, p, &res)
+	if !hasIssue(res.Errors, "tip height") {
+		t.Fatalf("expected tip height error, got %v", res.Errors)
+	}
+
+	res = RHP4Result{}
+	checkTipHeight(99, p, &res)
+	if len(res.Errors) != 0 || len(res.Warnings) != 0 {
+		t.Fatalf("expected no issues, got errors %v warnings %v", res.Errors, res.Warnings)
+	}
+
+	// a stale server state should not be blamed on the host
+	p.stateAge = 2 * maxStateAge
+	res = RHP4Result{}
+	checkTipHeight(90, p, &res)
+	if len(res.Errors) != 0 {
+		t.Fatalf("expected no errors with stale state, got %v", res.Errors)
+	} else if !hasIssue(res.Warnings, "consensus state") {
+		t.Fatalf("expected stale state warning, got %v", res.Warnings)
+	}
+}
+
+func TestTestRHP4LegacyPorts(t *testing.T) {
+	tests := []struct {
+		addr chain.NetAddress
+		warn bool
+	}{
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "127.0.0.1:9982"}, true},
+		{chain.NetAddress{Protocol: quic.Protocol, Address: "127.0.0.1:9983"}, true},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "127.0.0.1:9984"}, false},
+		{chain.NetAddress{Protocol: quic.Protocol, Address: "127.0.0.1:9984"}, false},
+		{chain.NetAddress{Protocol: quic.Protocol, Address: "127.0.0.1:443"}, false},
+	}
+
+	// the context is canceled so only the address checks run
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, test := range tests {
+		t.Run(string(test.addr.Protocol)+"/"+test.addr.Address, func(t *testing.T) {
+			var res RHP4Result
+			testRHP4(ctx, scanParams{scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}}}, test.addr, &res)
+			if warned := hasIssue(res.Warnings, "check that the announced port is correct"); warned != test.warn {
+				t.Fatalf("expected warning %t, got %v", test.warn, res.Warnings)
+			}
+		})
+	}
+}
+
+func TestNormalizeAddress(t *testing.T) {
+	tests := []struct {
+		addr     chain.NetAddress
+		expected chain.NetAddress
+		warnings int
+	}{
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, 0},
+		{chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech:9984"}, chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech:9984"}, 0},
+		{chain.NetAddress{Protocol: "SiaMux", Address: "host.sia.tech:9984"}, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, 1},
+		{chain.NetAddress{Protocol: "SIAMUX", Address: "host.sia.tech:9984"}, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, 1},
+		{chain.NetAddress{Protocol: "sia-mux", Address: "host.sia.tech:9984"}, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, 1},
+		{chain.NetAddress{Protocol: "sia_mux", Address: "host.sia.tech:9984"}, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, 1},
+		{chain.NetAddress{Protocol: " siamux ", Address: "host.sia.tech:9984"}, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, 1},
+		{chain.NetAddress{Protocol: "QUIC", Address: "host.sia.tech:9984"}, chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech:9984"}, 1},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "siamux://host.sia.tech:9984"}, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, 1},
+		{chain.NetAddress{Protocol: "Quic", Address: "QUIC://host.sia.tech:9984"}, chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech:9984"}, 2},
+		// a scheme for the other protocol is not removed
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "quic://host.sia.tech:9984"}, chain.NetAddress{Protocol: siamux.Protocol, Address: "quic://host.sia.tech:9984"}, 0},
+		{chain.NetAddress{Protocol: "rhp2", Address: "rhp2://host.sia.tech:9982"}, chain.NetAddress{Protocol: "rhp2", Address: "rhp2://host.sia.tech:9982"}, 0},
+	}
+
+	for _, test := range tests {
+		addr, warnings := normalizeAddress(test.addr)
+		if addr != test.expected {
+			t.Errorf("expected %v for %v, got %v", test.expected, test.addr, addr)
+		} else if len(warnings) != test.warnings {
+			t.Errorf("expected %d warnings for %v, got %v", test.warnings, test.addr, warnings)
+		}
+	}
+
+	// normalized addresses are tested instead of rejected
+	host := Host{
+		PublicKey:        types.GeneratePrivateKey().PublicKey(),
+		RHP4NetAddresses: []chain.NetAddress{{Protocol: "SiaMux", Address: "siamux://host.sia.tech:9984"}},
+	}
+	if res := dryRun(host, scanConfig{}, nil).RHP4[0]; len(res.Errors) != 0 {
+		t.Fatalf("expected no errors, got %v", res.Errors)
+	} else if res.NetAddress != (chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}) {
+		t.Fatalf("expected the normalized address, got %v", res.NetAddress)
+	} else if !hasIssue(res.Warnings, "is not canonical") || !hasIssue(res.Warnings, "should not include a scheme") {
+		t.Fatalf("expected normalization warnings, got %v", res.Warnings)
+	}
+}
+
+func TestWithDefaultPort(t *testing.T) {
+	tests := []struct {
+		addr     chain.NetAddress
+		expected string
+		changed  bool
+	}{
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech"}, "host.sia.tech:9984", true},
+		{chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech"}, "host.sia.tech:9984", true},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "127.0.0.1"}, "127.0.0.1:9984", true},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "2001:db8::1"}, "[2001:db8::1]:9984", true},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "[2001:db8::1]"}, "[2001:db8::1]:9984", true},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "fe80::1%eth0"}, "[fe80::1%eth0]:9984", true},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "[fe80::1%eth0]"}, "[fe80::1%eth0]:9984", true},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9982"}, "host.sia.tech:9982", false},
+		{chain.NetAddress{Protocol: quic.Protocol, Address: "[2001:db8::1]:9982"}, "[2001:db8::1]:9982", false},
+		{chain.NetAddress{Protocol: "unknown", Address: "host.sia.tech"}, "host.sia.tech", false},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: "host:sia:tech"}, "host:sia:tech", false},
+		{chain.NetAddress{Protocol: siamux.Protocol, Address: ""}, "", false},
+	}
+
+	for _, test := range tests {
+		addr, changed := withDefaultPort(test.addr)
+		if addr.Address != test.expected {
+			t.Errorf("expected %q for %q, got %q", test.expected, test.addr.Address, addr.Address)
+		} else if changed != test.changed {
+			t.Errorf("expected changed %v for %q, got %v", test.changed, test.addr.Address, changed)
+		}
+	}
+}
+
+func TestCheckAddressIPv6(t *testing.T) {
+	tests := []struct {
+		address  string
+		hostname string
+		port     string
+		err      string
+	}{
+		{address: "[2001:db8::1]:9984", hostname: "2001:db8::1", port: "9984"},
+		{address: "[fe80::1%eth0]:9984", hostname: "fe80::1%eth0", port: "9984"},
+		{address: "2001:db8::1:9984", err: `such as "[2001:db8::1]:9984"`},
+		{address: "fe80::1%eth0:9984", err: `such as "[fe80::1%eth0]:9984"`},
+		{address: "2001:db8::1", err: "too many colons"},
+		{address: "[2001:db8::1:9984", err: "missing ']'"},
+	}
+	for _, test := range tests {
+		var res RHP4Result
+		_, hostname, port, ok := checkAddress(scanConfig{}, chain.NetAddress{Protocol: siamux.Protocol, Address: test.address}, &res)
+		if test.err != "" {
+			if ok || !hasIssue(res.Errors, test.err) {
+				t.Errorf("expected error containing %q for %q, got %v", test.err, test.address, res.Errors)
+			}
+		} else if !ok {
+			t.Errorf("expected %q to be valid, got %v", test.address, res.Errors)
+		} else if hostname != test.hostname || port != test.port {
+			t.Errorf("expected %q to parse as %q %q, got %q %q", test.address, test.hostname, test.port, hostname, port)
+		}
+	}
+}
+
+func TestTestRHP4IPv6Literal(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	l, err := net.Listen("tcp", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 is not available:", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	srv := rhp4.NewServer(hostKey, stubChain{}, nil, nil, stubSettings(testHostSettings("hostd v2.1.0")), nil)
+	go siamux.Serve(l, srv, zap.NewNop())
+
+	for _, family := range []AddressFamily{AddressFamilyAny, AddressFamilyIPv6} {
+		p := scanParams{
+			scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second, handshakeTimeout: time.Second, settingsTimeout: time.Second, resolvers: defaultResolvers()},
+			hostKey:    hostKey.PublicKey(),
+			tip:        types.ChainIndex{Height: 100},
+			family:     family,
+		}
+		var res RHP4Result
+		testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: l.Addr().String()}, &res)
+		if !res.Scanned {
+			t.Fatalf("expected %s test of %q to succeed, got %v", family, l.Addr(), res.Errors)
+		} else if !slices.Equal(res.ResolvedAddresses, []string{"::1"}) {
+			t.Fatalf("expected the literal to be reported, got %v", res.ResolvedAddresses)
+		} else if res.ResolveTime != 0 {
+			t.Fatalf("expected no resolve time for an IP literal, got %s", res.ResolveTime)
+		}
+	}
+
+	// an IPv6 literal has no IPv4 addresses
+	p := scanParams{
+		scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second, resolvers: []resolver{dnsResolver(fallbackResolver)}},
+		family:     AddressFamilyIPv4,
+	}
+	var res RHP4Result
+	testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: l.Addr().String()}, &res)
+	if !hasIssue(res.Errors, "found no ipv4 addresses") {
+		t.Fatalf("expected no IPv4 addresses, got %v", res.Errors)
+	}
+}
+
+func TestCheckTimeout(t *testing.T) {
+	var res RHP4Result
+	if checkTimeout(context.Background(), "dial", &res) {
+		t.Fatal("expected no timeout")
+	} else if res.TimedOut || len(res.Errors) != 0 {
+		t.Fatal("expected result to be unchanged")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	if !checkTimeout(ctx, "dial", &res) {
+		t.Fatal("expected timeout")
+	} else if !res.TimedOut {
+		t.Fatal("expected result to be marked as timed out")
+	} else if !hasIssue(res.Errors, "timed out during dial") {
+		t.Fatalf("expected timeout error, got %v", res.Errors)
+	}
+}
+
+func TestTestRHP4TimedOut(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	var res RHP4Result
+	testRHP4(ctx, scanParams{scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}}}, chain.NetAddress{Protocol: siamux.Protocol, Address: "127.0.0.1:9984"}, &res)
+	if !res.TimedOut {
+		t.Fatalf("expected result to be marked as timed out, got errors %v", res.Errors)
+	} else if res.Connected {
+		t.Fatal("expected no connection")
+	}
+}
+
+func TestTestRHP4Override(t *testing.T) {
+	// grab a free port and close the listener so the dial is refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	p := scanParams{
+		scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second},
+		overrideIP: net.ParseIP("127.0.0.1"),
+	}
+	var res RHP4Result
+	// the hostname does not resolve, so the override must be dialed
+	// without a DNS lookup
+	testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: "unknown.invalid:" + port}, &res)
+	if res.ResolveAttempts != 0 || res.ResolveTime != 0 {
+		t.Fatalf("expected no DNS lookup, got %d attempts in %s", res.ResolveAttempts, res.ResolveTime)
+	} else if len(res.ResolvedAddresses) != 1 || res.ResolvedAddresses[0] != "127.0.0.1" {
+		t.Fatalf("expected override address to be reported, got %v", res.ResolvedAddresses)
+	} else if !hasIssue(res.Notes, "override address") {
+		t.Fatalf("expected override note, got %v", res.Notes)
+	} else if !hasIssue(res.Errors, "connection refused at \"127.0.0.1:"+port+"\"") {
+		t.Fatalf("expected override address to be dialed, got %v", res.Errors)
+	}
+}
+
+func TestTestRHP4ResolveTime(t *testing.T) {
+	// grab a free port and close the listener so the dial is refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	const delay = 50 * time.Millisecond
+	slow := resolver{
+		name: "slow",
+		lookup: func(ctx context.Context, _, hostname string, _ int) ([]net.IP, error) {
+			if ip := net.ParseIP(hostname); ip != nil {
+				return []net.IP{ip}, nil
+			}
+			time.Sleep(delay)
+			return []net.IP{net.ParseIP("127.0.0.1")}, nil
+		},
+	}
+	p := scanParams{
+		scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second, resolvers: []resolver{slow}},
+	}
+
+	var res RHP4Result
+	testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:" + port}, &res)
+	if res.ResolveTime < delay {
+		t.Fatalf("expected resolve time of at least %s, got %s", delay, res.ResolveTime)
+	}
+
+	// IP literals are not resolved with DNS
+	res = RHP4Result{}
+	testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: "127.0.0.1:" + port}, &res)
+	if res.ResolveTime != 0 {
+		t.Fatalf("expected no resolve time for an IP literal, got %s", res.ResolveTime)
+	} else if len(res.ResolvedAddresses) != 1 {
+		t.Fatalf("expected the IP to be reported, got %v", res.ResolvedAddresses)
+	}
+}
+
+func TestTestRHP4AddressFamily(t *testing.T) {
+	// grab a free port and close the listener so the dial is refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	l.Close()
+
+	var networks []string
+	records := resolver{
+		name: "mock",
+		lookup: func(_ context.Context, network, _ string, _ int) (ips []net.IP, _ error) {
+			networks = append(networks, network)
+			if network != "ip6" {
+				ips = append(ips, net.ParseIP("127.0.0.1"))
+			}
+			if network != "ip4" {
+				ips = append(ips, net.ParseIP("::1"))
+			}
+			return ips, nil
+		},
+	}
+	p := scanParams{
+		scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second, resolvers: []resolver{records}},
+		family:     AddressFamilyIPv4,
+	}
+	var res RHP4Result
+	testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:" + port}, &res)
+	if !slices.Equal(networks, []string{"ip4"}) {
+		t.Fatalf("expected an IPv4 lookup, got %v", networks)
+	} else if !slices.Equal(res.ResolvedAddresses, []string{"127.0.0.1"}) {
+		t.Fatalf("expected only IPv4 addresses, got %v", res.ResolvedAddresses)
+	} else if !hasIssue(res.Errors, "connection refused at \"127.0.0.1:"+port+"\"") {
+		t.Fatalf("expected the IPv4 address to be dialed, got %v", res.Errors)
+	}
+}
+
+// A countingListener counts the connections it accepts.
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int64
+}
+
+func (cl *countingListener) Accept() (net.Conn, error) {
+	conn, err := cl.Listener.Accept()
+	if err == nil {
+		cl.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestTestRHP4MultipleIPs(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	tl, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tl.Close() })
+	l := &countingListener{Listener: tl}
+	go siamux.Serve(l, rhp4.NewServer(hostKey, stubChain{}, nil, nil, stubSettings(testHostSettings("hostd v2.1.0")), nil), zap.NewNop())
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	// the host only listens on 127.0.0.1, so the other loopback addresses
+	// refuse the connection
+	records := func(addrs ...string) resolver {
+		return resolver{
+			name: "mock",
+			lookup: func(context.Context, string, string, int) (ips []net.IP, _ error) {
+				for _, addr := range addrs {
+					ips = append(ips, net.ParseIP(addr))
+				}
+				return ips, nil
+			},
+		}
+	}
+	var tryAcquireScan func() (func(), bool)
+	test := func(maxIPs int, addrs ...string) RHP4Result {
+		t.Helper()
+		p := scanParams{
+			scanConfig: scanConfig{
+				retry:            retryPolicy{Attempts: 1},
+				dialTimeout:      time.Second,
+				handshakeTimeout: time.Second,
+				settingsTimeout:  time.Second,
+				resolvers:        []resolver{records(addrs...)},
+			},
+			hostKey: hostKey.PublicKey(),
+			// stubChain's tip
+			tip:            types.ChainIndex{Height: 100},
+			maxIPs:         maxIPs,
+			tryAcquireScan: tryAcquireScan,
+		}
+		var res RHP4Result
+		testRHP4(context.Background(), p, chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:" + port}, &res)
+		dialed := len(addrs)
+		if maxIPs > 0 {
+			dialed = min(dialed, maxIPs)
+		}
+		if len(res.IPResults) != dialed {
+			t.Fatalf("expected %d IP results, got %+v", dialed, res.IPResults)
+		}
+		for i, ip := range res.IPResults {
+			if ip.Address != addrs[i] {
+				t.Fatalf("expected IP result %d to be %s, got %s", i, addrs[i], ip.Address)
+			}
+		}
+		return res
+	}
+
+	// the working address is tested even though it resolved last
+	res := test(0, "127.0.0.2", "127.0.0.1")
+	if !res.Scanned {
+		t.Fatalf("expected the working address to be scanned, got errors %v", res.Errors)
+	} else if res.IPResults[0].Handshake || !hasIssue(res.IPResults[0].Errors, "connection refused at \"127.0.0.2:"+port+"\"") {
+		t.Fatalf("expected 127.0.0.2 to refuse the connection, got %+v", res.IPResults[0])
+	} else if !res.IPResults[1].Handshake {
+		t.Fatalf("expected 127.0.0.1 to complete the handshake, got %+v", res.IPResults[1])
+	} else if !hasIssue(res.Warnings, "but 127.0.0.2 could not be reached") {
+		t.Fatalf("expected a warning about the broken address, got %v", res.Warnings)
+	} else if len(res.Errors) != 0 {
+		t.Fatalf("expected no errors, got %v", res.Errors)
+	}
+
+	// every address working is not warned, and the address that completed
+	// the handshake first is scanned without being dialed again
+	l.accepted.Store(0)
+	res = test(0, "127.0.0.1", "127.0.0.1")
+	if !res.Scanned || hasIssue(res.Warnings, "could not be reached") {
+		t.Fatalf("expected no reachability warning, got errors %v and warnings %v", res.Errors, res.Warnings)
+	} else if n := l.accepted.Load(); n != 2 {
+		t.Fatalf("expected each address to be dialed once, got %d connections", n)
+	}
+
+	// only the first addresses up to the limit are dialed
+	res = test(2, "127.0.0.1", "127.0.0.2", "127.0.0.3")
+	if !res.Scanned {
+		t.Fatalf("expected the working address to be scanned, got errors %v", res.Errors)
+	} else if !hasIssue(res.Warnings, `only the first 2 of the 3 addresses "host.sia.tech" resolved to were dialed`) {
+		t.Fatalf("expected a limit warning, got %v", res.Warnings)
+	} else if !hasIssue(res.Notes, "the first 2 were dialed") {
+		t.Fatalf("expected a note about the dialed addresses, got %v", res.Notes)
+	}
+
+	// the addresses are tested concurrently on the free scan slots only.
+	// The address's own slot is already held.
+	sem := make(chan struct{}, 2)
+	sem <- struct{}{}
+	var acquired atomic.Int64
+	tryAcquireScan = func() (func(), bool) {
+		select {
+		case sem <- struct{}{}:
+			acquired.Add(1)
+			return func() { <-sem }, true
+		default:
+			return nil, false
+		}
+	}
+	res = test(0, "127.0.0.2", "127.0.0.3", "127.0.0.1")
+	if !res.Scanned {
+		t.Fatalf("expected the working address to be scanned, got errors %v", res.Errors)
+	} else if n := acquired.Load(); n != 1 {
+		t.Fatalf("expected 1 additional slot to be acquired, got %d", n)
+	} else if len(sem) != 1 {
+		t.Fatalf("expected the additional slot to be released, got %d held", len(sem))
+	}
+	tryAcquireScan = nil
+
+	// if every address fails, each failure is reported
+	res = test(0, "127.0.0.2", "127.0.0.3")
+	if res.PortOpen || res.Scanned {
+		t.Fatal("expected the host to be unreachable")
+	} else if !hasIssue(res.Errors, "127.0.0.2:"+port) || !hasIssue(res.Errors, "127.0.0.3:"+port) {
+		t.Fatalf("expected both addresses' errors, got %v", res.Errors)
+	} else if res.dialFailure != dialRefused {
+		t.Fatalf("expected the connection to be refused, got %v", res.dialFailure)
+	} else if res.DialAttempts != 2 {
+		t.Fatalf("expected 2 dial attempts, got %d", res.DialAttempts)
+	}
+}
+
+func TestTestRHP4SiaMuxPortOpen(t *testing.T) {
+	// accept connections but close them immediately so the handshake fails
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+
+	p := scanParams{
+		scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second},
+		hostKey:    types.GeneratePrivateKey().PublicKey(),
+	}
+	var res RHP4Result
+	testRHP4SiaMux(context.Background(), p, l.Addr().String(), &res)
+	if !res.PortOpen {
+		t.Fatalf("expected port to be open, got errors %v", res.Errors)
+	} else if res.Handshake {
+		t.Fatal("expected handshake to fail")
+	}
+}
+
+func TestTestRHP4SiaMuxVersion(t *testing.T) {
+	sk := types.GeneratePrivateKey()
+
+	tests := []struct {
+		name    string
+		accept  func(net.Conn)
+		version string
+		err     string
+	}{
+		{"current", func(conn net.Conn) {
+			if m, err := mux.Accept(conn, ed25519.PrivateKey(sk)); err == nil {
+				m.Close()
+			}
+		}, "siamux/3", ""},
+		{"outdated", func(conn net.Conn) {
+			// read the client's version and reply with an older one
+			conn.Read(make([]byte, 1))
+			conn.Write([]byte{2})
+		}, "", "host only supports siamux version 2"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { l.Close() })
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					return
+				}
+				defer conn.Close()
+				test.accept(conn)
+			}()
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			p := scanParams{
+				scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second},
+				hostKey:    sk.PublicKey(),
+			}
+			var res RHP4Result
+			testRHP4SiaMux(ctx, p, l.Addr().String(), &res)
+			if res.TransportVersion != test.version {
+				t.Fatalf("expected transport version %q, got %q (errors %v)", test.version, res.TransportVersion, res.Errors)
+			} else if test.err != "" && !hasIssue(res.Errors, test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, res.Errors)
+			}
+		})
+	}
+}
+
+func TestQuicTransportVersion(t *testing.T) {
+	tests := []struct {
+		cs       tls.ConnectionState
+		expected string
+	}{
+		{tls.ConnectionState{NegotiatedProtocol: quic.TLSNextProtoRHP4, Version: tls.VersionTLS13}, "sia/rhp4 (TLS 1.3)"},
+		{tls.ConnectionState{Version: tls.VersionTLS13}, "unknown protocol (TLS 1.3)"},
+	}
+	for _, test := range tests {
+		if version := quicTransportVersion(test.cs); version != test.expected {
+			t.Fatalf("expected %q, got %q", test.expected, version)
+		}
+	}
+}
+
+func TestQuicPeerResponded(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"timeout", &quicgo.IdleTimeoutError{}, false},
+		{"local", &quicgo.TransportError{ErrorCode: quicgo.ConnectionRefused}, false},
+		{"remote", &quicgo.TransportError{ErrorCode: quicgo.ConnectionRefused, Remote: true}, true},
+		{"remote application", fmt.Errorf("dial failed: %w", &quicgo.ApplicationError{Remote: true}), true},
+		{"version", &quicgo.VersionNegotiationError{}, true},
+		{"other", errors.New("foo"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if quicPeerResponded(test.err) != test.expected {
+				t.Fatalf("expected %t", test.expected)
+			}
+		})
+	}
+}
+
+func TestQuicALPNMismatch(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{"remote", &quicgo.TransportError{ErrorCode: alertNoApplicationProtocol, Remote: true}, true},
+		{"local", fmt.Errorf("dial failed: %w", &quicgo.TransportError{ErrorCode: alertNoApplicationProtocol}), true},
+		{"other alert", &quicgo.TransportError{ErrorCode: quicgo.TransportErrorCode(0x100 + 42), Remote: true}, false},
+		{"other", errors.New("foo"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if quicALPNMismatch(test.err) != test.expected {
+				t.Fatalf("expected %t", test.expected)
+			}
+		})
+	}
+}
+
+// newQUICCertificate returns a self-signed certificate for localhost to
+// serve QUIC connections with.
+func newQUICCertificate(t *testing.T) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	cert, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{cert}, PrivateKey: key}
+}
+
+func TestTestRHP4QuicALPNMismatch(t *testing.T) {
+	// the listener only offers HTTP/3, like a proxy that does not pass
+	// the RHP4 protocol through to hostd
+	l, err := quicgo.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{newQUICCertificate(t)},
+		NextProtos:   []string{"h3"},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	p := scanParams{
+		scanConfig: scanConfig{retry: retryPolicy{Attempts: 1}, handshakeTimeout: 5 * time.Second},
+		hostKey:    types.GeneratePrivateKey().PublicKey(),
+	}
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	var res RHP4Result
+	testRHP4Quic(context.Background(), p, chain.NetAddress{Protocol: quic.Protocol, Address: "localhost:" + port}, l.Addr().String(), &res)
+	if res.Handshake {
+		t.Fatal("expected the handshake to fail")
+	} else if !res.PortOpen {
+		t.Fatal("expected the port to be reported open")
+	} else if !hasIssue(res.Errors, "did not accept the \"sia/rhp4\" application protocol (ALPN)") {
+		t.Fatalf("expected ALPN error, got %v", res.Errors)
+	}
+}
+
+// A staticCertificate serves the same certificate to every client.
+type staticCertificate tls.Certificate
+
+func (sc *staticCertificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return (*tls.Certificate)(sc), nil
+}
+
+func TestRPCSettings(t *testing.T) {
+	cert := staticCertificate(newQUICCertificate(t))
+	dial := func(addr string, hostKey types.PublicKey) rhp4.TransportClient {
+		t.Helper()
+		client, err := quic.Dial(context.Background(), addr, hostKey, quic.WithTLSConfig(func(tc *tls.Config) {
+			tc.InsecureSkipVerify = true
+		}))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { client.Close() })
+		return client
+	}
+
+	// a host serving RHP4 responds with its settings
+	hostKey := types.GeneratePrivateKey()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := quic.Listen(conn, &cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go quic.Serve(l, rhp4.NewServer(hostKey, stubChain{}, nil, nil, stubSettings(testHostSettings("hostd v2.1.0")), nil))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client := dial(l.Addr().String(), hostKey.PublicKey())
+	if settings, responded, err := rpcSettings(ctx, client); err != nil {
+		t.Fatal(err)
+	} else if !responded || settings.Release != "hostd v2.1.0" {
+		t.Fatalf("expected the host's settings, got %+v (responded %t)", settings, responded)
+	} else if _, err := rhp4.RPCSettings(ctx, client); err != nil {
+		t.Fatalf("expected the transport to remain usable, got %v", err)
+	}
+
+	// a listener that completes the handshake but never accepts streams,
+	// like a proxy terminating QUIC without forwarding it to hostd
+	dead, err := quicgo.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tls.Certificate(cert)},
+		NextProtos:   []string{quic.TLSNextProtoRHP4},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { dead.Close() })
+	go func() {
+		for {
+			if _, err := dead.Accept(context.Background()); err != nil {
+				return
+			}
+		}
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	if _, responded, err := rpcSettings(ctx, dial(dead.Addr().String(), hostKey.PublicKey())); responded || err == nil {
+		t.Fatalf("expected no response, got %v (responded %t)", err, responded)
+	}
+
+	// a host that responds with an invalid response has still responded
+	invalid, err := quicgo.ListenAddr("127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{tls.Certificate(cert)},
+		NextProtos:   []string{quic.TLSNextProtoRHP4},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { invalid.Close() })
+	go func() {
+		for {
+			conn, err := invalid.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				s, err := conn.AcceptStream(context.Background())
+				if err != nil {
+					return
+				}
+				s.Write([]byte("not an RPC response"))
+				s.Close()
+			}()
+		}
+	}()
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, responded, err := rpcSettings(ctx, dial(invalid.Addr().String(), hostKey.PublicKey())); !responded || err == nil {
+		t.Fatalf("expected an invalid response, got %v (responded %t)", err, responded)
+	}
+}
+
+func TestCheckAnnouncement(t *testing.T) {
+	announced := []chain.NetAddress{
+		{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"},
+		{Protocol: "SiaMux", Address: "siamux://backup.sia.tech:9984"},
+	}
+
+	tests := []struct {
+		name    string
+		addr    chain.NetAddress
+		warning string
+	}{
+		{"matching", chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, ""},
+		{"matching case", chain.NetAddress{Protocol: siamux.Protocol, Address: "HOST.sia.tech:9984"}, ""},
+		{"matching normalized", chain.NetAddress{Protocol: siamux.Protocol, Address: "backup.sia.tech:9984"}, ""},
+		{"different address", chain.NetAddress{Protocol: siamux.Protocol, Address: "other.sia.tech:9984"}, `address "other.sia.tech:9984" does not match the host's announced "siamux" addresses ["host.sia.tech:9984" "backup.sia.tech:9984"]`},
+		{"different port", chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9982"}, `address "host.sia.tech:9982" does not match`},
+		{"not announced", chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech:9984"}, `host has not announced a "quic" address on-chain`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res := RHP4Result{NetAddress: test.addr}
+			checkAnnouncement(announced, &res)
+			if test.warning == "" && len(res.Warnings) != 0 {
+				t.Fatalf("expected no warnings, got %v", res.Warnings)
+			} else if test.warning != "" && !hasIssue(res.Warnings, test.warning) {
+				t.Fatalf("expected warning %q, got %v", test.warning, res.Warnings)
+			} else if len(res.Warnings) > 1 {
+				t.Fatalf("expected at most one warning, got %v", res.Warnings)
+			}
+		})
+	}
+}
+
+func TestCheckTransports(t *testing.T) {
+	result := func(proto chain.Protocol, ok bool) RHP4Result {
+		return RHP4Result{
+			NetAddress: chain.NetAddress{Protocol: proto, Address: "host.sia.tech:9984"},
+			Connected:  ok,
+			Handshake:  ok,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		results  []RHP4Result
+		expected string
+	}{
+		{"both reachable", []RHP4Result{result(siamux.Protocol, true), result(quic.Protocol, true)}, ""},
+		{"neither reachable", []RHP4Result{result(siamux.Protocol, false), result(quic.Protocol, false)}, ""},
+		{"siamux only", []RHP4Result{result(siamux.Protocol, true)}, ""},
+		{"quic only", []RHP4Result{result(quic.Protocol, false)}, ""},
+		{"quic unreachable", []RHP4Result{result(siamux.Protocol, true), result(quic.Protocol, false)}, "reachable over siamux but not quic"},
+		{"siamux unreachable", []RHP4Result{result(siamux.Protocol, false), result(quic.Protocol, true)}, "reachable over quic but not siamux"},
+		{"quic skipped", []RHP4Result{result(siamux.Protocol, true), {NetAddress: chain.NetAddress{Protocol: quic.Protocol}, Skipped: true}}, ""},
+		{"quic streams unanswered", []RHP4Result{result(siamux.Protocol, true), {NetAddress: chain.NetAddress{Protocol: quic.Protocol}, Handshake: true}}, "reachable over siamux but not quic"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			warnings := checkTransports(test.results)
+			if test.expected == "" {
+				if len(warnings) != 0 {
+					t.Fatalf("expected no warnings, got %v", warnings)
+				}
+			} else if !hasIssue(warnings, test.expected) {
+				t.Fatalf("expected warning %q, got %v", test.expected, warnings)
+			}
+		})
+	}
+}
+
+func TestCorrelateTransports(t *testing.T) {
+	result := func(proto chain.Protocol, addr string, ok bool, failure dialFailure, ips ...string) RHP4Result {
+		return RHP4Result{
+			NetAddress:        chain.NetAddress{Protocol: proto, Address: addr},
+			ResolvedAddresses: ips,
+			Connected:         ok,
+			Handshake:         ok,
+			dialFailure:       failure,
+		}
+	}
+
+	tests := []struct {
+		name    string
+		results []RHP4Result
+		noted   bool
+	}{
+		{"siamux reachable", []RHP4Result{result(siamux.Protocol, "host.sia.tech:9984", true, 0), result(quic.Protocol, "host.sia.tech:9984", false, dialTimedOut)}, true},
+		{"same IP", []RHP4Result{result(siamux.Protocol, "host.sia.tech:9984", true, 0, "203.0.113.10"), result(quic.Protocol, "quic.sia.tech:9984", false, dialTimedOut, "203.0.113.10")}, true},
+		{"siamux unreachable", []RHP4Result{result(siamux.Protocol, "host.sia.tech:9984", false, dialRefused), result(quic.Protocol, "host.sia.tech:9984", false, dialTimedOut)}, false},
+		{"different host", []RHP4Result{result(siamux.Protocol, "host.sia.tech:9984", true, 0, "203.0.113.10"), result(quic.Protocol, "quic.sia.tech:9984", false, dialTimedOut, "203.0.113.11")}, false},
+		{"quic responded", []RHP4Result{result(siamux.Protocol, "host.sia.tech:9984", true, 0), result(quic.Protocol, "host.sia.tech:9984", false, 0)}, false},
+		{"quic reachable", []RHP4Result{result(siamux.Protocol, "host.sia.tech:9984", true, 0), result(quic.Protocol, "host.sia.tech:9984", true, 0)}, false},
+		{"siamux skipped", []RHP4Result{{NetAddress: chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"}, Skipped: true, Connected: true, Handshake: true}, result(quic.Protocol, "host.sia.tech:9984", false, dialTimedOut)}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			correlateTransports(test.results)
+			if noted := hasIssue(test.results[1].Notes, "likely a UDP/firewall issue"); noted != test.noted {
+				t.Fatalf("expected noted %v, got notes %v", test.noted, test.results[1].Notes)
+			} else if len(test.results[0].Notes) != 0 {
+				t.Fatalf("expected no siamux notes, got %v", test.results[0].Notes)
+			}
+		})
+	}
+}
+
+func TestOfferedTransports(t *testing.T) {
+	addr := func(proto chain.Protocol) chain.NetAddress {
+		return chain.NetAddress{Protocol: proto, Address: "host.sia.tech:9984"}
+	}
+
+	tests := []struct {
+		name    string
+		addrs   []chain.NetAddress
+		offered []chain.Protocol
+		missing []chain.Protocol
+	}{
+		{"siamux only", []chain.NetAddress{addr(siamux.Protocol)}, []chain.Protocol{siamux.Protocol}, []chain.Protocol{quic.Protocol}},
+		{"quic only", []chain.NetAddress{addr(quic.Protocol), addr(quic.Protocol)}, []chain.Protocol{quic.Protocol}, []chain.Protocol{siamux.Protocol}},
+		{"both", []chain.NetAddress{addr(quic.Protocol), addr(siamux.Protocol)}, []chain.Protocol{siamux.Protocol, quic.Protocol}, nil},
+		{"non-canonical", []chain.NetAddress{addr("SiaMux"), addr(quic.Protocol)}, []chain.Protocol{siamux.Protocol, quic.Protocol}, nil},
+		{"unknown", []chain.NetAddress{addr("rhp3")}, []chain.Protocol{}, []chain.Protocol{siamux.Protocol, quic.Protocol}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			offered, missing := offeredTransports(test.addrs)
+			if !slices.Equal(offered, test.offered) {
+				t.Fatalf("expected offered %v, got %v", test.offered, offered)
+			} else if !slices.Equal(missing, test.missing) {
+				t.Fatalf("expected missing %v, got %v", test.missing, missing)
+			}
+		})
+	}
+}
+
+func TestDiagnoseUnreachable(t *testing.T) {
+	result := func(proto chain.Protocol, address string, failure dialFailure, resolved ...string) RHP4Result {
+		return RHP4Result{
+			NetAddress:        chain.NetAddress{Protocol: proto, Address: address},
+			ResolvedAddresses: resolved,
+			dialFailure:       failure,
+		}
+	}
+	const addr = "host.sia.tech:9984"
+	ips := []string{"203.0.113.10", "203.0.113.11", "2001:db8::10"}
+
+	tests := []struct {
+		name     string
+		results  []RHP4Result
+		expected string
+	}{
+		{"all refused", []RHP4Result{result(siamux.Protocol, addr, dialRefused, ips...)}, "every address refused the connection"},
+		{"all timed out", []RHP4Result{result(siamux.Protocol, addr, dialTimedOut, ips...)}, "connections to every address timed out"},
+		{"single address refused", []RHP4Result{result(siamux.Protocol, addr, dialRefused, ips[0])}, "the address refused the connection"},
+		{"refused and quic timed out", []RHP4Result{result(siamux.Protocol, addr, dialRefused, ips...), result(quic.Protocol, addr, dialTimedOut, ips...)}, "every address refused the connection"},
+		{"timed out and quic timed out", []RHP4Result{result(siamux.Protocol, addr, dialTimedOut, ips...), result(quic.Protocol, addr, dialTimedOut, ips...)}, "connections to every address timed out"},
+		{"quic reachable", []RHP4Result{result(siamux.Protocol, addr, dialRefused, ips...), result(quic.Protocol, addr, 0, ips...)}, ""},
+		{"refused and timed out", []RHP4Result{result(siamux.Protocol, addr, dialRefused, ips...), result(siamux.Protocol, "other.sia.tech:9984", dialTimedOut, ips...)}, ""},
+		{"handshake failed", []RHP4Result{result(siamux.Protocol, addr, 0, ips...)}, ""},
+		{"not resolved", []RHP4Result{result(siamux.Protocol, addr, dialRefused)}, ""},
+		{"ip address", []RHP4Result{result(siamux.Protocol, "203.0.113.10:9984", dialRefused, ips[0])}, ""},
+		{"quic only", []RHP4Result{result(quic.Protocol, addr, dialTimedOut, ips...)}, ""},
+		{"skipped", []RHP4Result{result(siamux.Protocol, addr, dialRefused, ips...), {NetAddress: chain.NetAddress{Protocol: quic.Protocol}, Skipped: true}}, "every address refused the connection"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			diagnosis := diagnoseUnreachable(test.results)
+			if test.expected == "" && diagnosis != "" {
+				t.Fatalf("expected no diagnosis, got %q", diagnosis)
+			} else if !strings.Contains(diagnosis, test.expected) {
+				t.Fatalf("expected diagnosis containing %q, got %q", test.expected, diagnosis)
+			}
+		})
+	}
+}
+
+func TestCheckHardfork(t *testing.T) {
+	n, _ := chain.Mainnet()
+	require := n.HardforkV2.RequireHeight
+	unreachable := []RHP4Result{{NetAddress: chain.NetAddress{Protocol: siamux.Protocol, Address: "host.example:9984"}}}
+	reachable := []RHP4Result{{NetAddress: chain.NetAddress{Protocol: siamux.Protocol, Address: "host.example:9984"}, Scanned: true}}
+
+	tests := []struct {
+		name    string
+		height  uint64
+		results []RHP4Result
+		warn    bool
+	}{
+		{"far from hardfork", require - 2000, unreachable, false},
+		{"near hardfork", require - 100, unreachable, true},
+		{"near hardfork reachable", require - 100, reachable, false},
+		{"at window", require - 1008, unreachable, true},
+		{"after hardfork", require, unreachable, false},
+		{"near hardfork skipped", require - 100, append(unreachable, RHP4Result{Skipped: true}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cs := consensus.State{Network: n, Index: types.ChainIndex{Height: tt.height}}
+			warnings := checkHardfork(cs, 1008, tt.results)
+			if tt.warn && !hasIssue(warnings, "the v2 hardfork requires RHP4") {
+				t.Fatalf("expected hardfork warning, got %v", warnings)
+			} else if !tt.warn && len(warnings) != 0 {
+				t.Fatalf("expected no warnings, got %v", warnings)
+			}
+		})
+	}
+
+	// the warning can be disabled
+	cs := consensus.State{Network: n, Index: types.ChainIndex{Height: require - 100}}
+	if warnings := checkHardfork(cs, 0, unreachable); len(warnings) != 0 {
+		t.Fatalf("expected no warnings, got %v", warnings)
+	}
+}
+
+// stallingSettings blocks settings requests until the test finishes.
+type stallingSettings chan struct{}
+
+func (s stallingSettings) RHP4Settings() proto4.HostSettings {
+	<-s
+	return proto4.HostSettings{}
+}
+
+// newStallingListener returns the address of a listener that accepts
+// connections but never responds.
+func newStallingListener(t *testing.T) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		var conns []net.Conn
+		defer func() {
+			for _, conn := range conns {
+				conn.Close()
+			}
+		}()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			conns = append(conns, conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func TestStepTimeouts(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	p := scanParams{
+		scanConfig: scanConfig{
+			retry:            retryPolicy{Attempts: 1},
+			dialTimeout:      time.Second,
+			handshakeTimeout: 200 * time.Millisecond,
+			settingsTimeout:  200 * time.Millisecond,
+		},
+		hostKey: hostKey.PublicKey(),
+	}
+
+	t.Run("handshake", func(t *testing.T) {
+		addr := newStallingListener(t)
+
+		var res RHP4Result
+		testRHP4SiaMux(context.Background(), p, addr, &res)
+		if !res.Connected || res.Handshake {
+			t.Fatalf("expected the connection to stall during the handshake, got %+v", res)
+		} else if res.TimedOut {
+			t.Fatal("expected the step timeout not to be reported as the server's time limit")
+		} else if !hasIssue(res.Errors, "siamux handshake timed out after 200ms") {
+			t.Fatalf("expected handshake timeout, got %v", res.Errors)
+		}
+	})
+
+	t.Run("settings", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer l.Close()
+		stall := make(stallingSettings)
+		defer close(stall)
+		go siamux.Serve(l, rhp4.NewServer(hostKey, stubChain{}, nil, nil, stall, nil), zap.NewNop())
+
+		var res RHP4Result
+		testRHP4SiaMux(context.Background(), p, l.Addr().String(), &res)
+		if !res.Handshake || res.Scanned {
+			t.Fatalf("expected the settings scan to stall after the handshake, got %+v", res)
+		} else if res.TimedOut {
+			t.Fatal("expected the step timeout not to be reported as the server's time limit")
+		} else if !hasIssue(res.Errors, "settings scan timed out after 200ms") {
+			t.Fatalf("expected settings timeout, got %v", res.Errors)
+		}
+	})
+
+	t.Run("deadline", func(t *testing.T) {
+		// the request's deadline takes precedence over the step timeout
+		addr := newStallingListener(t)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		slow := p
+		slow.handshakeTimeout = time.Minute
+		var res RHP4Result
+		testRHP4SiaMux(ctx, slow, addr, &res)
+		if !res.TimedOut || !hasIssue(res.Errors, "timed out during siamux handshake") {
+			t.Fatalf("expected the server's time limit to be reported, got %v", res.Errors)
+		}
+	})
+}
+
+// failingTransport is an RHP4 transport whose streams fail.
+type failingTransport struct {
+	hostKey types.PublicKey
+}
+
+func (failingTransport) DialStream(context.Context) (net.Conn, error) {
+	return nil, errors.New("stream reset by peer")
+}
+
+func (failingTransport) FrameSize() int              { return 1440 * 3 }
+func (ft failingTransport) PeerKey() types.PublicKey { return ft.hostKey }
+func (failingTransport) Close() error                { return nil }
+
+func TestTestRHP4TransportSettingsError(t *testing.T) {
+	hostKey := types.GeneratePrivateKey().PublicKey()
+	p := scanParams{hostKey: hostKey, scanConfig: scanConfig{settingsTimeout: time.Second}}
+
+	var res RHP4Result
+	testRHP4Transport(context.Background(), failingTransport{hostKey: hostKey}, p, &res)
+	if res.Scanned {
+		t.Fatal("expected a failed settings scan not to be marked scanned")
+	} else if res.Settings != nil || res.Pricing != nil {
+		t.Fatalf("expected no settings or pricing, got %+v and %+v", res.Settings, res.Pricing)
+	} else if !hasIssue(res.Errors, "failed to get settings") || !hasIssue(res.Errors, "stream reset by peer") {
+		t.Fatalf("expected settings error, got %v", res.Errors)
+	} else if len(res.Warnings) != 0 {
+		t.Fatalf("expected no settings warnings, got %v", res.Warnings)
+	}
+}
+
+func TestTestRHP4VersionDrift(t *testing.T) {
+	tests := []struct {
+		release string
+		warning string
+		err     string
+	}{
+		{"hostd v2.1.0", "", ""},
+		{"hostd v2.0.3", "outdated version", ""},
+		{"hostd v1.9.0", "", "a major version behind"},
+	}
+	for _, test := range tests {
+		t.Run(test.release, func(t *testing.T) {
+			hostKey := types.GeneratePrivateKey()
+			addr := newSiaMuxHost(t, hostKey, proto4.HostSettings{Release: test.release})
+
+			p := scanParams{
+				scanConfig:     scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second},
+				hostKey:        hostKey.PublicKey(),
+				currentVersion: SemVer{version: [3]byte{2, 1, 0}},
+			}
+			var res RHP4Result
+			testRHP4SiaMux(context.Background(), p, addr, &res)
+			if !res.Scanned {
+				t.Fatalf("expected the host to be scanned, got %v", res.Errors)
+			}
+			if test.warning == "" && hasIssue(res.Warnings, "version") {
+				t.Fatalf("expected no version warning, got %v", res.Warnings)
+			} else if test.warning != "" && !hasIssue(res.Warnings, test.warning) {
+				t.Fatalf("expected warning containing %q, got %v", test.warning, res.Warnings)
+			} else if test.err == "" && hasIssue(res.Errors, "version") {
+				t.Fatalf("expected no version error, got %v", res.Errors)
+			} else if test.err != "" && !hasIssue(res.Errors, test.err) {
+				t.Fatalf("expected error containing %q, got %v", test.err, res.Errors)
+			}
+		})
+	}
+}
+
+// countingSettings counts the settings requests it serves.
+type countingSettings struct {
+	calls atomic.Int32
+}
+
+func (s *countingSettings) RHP4Settings() proto4.HostSettings {
+	s.calls.Add(1)
+	return proto4.HostSettings{}
+}
+
+func TestQuickMode(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	settings := new(countingSettings)
+	go siamux.Serve(l, rhp4.NewServer(hostKey, stubChain{}, nil, nil, settings, nil), zap.NewNop())
+
+	m := &Manager{
+		tg:       threadgroup.New(),
+		log:      zap.NewNop(),
+		explorer: mockExplorer{},
+		scanSem:  make(chan struct{}, defaultMaxConcurrentScans),
+		cooldown: make(map[types.PublicKey]time.Time),
+		cfg:      scanConfig{resolvers: defaultResolvers(), retry: retryPolicy{Attempts: 1}},
+	}
+	host := Host{
+		PublicKey:        hostKey.PublicKey(),
+		RHP4NetAddresses: []chain.NetAddress{{Protocol: siamux.Protocol, Address: l.Addr().String()}},
+		Quick:            true,
+	}
+
+	result, err := m.TestHost(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	} else if !result.Quick {
+		t.Fatal("expected a quick result")
+	} else if n := settings.calls.Load(); n != 0 {
+		t.Fatalf("expected no settings requests, got %d", n)
+	} else if len(result.Errors) != 0 {
+		t.Fatalf("expected no errors, got %v", result.Errors)
+	}
+	res := result.RHP4[0]
+	switch {
+	case !res.Quick || !res.Connected || !res.Handshake:
+		t.Fatalf("expected a completed handshake, got %+v", res)
+	case res.Scanned || res.Settings != nil || res.Pricing != nil:
+		t.Fatalf("expected settings not to be scanned, got %+v", res)
+	case !res.reached() || result.reachable(siamux.Protocol) != "true":
+		t.Fatal("expected the address to be reachable")
+	}
+	if status, _ := res.status(); status == "FAIL" {
+		t.Fatal("expected the address not to fail")
+	}
+
+	// a full test scans the settings
+	host.Quick = false
+	if result, err := m.TestHost(context.Background(), host); err != nil {
+		t.Fatal(err)
+	} else if n := settings.calls.Load(); n != 1 {
+		t.Fatalf("expected 1 settings request, got %d", n)
+	} else if res := result.RHP4[0]; res.Quick || !res.Scanned {
+		t.Fatalf("expected a full scan, got %+v", res)
+	}
+}
@@ -10,14 +10,19 @@ import (
 type SemVer struct {
 	version [3]byte
 	suffix  string
+	build   string
 }
 
 // String returns the string representation of the semantic version.
 func (v SemVer) String() string {
+	s := fmt.Sprintf("v%d.%d.%d", v.version[0], v.version[1], v.version[2])
 	if v.suffix != "" {
-		return fmt.Sprintf("v%d.%d.%d-%s", v.version[0], v.version[1], v.version[2], v.suffix)
+		s += "-" + v.suffix
 	}
-	return fmt.Sprintf("v%d.%d.%d", v.version[0], v.version[1], v.version[2])
+	if v.build != "" {
+		s += "+" + v.build
+	}
+	return s
 }
 
 // Suffix returns the suffix of the semantic version.
@@ -25,6 +30,12 @@ func (v SemVer) Suffix() string {
 	return v.suffix
 }
 
+// Build returns the build metadata of the semantic version. Build metadata
+// is ignored when comparing versions.
+func (v SemVer) Build() string {
+	return v.build
+}
+
 // Cmp compares two semantic versions.
 // Returns -1 if a < b, 0 if a == b, 1 if a > b
 func (v SemVer) Cmp(b SemVer) int {
@@ -47,18 +58,47 @@ func (v SemVer) Cmp(b SemVer) int {
 	}
 }
 
-// UnmarshalText implements encoding.TextUnmarshaler
+// A VersionDrift describes how far a version is behind the latest release.
+type VersionDrift string
+
+// Version drifts, from least to most urgent.
+const (
+	VersionUpToDate    VersionDrift = "upToDate"
+	VersionPatchBehind VersionDrift = "patchBehind"
+	VersionMinorBehind VersionDrift = "minorBehind"
+	VersionMajorBehind VersionDrift = "majorBehind"
+)
+
+// Drift classifies how far v is behind latest. Versions equal to or ahead
+// of latest are up to date. A pre-release of latest is a patch behind.
+func (v SemVer) Drift(latest SemVer) VersionDrift {
+	switch {
+	case v.Cmp(latest) >= 0:
+		return VersionUpToDate
+	case v.version[0] != latest.version[0]:
+		return VersionMajorBehind
+	case v.version[1] != latest.version[1]:
+		return VersionMinorBehind
+	default:
+		return VersionPatchBehind
+	}
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler. The leading "v" is
+// optional and missing minor or patch versions are treated as zero, so
+// "1.2" is parsed as "v1.2.0".
 func (v *SemVer) UnmarshalText(buf []byte) error {
 	if len(buf) == 0 {
 		return fmt.Errorf("empty version string")
 	}
-	version := string(buf)
-	if version[0] != 'v' {
-		return fmt.Errorf("invalid version format: %s", version)
-	}
 
-	var suffix string
-	version = version[1:] // Remove the leading 'v'
+	var suffix, build string
+	version := strings.TrimPrefix(string(buf), "v")
+	if buildPos := strings.Index(version, "+"); buildPos >= 0 {
+		// remove optional build metadata
+		build = version[buildPos+1:]
+		version = version[:buildPos]
+	}
 	if suffixPos := strings.Index(version, "-"); suffixPos >= 0 {
 		// remove optional suffix
 		suffix = strings.ToLower(version[suffixPos+1:])
@@ -66,25 +106,23 @@ func (v *SemVer) UnmarshalText(buf []byte) error {
 	}
 
 	parts := strings.Split(version, ".")
-	if len(parts) != 3 {
+	if len(parts) > 3 {
 		return fmt.Errorf("invalid version format: %s", version)
 	}
-	major, err := strconv.ParseUint(parts[0], 10, 8)
-	if err != nil {
-		return fmt.Errorf("invalid major version: %s", parts[0])
-	}
-
-	minor, err := strconv.ParseUint(parts[1], 10, 8)
-	if err != nil {
-		return fmt.Errorf("invalid minor version: %s", parts[1])
-	}
-
-	patch, err := strconv.ParseUint(parts[2], 10, 8)
-	if err != nil {
-		return fmt.Errorf("invalid patch version: %s", parts[2])
+	var components [3]byte
+	for i, name := range []string{"major", "minor", "patch"} {
+		if i >= len(parts) {
+			break
+		}
+		n, err := strconv.ParseUint(parts[i], 10, 8)
+		if err != nil {
+			return fmt.Errorf("invalid %s version: %s", name, parts[i])
+		}
+		components[i] = byte(n)
 	}
-	v.version = [3]byte{byte(major), byte(minor), byte(patch)}
+	v.version = components
 	v.suffix = suffix
+	v.build = build
 	return nil
 }
 
@@ -111,6 +149,7 @@ func cmpSuffix(a, b string) int {
 	suffixWeights := map[string]int{
 		"alpha": 1,
 		"beta":  2,
+		"rc":    3,
 	}
 
 	splitSuffix := func(s string) (w, n int) {
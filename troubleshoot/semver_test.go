@@ -21,6 +21,17 @@ func TestSemver(t *testing.T) {
 		{"v1.2.3-alpha.1", "v1.2.3-rc1", 1},
 		{"v1.2.3-alpha.a", "v1.2.3-alpha.1", -1},
 		{"v1.2.3-beta.a", "v1.2.3-alpha.1", 1},
+		{"v1.2.3+abcdef", "v1.2.3", 0},               // build metadata is ignored
+		{"v1.2.3+abcdef", "v1.2.3+123456", 0},        // build metadata is ignored
+		{"v1.2.3-beta.1+abcdef", "v1.2.3-beta.1", 0}, // build metadata is ignored
+		{"v1.2.3-beta.1+abcdef", "v1.2.3-beta.2", -1},
+		{"v1.2.3-rc.1+20250101.abcdef", "v1.2.3", -1},
+		{"v1.2.3-rc.1", "v1.2.3-beta.2", 1},
+		{"v1.2.3-rc.2", "v1.2.3-rc.1", 1},
+		// hosts ahead of a pre-release latest are not outdated
+		{"v1.2.3", "v1.2.3-rc.1", 1},
+		{"v1.2.4", "v1.2.4-rc.1", 1},
+		{"v1.3.0-beta.1", "v1.2.4", 1},
 	}
 
 	for _, test := range tests {
@@ -38,3 +49,101 @@ func TestSemver(t *testing.T) {
 		}
 	}
 }
+
+func TestSemverBuild(t *testing.T) {
+	tests := []struct {
+		version string
+		suffix  string
+		build   string
+	}{
+		{"v1.2.3", "", ""},
+		{"v1.2.3-beta.1", "beta.1", ""},
+		{"v1.2.3+abcdef", "", "abcdef"},
+		{"v1.2.3-rc.1+20250101.abcdef", "rc.1", "20250101.abcdef"},
+		{"v1.2.3-rc.1+build-1", "rc.1", "build-1"},
+	}
+
+	for _, test := range tests {
+		var v SemVer
+		if err := v.UnmarshalText([]byte(test.version)); err != nil {
+			t.Fatalf("failed to parse version %q: %v", test.version, err)
+		} else if v.Suffix() != test.suffix {
+			t.Errorf("expected suffix %q for %q, got %q", test.suffix, test.version, v.Suffix())
+		} else if v.Build() != test.build {
+			t.Errorf("expected build %q for %q, got %q", test.build, test.version, v.Build())
+		} else if v.String() != test.version {
+			t.Errorf("expected %q to round trip, got %q", test.version, v.String())
+		}
+	}
+}
+
+func TestSemverParse(t *testing.T) {
+	tests := []struct {
+		version  string
+		expected string
+		err      bool
+	}{
+		{"v1.2.3", "v1.2.3", false},
+		{"1.2.3", "v1.2.3", false},
+		{"v1.2", "v1.2.0", false},
+		{"1.2", "v1.2.0", false},
+		{"v2", "v2.0.0", false},
+		{"2", "v2.0.0", false},
+		{"1.2-beta.1", "v1.2.0-beta.1", false},
+		{"2+abcdef", "v2.0.0+abcdef", false},
+		{"", "", true},
+		{"v", "", true},
+		{"1.", "", true},
+		{"1..3", "", true},
+		{"1.2.3.4", "", true},
+		{"v1.x.3", "", true},
+		{"1.2.256", "", true},
+		{"hostd", "", true},
+	}
+
+	for _, test := range tests {
+		var v SemVer
+		err := v.UnmarshalText([]byte(test.version))
+		if test.err {
+			if err == nil {
+				t.Errorf("expected %q to be invalid, got %q", test.version, v)
+			}
+			continue
+		} else if err != nil {
+			t.Errorf("failed to parse version %q: %v", test.version, err)
+		} else if v.String() != test.expected {
+			t.Errorf("expected %q to parse as %q, got %q", test.version, test.expected, v)
+		}
+	}
+}
+
+func TestSemverDrift(t *testing.T) {
+	tests := []struct {
+		version  string
+		latest   string
+		expected VersionDrift
+	}{
+		{"v2.1.0", "v2.1.0", VersionUpToDate},
+		{"v2.2.0", "v2.1.0", VersionUpToDate},
+		{"v2.1.0", "v2.1.0-rc.1", VersionUpToDate},
+		{"v2.1.0+abcdef", "v2.1.0", VersionUpToDate},
+		{"v2.1.0-rc.1", "v2.1.0", VersionPatchBehind},
+		{"v2.1.0", "v2.1.3", VersionPatchBehind},
+		{"v2.0.5", "v2.1.0", VersionMinorBehind},
+		{"v2.0.0", "v2.3.1", VersionMinorBehind},
+		{"v1.9.9", "v2.0.0", VersionMajorBehind},
+		{"v1.1.0", "v2.1.0", VersionMajorBehind},
+	}
+
+	for _, test := range tests {
+		var v, latest SemVer
+		if err := v.UnmarshalText([]byte(test.version)); err != nil {
+			t.Fatalf("failed to parse version %q: %v", test.version, err)
+		} else if err := latest.UnmarshalText([]byte(test.latest)); err != nil {
+			t.Fatalf("failed to parse version %q: %v", test.latest, err)
+		}
+		if drift := v.Drift(latest); drift != test.expected {
+			t.Errorf("expected %q behind %q to be %q, got %q", test.version, test.latest, test.expected, drift)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package troubleshoot
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	rhp4 "go.sia.tech/coreutils/rhp/v4"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+	"go.sia.tech/troubleshootd/internal/dns"
+)
+
+// HostSettings are the unvalidated settings of a host and the address they
+// were fetched from.
+type HostSettings struct {
+	NetAddress chain.NetAddress    `json:"netAddress"`
+	Settings   proto4.HostSettings `json:"settings"`
+}
+
+// fetchSettings connects to the address and returns the host's settings.
+func fetchSettings(ctx context.Context, cfg scanConfig, hostKey types.PublicKey, netAddr chain.NetAddress, overrideIP net.IP) (proto4.HostSettings, error) {
+	if cfg.defaultPorts {
+		netAddr, _ = withDefaultPort(netAddr)
+	}
+	dialAddr := netAddr.Address
+	if overrideIP != nil {
+		_, port, err := net.SplitHostPort(netAddr.Address)
+		if err != nil {
+			return proto4.HostSettings{}, fmt.Errorf("failed to parse net address %q: %w", netAddr.Address, err)
+		}
+		dialAddr = net.JoinHostPort(overrideIP.String(), port)
+	}
+	if cfg.restrictsNetworks() {
+		ips := []net.IP{overrideIP}
+		if overrideIP == nil {
+			hostname, port, err := net.SplitHostPort(netAddr.Address)
+			if err != nil {
+				return proto4.HostSettings{}, fmt.Errorf("failed to parse net address %q: %w", netAddr.Address, err)
+			}
+			lookup, _, err := lookupIPs(ctx, cfg, AddressFamilyAny, hostname)
+			if err != nil {
+				return proto4.HostSettings{}, fmt.Errorf("failed to resolve host %q: %w", hostname, err)
+			}
+			ips = lookup.ips
+			if (netAddr.Protocol == quic.Protocol || cfg.proxy != nil) && dns.ParseIP(hostname) == nil {
+				// QUIC and proxies resolve the hostname again when
+				// dialing
+				dialAddr = checkedDialAddress(ips, port)
+			}
+		}
+		if err := checkNetworks(cfg, ips); err != nil {
+			return proto4.HostSettings{}, fmt.Errorf("refusing to connect: %w", err)
+		}
+	}
+
+	var t rhp4.TransportClient
+	switch netAddr.Protocol {
+	case siamux.Protocol:
+		conn, _, err := dialContext(ctx, cfg, "tcp", dialAddr)
+		if err != nil {
+			return proto4.HostSettings{}, err
+		}
+		defer conn.Close()
+		t, err = siamux.Upgrade(ctx, conn, hostKey)
+		if err != nil {
+			return proto4.HostSettings{}, fmt.Errorf("failed to connect to siamux: %w", err)
+		}
+	case quic.Protocol:
+		hostname, _, err := net.SplitHostPort(netAddr.Address)
+		if err != nil {
+			return proto4.HostSettings{}, fmt.Errorf("failed to parse net address %q: %w", netAddr.Address, err)
+		}
+		_, err = cfg.retry.do(ctx, func() (err error) {
+			t, err = quic.Dial(ctx, dialAddr, hostKey, quic.WithTLSConfig(func(tc *tls.Config) {
+				tc.ServerName = hostname
+			}))
+			return err
+		})
+		if err != nil {
+			return proto4.HostSettings{}, fmt.Errorf("failed to connect to quic: %w", err)
+		}
+	default:
+		return proto4.HostSettings{}, fmt.Errorf("unknown protocol %q", netAddr.Protocol)
+	}
+	defer t.Close()
+
+	settings, err := rhp4.RPCSettings(ctx, t)
+	if err != nil {
+		return proto4.HostSettings{}, fmt.Errorf("failed to get settings: %w", err)
+	}
+	return settings, nil
+}
+
+// FetchSettings returns the host's settings from the first of its addresses
+// that responds. Unlike TestHost, the settings are not validated and the
+// host's cooldown is not affected.
+func (m *Manager) FetchSettings(ctx context.Context, host Host) (HostSettings, error) {
+	ctx, cancel, err := m.tg.AddContext(ctx)
+	if err != nil {
+		return HostSettings{}, err
+	}
+	defer cancel()
+
+	var overrideIP net.IP
+	if host.OverrideAddress != "" {
+		overrideIP = net.ParseIP(strings.Trim(host.OverrideAddress, "[]"))
+		if overrideIP == nil {
+			return HostSettings{}, fmt.Errorf("override address %q is not a valid IP address", host.OverrideAddress)
+		}
+	}
+
+	protocols, err := requestedProtocols(host.Protocols)
+	if err != nil {
+		return HostSettings{}, err
+	}
+
+	release, err := m.acquireScan(ctx)
+	if err != nil {
+		return HostSettings{}, err
+	}
+	defer release()
+
+	host, _ = m.limitAddresses(host)
+
+	var errs []error
+	for _, addr := range host.RHP4NetAddresses {
+		addr, _ := normalizeAddress(addr)
+		if m.cfg.disabledProtocols[addr.Protocol] || (protocols != nil && !protocols[addr.Protocol]) {
+			continue
+		}
+		settings, err := fetchSettings(ctx, m.cfg, host.PublicKey, addr, overrideIP)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s %s: %w", addr.Protocol, addr.Address, err))
+			continue
+		}
+		return HostSettings{NetAddress: addr, Settings: settings}, nil
+	}
+	if len(errs) == 0 {
+		return HostSettings{}, errors.New("host has no addresses to fetch settings from")
+	}
+	return HostSettings{}, errors.Join(errs...)
+}
@@ -0,0 +1,108 @@
+package troubleshoot
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	proto4 "go.sia.tech/core/rhp/v4"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	rhp4 "go.sia.tech/coreutils/rhp/v4"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+	"go.sia.tech/coreutils/threadgroup"
+	"go.uber.org/zap"
+)
+
+// stubChain implements the chain methods used by the settings RPC.
+type stubChain struct {
+	rhp4.ChainManager
+}
+
+func (stubChain) Tip() types.ChainIndex { return types.ChainIndex{Height: 100} }
+
+type stubSettings proto4.HostSettings
+
+func (s stubSettings) RHP4Settings() proto4.HostSettings { return proto4.HostSettings(s) }
+
+// newSiaMuxHost starts an RHP4 host that only serves its settings. It
+// returns the host's address.
+func newSiaMuxHost(t *testing.T, hostKey types.PrivateKey, settings proto4.HostSettings) string {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	srv := rhp4.NewServer(hostKey, stubChain{}, nil, nil, stubSettings(settings), nil)
+	go siamux.Serve(l, srv, zap.NewNop())
+	return l.Addr().String()
+}
+
+func TestFetchSettings(t *testing.T) {
+	hostKey := types.GeneratePrivateKey()
+	// settings that would fail validation
+	addr := newSiaMuxHost(t, hostKey, proto4.HostSettings{
+		Release:             "hostd v0.0.1",
+		MaxContractDuration: 1,
+	})
+
+	m := &Manager{
+		tg:       threadgroup.New(),
+		log:      zap.NewNop(),
+		explorer: mockExplorer{},
+		scanSem:  make(chan struct{}, defaultMaxConcurrentScans),
+		cooldown: make(map[types.PublicKey]time.Time),
+	}
+	WithDialTimeout(time.Second)(m)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// a listener that is closed immediately leaves a port that refuses
+	// connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	host := Host{
+		PublicKey: hostKey.PublicKey(),
+		RHP4NetAddresses: []chain.NetAddress{
+			{Protocol: siamux.Protocol, Address: closedAddr},
+			{Protocol: siamux.Protocol, Address: addr},
+		},
+	}
+	resp, err := m.FetchSettings(ctx, host)
+	if err != nil {
+		t.Fatal(err)
+	} else if resp.NetAddress.Address != addr {
+		t.Fatalf("expected settings from %q, got %q", addr, resp.NetAddress.Address)
+	} else if resp.Settings.Release != "hostd v0.0.1" {
+		t.Fatalf("expected release %q, got %q", "hostd v0.0.1", resp.Settings.Release)
+	} else if resp.Settings.Prices.TipHeight != 100 {
+		t.Fatalf("expected tip height 100, got %d", resp.Settings.Prices.TipHeight)
+	}
+
+	// fetching settings should not put the host on cooldown
+	if _, ok := m.cooldown[hostKey.PublicKey()]; ok {
+		t.Fatal("expected host not to be on cooldown")
+	}
+
+	host.Protocols = []chain.Protocol{quic.Protocol}
+	if _, err := m.FetchSettings(ctx, host); err == nil {
+		t.Fatal("expected error when no addresses match the requested protocols")
+	}
+
+	host.Protocols = nil
+	host.RHP4NetAddresses = host.RHP4NetAddresses[:1]
+	if _, err := m.FetchSettings(ctx, host); err == nil {
+		t.Fatal("expected error when no address responds")
+	}
+}
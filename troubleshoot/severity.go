@@ -0,0 +1,54 @@
+package troubleshoot
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// A Severity describes how serious an issue is.
+type Severity string
+
+// Severities of issues found while testing a host.
+const (
+	// SeverityError is an issue that prevents renters from using the host.
+	SeverityError Severity = "error"
+	// SeverityWarning is an issue that may affect renters but does not
+	// prevent them from using the host.
+	SeverityWarning Severity = "warning"
+	// SeverityInfo is informational and does not need to be fixed.
+	SeverityInfo Severity = "info"
+)
+
+// String implements fmt.Stringer.
+func (s Severity) String() string {
+	return string(s)
+}
+
+// valid returns an error if s is not a known severity.
+func (s Severity) valid() error {
+	switch s {
+	case SeverityError, SeverityWarning, SeverityInfo:
+		return nil
+	}
+	return fmt.Errorf("unknown severity %q", string(s))
+}
+
+// MarshalJSON implements json.Marshaler.
+func (s Severity) MarshalJSON() ([]byte, error) {
+	if err := s.valid(); err != nil {
+		return nil, err
+	}
+	return json.Marshal(string(s))
+}
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (s *Severity) UnmarshalJSON(b []byte) error {
+	var str string
+	if err := json.Unmarshal(b, &str); err != nil {
+		return err
+	} else if err := Severity(str).valid(); err != nil {
+		return err
+	}
+	*s = Severity(str)
+	return nil
+}
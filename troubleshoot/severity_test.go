@@ -0,0 +1,44 @@
+package troubleshoot
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSeverityJSON(t *testing.T) {
+	for _, s := range []Severity{SeverityError, SeverityWarning, SeverityInfo} {
+		buf, err := json.Marshal(s)
+		if err != nil {
+			t.Fatal(err)
+		} else if string(buf) != `"`+s.String()+`"` {
+			t.Fatalf("expected %q, got %s", s, buf)
+		}
+
+		var decoded Severity
+		if err := json.Unmarshal(buf, &decoded); err != nil {
+			t.Fatal(err)
+		} else if decoded != s {
+			t.Fatalf("expected %q, got %q", s, decoded)
+		}
+	}
+
+	for _, invalid := range []string{`"critical"`, `""`, `"Error"`, `1`} {
+		var s Severity
+		if err := json.Unmarshal([]byte(invalid), &s); err == nil {
+			t.Fatalf("expected %s to be rejected, got %q", invalid, s)
+		}
+	}
+	if _, err := json.Marshal(Severity("critical")); err == nil {
+		t.Fatal("expected an unknown severity not to be marshaled")
+	}
+
+	// issues carry their severity
+	var issue Issue
+	if err := json.Unmarshal([]byte(`{"severity":"warning","message":"outdated version"}`), &issue); err != nil {
+		t.Fatal(err)
+	} else if issue.Severity != SeverityWarning {
+		t.Fatalf("expected warning severity, got %q", issue.Severity)
+	} else if err := json.Unmarshal([]byte(`{"severity":"fatal","message":"outdated version"}`), &issue); err == nil {
+		t.Fatal("expected an issue with an unknown severity to be rejected")
+	}
+}
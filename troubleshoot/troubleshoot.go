@@ -2,63 +2,567 @@ package troubleshoot
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.sia.tech/core/consensus"
 	proto4 "go.sia.tech/core/rhp/v4"
 	"go.sia.tech/core/types"
 	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
 	"go.sia.tech/coreutils/threadgroup"
+	"go.sia.tech/explored/explorer"
 	"go.sia.tech/troubleshootd/github"
+	"go.sia.tech/troubleshootd/internal/dns"
+	"go.sia.tech/troubleshootd/internal/rdap"
 	"go.uber.org/zap"
+	"golang.org/x/net/proxy"
 )
 
+const (
+	// defaultMaxConcurrentScans is the default number of address tests that
+	// can run concurrently across all requests.
+	defaultMaxConcurrentScans = 64
+	// defaultMaxAddresses is the default maximum number of RHP4 addresses
+	// tested per request. Hosts usually announce one address per
+	// transport.
+	defaultMaxAddresses = 16
+	// defaultDialTimeout is the default timeout for each TCP dial attempt.
+	// It should be well within the API's request timeout so that
+	// unreachable hosts produce a clear error.
+	defaultDialTimeout = 15 * time.Second
+	// defaultHandshakeTimeout is the default timeout for the transport
+	// handshake. It is longer than the QUIC handshake's idle timeout so
+	// that unreachable UDP ports are still reported as such.
+	defaultHandshakeTimeout = 20 * time.Second
+	// defaultSettingsTimeout is the default timeout for the settings scan.
+	defaultSettingsTimeout = 10 * time.Second
+	// defaultCooldown is the default minimum time between tests of the
+	// same host.
+	defaultCooldown = 15 * time.Second
+	// defaultECSResolver is the default resolver used for client subnet
+	// lookups. Not every public resolver supports the option.
+	defaultECSResolver = "8.8.8.8:53"
+
+	// tip state changes more frequently than the latest release, so it is
+	// polled more often.
+	statePollInterval   = time.Minute
+	releasePollInterval = 15 * time.Minute
+
+	// maxStateAge is the maximum age of the consensus state before it is
+	// considered stale.
+	maxStateAge = 2 * statePollInterval
+)
+
+// fetchLatestRelease fetches the name of the latest hostd release from
+// GitHub. It is a variable so that tests do not depend on GitHub.
+var fetchLatestRelease = func(ctx context.Context, prereleases bool) (string, error) {
+	return github.LatestRelease(ctx, "SiaFoundation", "hostd", prereleases)
+}
+
+// requestIDKey is the context key for the request ID.
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx carrying the ID of the request that
+// started the test. [Manager.TestHost] includes the ID in its logs and
+// result.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// requestID returns the request ID carried by ctx, if any.
+func requestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// ErrUnhealthy is returned by [Manager.Health] when one of the manager's
+// dependencies is degraded.
+var ErrUnhealthy = errors.New("unhealthy")
+
+// A CooldownError is returned by [Manager.TestHost] when the host was
+// tested too recently.
+type CooldownError struct {
+	Remaining time.Duration
+}
+
+// Error implements error.
+func (e *CooldownError) Error() string {
+	return fmt.Sprintf("host is on cooldown, please try again in %s", e.Remaining.Round(time.Second))
+}
+
+// An AddressFamily restricts a test to IPv4 or IPv6 addresses.
+type AddressFamily string
+
+// Address families that a test can be restricted to.
+const (
+	AddressFamilyAny  AddressFamily = ""
+	AddressFamilyIPv4 AddressFamily = "ipv4"
+	AddressFamilyIPv6 AddressFamily = "ipv6"
+)
+
+// network returns the name of the IP network for the family, as accepted
+// by [net.Resolver.LookupIP].
+func (f AddressFamily) network() string {
+	switch f {
+	case AddressFamilyIPv4:
+		return "ip4"
+	case AddressFamilyIPv6:
+		return "ip6"
+	}
+	return "ip"
+}
+
+// contains returns true if ip belongs to the family.
+func (f AddressFamily) contains(ip net.IP) bool {
+	switch f {
+	case AddressFamilyIPv4:
+		return ip.To4() != nil
+	case AddressFamilyIPv6:
+		return ip.To4() == nil
+	}
+	return true
+}
+
 type (
 	// A Host is a host on the Sia network. It contains the public key of the
-	// host, the address of the host's RHP2 endpoint, and a list of addresses for
-	// RHP4.
+	// host and a list of addresses for RHP4.
 	Host struct {
 		PublicKey        types.PublicKey    `json:"publicKey"`
 		RHP4NetAddresses []chain.NetAddress `json:"rhp4NetAddresses"`
+
+		// OverrideAddress is an optional IP address to dial instead of
+		// resolving each address's hostname. The port of each address is
+		// kept and the handshake is still performed against the public key.
+		OverrideAddress string `json:"overrideAddress,omitempty"`
+
+		// Protocols optionally restricts the test to addresses using the
+		// given protocols. If empty, every address is tested.
+		Protocols []chain.Protocol `json:"protocols,omitempty"`
+
+		// AddressFamily optionally restricts resolution and dialing to a
+		// single address family. If empty, both families are used.
+		AddressFamily AddressFamily `json:"addressFamily,omitempty"`
+
+		// DryRun only checks that the host can be tested, without
+		// connecting to it or putting it on cooldown.
+		DryRun bool `json:"dryRun,omitempty"`
+
+		// ExpectedIPs optionally lists the IP addresses each hostname should
+		// resolve to. If set, an error is added when the resolved addresses
+		// are missing an expected IP or include an unexpected one.
+		ExpectedIPs []string `json:"expectedIPs,omitempty"`
+
+		// Quick only checks that each address is reachable, without
+		// validating the host's settings. QUIC addresses still request the
+		// settings to confirm that the host responds after the handshake.
+		Quick bool `json:"quick,omitempty"`
+
+		// Force tests the host again even if a recent result of the same
+		// request is cached. The host's cooldown still applies.
+		Force bool `json:"force,omitempty"`
+
+		// ContractDuration, in blocks, and DataSize, in bytes, optionally
+		// describe the contract the renter intends to form. They are used
+		// to calculate the contract's cost and to warn if the host cannot
+		// form it. If zero, the minimum contract duration and 1 TB are
+		// used.
+		ContractDuration uint64 `json:"contractDuration,omitempty"`
+		DataSize         uint64 `json:"dataSize,omitempty"`
+
+		// SkipVersionCheck does not compare the host's version against
+		// the latest release, for hosts intentionally running a pinned or
+		// custom build. The host's version is still reported.
+		SkipVersionCheck bool `json:"skipVersionCheck,omitempty"`
 	}
 
 	// RHP4Result is the result of testing a host's RHP4 endpoint. It contains
 	// the results of the connection, handshake, and scan, as well as any errors
 	// or warnings that occurred during the test.
 	RHP4Result struct {
-		NetAddress        chain.NetAddress `json:"netAddress"`
-		ResolvedAddresses []string         `json:"resolvedAddresses"`
+		NetAddress chain.NetAddress `json:"netAddress"`
 
-		Connected bool          `json:"connected"`
-		DialTime  time.Duration `json:"dialTime"`
+		// Skipped is true if the address was not tested because its
+		// protocol was not requested.
+		Skipped bool `json:"skipped,omitempty"`
+
+		ResolvedAddresses []string `json:"resolvedAddresses"`
+		ResolveAttempts   int      `json:"resolveAttempts"`
+		// ResolveTime is how long resolving the hostname took, including
+		// retries. It is zero if the address is an IP literal or an
+		// override address was dialed instead.
+		ResolveTime time.Duration `json:"resolveTime"`
+		// Resolver is the DNS resolver that answered first
+		Resolver        string           `json:"resolver,omitempty"`
+		ResolverAnswers []ResolverAnswer `json:"resolverAnswers,omitempty"`
+		// CNAMEChain is the chain of hostnames followed to resolve the
+		// address, starting with the announced hostname. It is only set
+		// if the hostname is a CNAME.
+		CNAMEChain []string `json:"cnameChain,omitempty"`
+
+		// ReverseDNS maps resolved addresses to their PTR records
+		ReverseDNS map[string][]string `json:"reverseDNS,omitempty"`
+		// NetworkOwners maps resolved addresses to the registered owner of
+		// their network. It is only set if network lookups are enabled.
+		NetworkOwners map[string]NetworkOwner `json:"networkOwners,omitempty"`
+		// IPResults are the results of connecting to each dialed
+		// address. They are only set if the hostname resolved to more
+		// than one address, in which case the rest of the result is for
+		// the first address that completed the handshake. At most the
+		// server's per-host address limit are dialed.
+		IPResults []IPResult `json:"ipResults,omitempty"`
+
+		// PortOpen is true if the host responded on the address's port,
+		// even if the handshake later failed.
+		PortOpen bool `json:"portOpen"`
+		// Connected is true once a connection to the host is
+		// established. For QUIC, it is only set once the host responds
+		// to the settings RPC, since the handshake can complete without
+		// hostd handling requests.
+		Connected    bool          `json:"connected"`
+		DialTime     time.Duration `json:"dialTime"`
+		DialAttempts int           `json:"dialAttempts"`
+		// dialFailure is set if connecting to the host was refused or
+		// timed out
+		dialFailure dialFailure
 
 		Handshake     bool          `json:"handshake"`
 		HandshakeTime time.Duration `json:"handshakeTime"`
+		// TransportVersion is the wire protocol version negotiated
+		// during the handshake, such as "siamux/3". It is distinct from
+		// the host's software version.
+		TransportVersion string `json:"transportVersion,omitempty"`
+
+		// BytesSent and BytesReceived are the number of bytes exchanged
+		// with the host. For QUIC, only stream data is counted.
+		BytesSent     uint64 `json:"bytesSent"`
+		BytesReceived uint64 `json:"bytesReceived"`
 
 		Scanned  bool          `json:"scanned"`
 		ScanTime time.Duration `json:"scanTime"`
+		// Quick is true if the test stopped after the handshake, so the
+		// host's settings were not scanned.
+		Quick bool `json:"quick,omitempty"`
 
 		Settings *proto4.HostSettings `json:"settings"`
+		Pricing  *Pricing             `json:"pricing,omitempty"`
+		// Contract is the cost of the requested contract at the host's
+		// prices
+		Contract *ContractCost `json:"contract,omitempty"`
+
+		// TimedOut is true if the server's time limit was reached before
+		// the test completed. The result may be incomplete.
+		TimedOut bool `json:"timedOut"`
+
+		// Certificate is only set for QUIC addresses
+		Certificate *Certificate `json:"certificate,omitempty"`
 
 		Errors   []string `json:"errors"`
 		Warnings []string `json:"warnings"`
+		Notes    []string `json:"notes,omitempty"`
+	}
+
+	// A ResolverAnswer is a single DNS resolver's answer for an address's
+	// hostname.
+	ResolverAnswer struct {
+		Resolver  string   `json:"resolver"`
+		Addresses []string `json:"addresses,omitempty"`
+		Error     string   `json:"error,omitempty"`
+	}
+
+	// An IPResult is the result of connecting to one of the addresses an
+	// address's hostname resolved to. Only the handshake is tested, except
+	// for the address the rest of the RHP4Result is for.
+	IPResult struct {
+		Address       string        `json:"address"`
+		PortOpen      bool          `json:"portOpen"`
+		Connected     bool          `json:"connected"`
+		Handshake     bool          `json:"handshake"`
+		HandshakeTime time.Duration `json:"handshakeTime"`
+		Errors        []string      `json:"errors,omitempty"`
+	}
+
+	// A SubnetLookup is the result of resolving a hostname as if the query
+	// came from a client in Subnet.
+	SubnetLookup struct {
+		Hostname  string   `json:"hostname"`
+		Subnet    string   `json:"subnet"`
+		Resolver  string   `json:"resolver"`
+		Addresses []string `json:"addresses"`
+		// Scope is the prefix length the answer applies to, as reported
+		// by the resolver. It is -1 if the resolver did not report one.
+		Scope int `json:"scope"`
+	}
+
+	// A DNSLookup is the result of resolving a hostname and running the
+	// same supplementary DNS checks as a host test.
+	DNSLookup struct {
+		Hostname string `json:"hostname"`
+		Resolver string `json:"resolver"`
+		// RecordType is "A" or "AAAA" if only one address family was
+		// resolved
+		RecordType string   `json:"recordType,omitempty"`
+		Addresses  []string `json:"addresses"`
+		// CNAMEChain is the chain of hostnames followed to resolve the
+		// hostname, starting with Hostname. It is only set if the
+		// hostname is a CNAME.
+		CNAMEChain []string `json:"cnameChain,omitempty"`
+		// ReverseDNS maps resolved addresses to their PTR records
+		ReverseDNS map[string][]string `json:"reverseDNS,omitempty"`
+		// ResolveTime is how long resolving the hostname took and
+		// CheckTime how long the supplementary checks took.
+		ResolveTime time.Duration `json:"resolveTime"`
+		CheckTime   time.Duration `json:"checkTime"`
+		Warnings    []string      `json:"warnings,omitempty"`
+	}
+
+	// A NetworkOwner is the registered owner of the network containing an
+	// IP address.
+	NetworkOwner struct {
+		Network      string `json:"network"`
+		Organization string `json:"organization,omitempty"`
+		AbuseContact string `json:"abuseContact,omitempty"`
+	}
+
+	// A Certificate contains the details of the TLS certificate presented
+	// by a host's QUIC endpoint.
+	Certificate struct {
+		Subject   string    `json:"subject"`
+		Issuer    string    `json:"issuer"`
+		NotBefore time.Time `json:"notBefore"`
+		NotAfter  time.Time `json:"notAfter"`
+		// Names are the DNS names and IP addresses the certificate covers
+		Names []string `json:"names,omitempty"`
+		// ServerName is the announced hostname, which is sent as the TLS
+		// server name (SNI) and must be one of the certificate's names
+		ServerName    string `json:"serverName"`
+		HostnameMatch bool   `json:"hostnameMatch"`
+	}
+
+	// Pricing is a host's prices converted from per-byte and per-block
+	// values to per-terabyte and per-month values.
+	Pricing struct {
+		// StoragePrice and Collateral are per TB per month
+		StoragePrice types.Currency `json:"storagePrice"`
+		Collateral   types.Currency `json:"collateral"`
+
+		// IngressPrice and EgressPrice are per TB
+		IngressPrice types.Currency `json:"ingressPrice"`
+		EgressPrice  types.Currency `json:"egressPrice"`
+	}
+
+	// A ContractCost is the cost of storing data with the host for a
+	// contract's duration at the host's current prices.
+	ContractCost struct {
+		// Duration is the contract's duration in blocks
+		Duration uint64 `json:"duration"`
+		// DataSize is the amount of data stored, in bytes
+		DataSize uint64 `json:"dataSize"`
+
+		// StorageCost is the cost of storing the data for the duration
+		StorageCost types.Currency `json:"storageCost"`
+		// UploadCost is the cost of uploading the data once
+		UploadCost types.Currency `json:"uploadCost"`
+		// Collateral is the collateral the host locks for the data for
+		// the duration
+		Collateral types.Currency `json:"collateral"`
+	}
+
+	// PriceLimits are the maximum prices, in the same units as Pricing,
+	// above which a warning is emitted. A zero limit is not checked.
+	PriceLimits struct {
+		StoragePrice types.Currency
+		IngressPrice types.Currency
+		EgressPrice  types.Currency
+	}
+
+	// ConsensusStatus describes the network and consensus state the server
+	// compares hosts against.
+	ConsensusStatus struct {
+		Network string           `json:"network"`
+		Tip     types.ChainIndex `json:"tip"`
+		// LastUpdate is when the consensus state was last updated
+		LastUpdate time.Time `json:"lastUpdate"`
+
+		// V2Allowed and V2Required are true once the tip has reached the
+		// v2 hardfork's allow and require heights. Only RHP4 is tested, so
+		// hosts that have not upgraded to v2 cannot be tested.
+		V2AllowHeight   uint64 `json:"v2AllowHeight"`
+		V2RequireHeight uint64 `json:"v2RequireHeight"`
+		V2Allowed       bool   `json:"v2Allowed"`
+		V2Required      bool   `json:"v2Required"`
+	}
+
+	// ReleaseStatus describes the latest hostd release the server compares
+	// hosts against.
+	ReleaseStatus struct {
+		Version string `json:"version"`
+		// LastUpdate is when the release was last refreshed from GitHub
+		LastUpdate time.Time `json:"lastUpdate"`
+		// Prereleases is true if pre-releases are considered when
+		// determining the latest release
+		Prereleases bool `json:"prereleases"`
+	}
+
+	// Thresholds control when a host's settings are considered
+	// unfavorable for renters.
+	Thresholds struct {
+		// MinCollateralRatio is the ratio of the collateral price to the
+		// storage price below which a warning is emitted.
+		MinCollateralRatio float64
+		// MinContractDuration is the maximum contract duration, in blocks,
+		// below which a warning is emitted.
+		MinContractDuration uint64
 	}
 
 	// A Result is the result of testing a host. It contains the public key of the
-	// host, the version of the host, and the results of the RHP2, RHP3, and RHP4
+	// host, the version of the host, and the results of the RHP4 tests. RHP2
+	// and RHP3 were removed by the v2 hardfork and are never tested.
 	Result struct {
 		PublicKey types.PublicKey `json:"publicKey"`
 		Version   string          `json:"version"`
+		// VersionDrift is how far the host's version is behind the latest
+		// release. It is only set if the host's version is known.
+		VersionDrift    VersionDrift  `json:"versionDrift,omitempty"`
+		OverrideAddress string        `json:"overrideAddress,omitempty"`
+		AddressFamily   AddressFamily `json:"addressFamily,omitempty"`
+		// DryRun is true if the addresses were only checked, not tested
+		DryRun bool `json:"dryRun,omitempty"`
+		// Quick is true if the addresses were only checked for
+		// reachability, without scanning the host's settings
+		Quick bool `json:"quick,omitempty"`
+		// RequestID is the ID of the API request that started the test,
+		// if any. It can be used to find the test's logs.
+		RequestID string `json:"requestID,omitempty"`
+		// Cached is true if the result is from an earlier test of the same
+		// request, finished at CachedAt, rather than a new test.
+		Cached   bool      `json:"cached,omitempty"`
+		CachedAt time.Time `json:"cachedAt,omitzero"`
+
+		// ScannedAt is when the test started and Elapsed is how long the
+		// whole test took.
+		ScannedAt time.Time     `json:"scannedAt"`
+		Elapsed   time.Duration `json:"elapsed"`
 
 		RHP4 []RHP4Result `json:"rhp4"`
+		// Transports are the RHP4 transports the host's addresses offer,
+		// whether or not they were tested, and MissingTransports are the
+		// ones they do not. Renters that only support a missing transport,
+		// such as browsers without QUIC, cannot use the host.
+		Transports        []chain.Protocol `json:"transports"`
+		MissingTransports []chain.Protocol `json:"missingTransports,omitempty"`
+
+		// Diagnosis explains the result in a single sentence when the
+		// per-address errors share a common cause, such as every resolved
+		// address refusing the connection.
+		Diagnosis string `json:"diagnosis,omitempty"`
+
+		// Errors and Warnings summarize the issues found across all
+		// addresses, including issues that span multiple addresses.
+		Errors   []Issue `json:"errors"`
+		Warnings []Issue `json:"warnings"`
+
+		// Score is between 0 and 100 and summarizes the result; Grade is
+		// its letter grade. See [ScoreWeights] for how it is calculated.
+		Score int   `json:"score"`
+		Grade Grade `json:"grade"`
+	}
+
+	// An Issue is an error or warning found while testing a host. Protocol
+	// is empty for issues that are not specific to a transport.
+	Issue struct {
+		Severity Severity       `json:"severity"`
+		Protocol chain.Protocol `json:"protocol,omitempty"`
+		Message  string         `json:"message"`
 	}
 
 	// An Explorer is an interface that defines the methods required to
 	// query state from the Sia blockchain.
 	Explorer interface {
 		ConsensusState() (consensus.State, error)
+		Host(types.PublicKey) (explorer.Host, error)
+	}
+
+	// scanConfig contains the server's configuration for address tests.
+	scanConfig struct {
+		retry       retryPolicy
+		dialTimeout time.Duration
+		// handshakeTimeout and settingsTimeout bound the transport
+		// handshake and settings scan of each address
+		handshakeTimeout time.Duration
+		settingsTimeout  time.Duration
+		// defaultPorts enables assuming the default port for addresses
+		// without one
+		defaultPorts bool
+		priceLimits  PriceLimits
+		thresholds   Thresholds
+		scoreWeights ScoreWeights
+		// hardforkWindow is how many blocks before the v2 require height
+		// hosts without a working RHP4 address are warned
+		hardforkWindow uint64
+		// proxy is used for TCP connections to hosts if set
+		proxy proxy.ContextDialer
+		// sourceAddr is the local address TCP connections to hosts are
+		// made from if set
+		sourceAddr net.IP
+		// rdap looks up the owners of resolved addresses if set
+		rdap *rdap.Client
+		// resolvers are queried concurrently to resolve hostnames
+		resolvers []resolver
+		// maxCNAMEDepth is the maximum number of CNAME records followed
+		// when resolving a hostname
+		maxCNAMEDepth int
+		// disabledProtocols are never tested, usually because the
+		// server's network blocks them
+		disabledProtocols map[chain.Protocol]bool
+		// hosts resolving to an internal address, if denyInternal is
+		// set, an address outside allowedNetworks, if set, or an address
+		// inside deniedNetworks are not dialed
+		denyInternal    bool
+		allowedNetworks []*net.IPNet
+		deniedNetworks  []*net.IPNet
+	}
+
+	// scanParams are the parameters shared by each address test during
+	// a host test.
+	scanParams struct {
+		scanConfig
+
+		hostKey        types.PublicKey
+		currentVersion SemVer
+		tip            types.ChainIndex
+		stateAge       time.Duration
+		overrideIP     net.IP
+		family         AddressFamily
+		expectedIPs    []net.IP
+		// quick stops each address's test after the handshake
+		quick bool
+		// skipVersionCheck disables the checks of the host's version
+		skipVersionCheck bool
+		// contractDuration and contractSize describe the contract whose
+		// cost is calculated. customContract is true if they were set by
+		// the request.
+		contractDuration uint64
+		contractSize     uint64
+		customContract   bool
+
+		// maxIPs is the maximum number of a hostname's resolved
+		// addresses that are dialed, or zero for no limit
+		maxIPs int
+		// tryAcquireScan acquires an additional scan slot if one is free.
+		// If nil, a hostname's resolved addresses are dialed one at a
+		// time.
+		tryAcquireScan func() (release func(), ok bool)
+		// claim is set while testing each of a hostname's resolved
+		// addresses. Only the first address to claim the test continues
+		// past the handshake.
+		claim func() bool
 	}
 
 	// A Manager manages the testing of hosts.
@@ -66,17 +570,177 @@ type (
 		tg       *threadgroup.ThreadGroup
 		log      *zap.Logger
 		explorer Explorer
+		// explorer requests are retried according to explorerRetry and
+		// each attempt is bounded by explorerTimeout
+		explorerRetry   retryPolicy
+		explorerTimeout time.Duration
+
+		// activeTests is the number of in-flight host tests
+		activeTests atomic.Int64
+		// scanSem limits the number of in-flight address tests
+		scanSem     chan struct{}
+		cfg         scanConfig
+		ecsResolver string
+		// maxAddresses is the maximum number of RHP4 addresses tested per
+		// request, or zero for no limit
+		maxAddresses int
+		// webhook is notified of results with issues if set
+		webhook *webhook
+		// history holds the recent results of each host
+		history *history
+		// a warning is added when a host's reachability changes
+		// flapTransitions times within flapWindow
+		flapTransitions int
+		flapWindow      time.Duration
+
+		// prereleases includes hostd pre-releases when determining the
+		// latest release
+		prereleases bool
+
+		mu                sync.Mutex // protects the fields below
+		latestRelease     SemVer
+		lastReleaseUpdate time.Time
+		state             consensus.State
+		lastStateUpdate   time.Time
 
-		mu            sync.Mutex // protects the fields below
-		latestRelease SemVer
-		state         consensus.State
+		// cache holds the latest result of each request
+		cache *resultCache
 
 		// cooldown protects hosts from being spammed too frequently
-		cooldown map[types.PublicKey]time.Time
+		cooldown       map[types.PublicKey]time.Time
+		cooldownPeriod time.Duration
 	}
 )
 
-// TestHost tests a host by connecting to its RHP2, RHP3, and RHP4 endpoints.
+// ActiveTests returns the number of host tests currently in progress.
+func (m *Manager) ActiveTests() int {
+	return int(m.activeTests.Load())
+}
+
+// ConsensusStatus returns the consensus state hosts are compared against.
+func (m *Manager) ConsensusStatus() ConsensusStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := ConsensusStatus{
+		Tip:        m.state.Index,
+		LastUpdate: m.lastStateUpdate,
+	}
+	if n := m.state.Network; n != nil {
+		status.Network = n.Name
+		status.V2AllowHeight = n.HardforkV2.AllowHeight
+		status.V2RequireHeight = n.HardforkV2.RequireHeight
+		status.V2Allowed = m.state.Index.Height >= n.HardforkV2.AllowHeight
+		status.V2Required = m.state.Index.Height >= n.HardforkV2.RequireHeight
+	}
+	return status
+}
+
+// ConsensusTip returns the chain tip hosts are compared against.
+func (m *Manager) ConsensusTip() types.ChainIndex {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state.Index
+}
+
+// setState replaces the consensus state hosts are compared against.
+func (m *Manager) setState(cs consensus.State) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.state = cs
+	m.lastStateUpdate = time.Now()
+}
+
+// setLatestRelease replaces the hostd release hosts are compared against.
+func (m *Manager) setLatestRelease(release SemVer) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.latestRelease = release
+	m.lastReleaseUpdate = time.Now()
+}
+
+// LatestRelease returns the latest hostd release hosts are compared
+// against. It is the zero value until the first release check completes.
+func (m *Manager) LatestRelease() SemVer {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.latestRelease
+}
+
+// ReleaseStatus returns the latest hostd release hosts are compared against
+// and when it was last updated.
+func (m *Manager) ReleaseStatus() ReleaseStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	status := ReleaseStatus{
+		LastUpdate:  m.lastReleaseUpdate,
+		Prereleases: m.prereleases,
+	}
+	if !m.lastReleaseUpdate.IsZero() {
+		status.Version = m.latestRelease.String()
+	}
+	return status
+}
+
+// Health returns an error wrapping [ErrUnhealthy] if the consensus state
+// is stale or the latest release is unknown.
+func (m *Manager) Health() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var errs []error
+	if age := time.Since(m.lastStateUpdate); age > maxStateAge {
+		errs = append(errs, fmt.Errorf("consensus state has not been updated in %s: check explorer connectivity", age.Round(time.Second)))
+	}
+	if m.lastReleaseUpdate.IsZero() {
+		errs = append(errs, errors.New("latest release is unknown: check GitHub connectivity"))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%w: %w", ErrUnhealthy, errors.Join(errs...))
+	}
+	return nil
+}
+
+// acquireScan blocks until a scan slot is available or the context is
+// canceled. The returned function must be called to release the slot.
+func (m *Manager) acquireScan(ctx context.Context) (func(), error) {
+	select {
+	case <-ctx.Done():
+		return nil, fmt.Errorf("server is busy, please try again later: %w", ctx.Err())
+	case m.scanSem <- struct{}{}:
+		return func() { <-m.scanSem }, nil
+	}
+}
+
+// tryAcquireScan acquires a scan slot if one is available without
+// blocking.
+func (m *Manager) tryAcquireScan() (func(), bool) {
+	select {
+	case m.scanSem <- struct{}{}:
+		return func() { <-m.scanSem }, true
+	default:
+		return nil, false
+	}
+}
+
+// Cooldown returns how long until the host can be tested again. It is zero
+// if TestHost would not be rejected by the host's cooldown, including dry
+// runs and requests with a cached result.
+func (m *Manager) Cooldown(host Host) time.Duration {
+	host, _ = m.limitAddresses(host)
+	if host.DryRun {
+		return 0
+	} else if _, ok := m.cache.get(resultCacheKey(host)); ok && !host.Force {
+		return 0
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return max(time.Until(m.cooldown[host.PublicKey]), 0)
+}
+
+// TestHost tests a host by connecting to its RHP4 endpoints.
 // It returns a Result struct containing the results of the tests.
 func (m *Manager) TestHost(ctx context.Context, host Host) (Result, error) {
 	ctx, cancel, err := m.tg.AddContext(ctx)
@@ -85,36 +749,152 @@ func (m *Manager) TestHost(ctx context.Context, host Host) (Result, error) {
 	}
 	defer cancel()
 
+	m.activeTests.Add(1)
+	defer m.activeTests.Add(-1)
+
+	var overrideIP net.IP
+	if host.OverrideAddress != "" {
+		overrideIP = net.ParseIP(strings.Trim(host.OverrideAddress, "[]"))
+		if overrideIP == nil {
+			return Result{}, fmt.Errorf("override address %q is not a valid IP address", host.OverrideAddress)
+		}
+	}
+
+	protocols, err := requestedProtocols(host.Protocols)
+	if err != nil {
+		return Result{}, err
+	}
+
+	switch host.AddressFamily {
+	case AddressFamilyAny, AddressFamilyIPv4, AddressFamilyIPv6:
+	default:
+		return Result{}, fmt.Errorf("unknown address family %q", host.AddressFamily)
+	}
+	if overrideIP != nil && !host.AddressFamily.contains(overrideIP) {
+		return Result{}, fmt.Errorf("override address %q is not an %s address", host.OverrideAddress, host.AddressFamily)
+	}
+
+	expectedIPs, err := parseExpectedIPs(host.ExpectedIPs)
+	if err != nil {
+		return Result{}, err
+	} else if len(expectedIPs) > 0 && overrideIP != nil {
+		return Result{}, errors.New("expected IPs cannot be checked when an override address is set")
+	}
+
+	host, limitWarning := m.limitAddresses(host)
+	if host.DryRun {
+		resp := dryRun(host, m.cfg, protocols)
+		if limitWarning != "" {
+			resp.Warnings = append(resp.Warnings, Issue{Severity: SeverityWarning, Message: limitWarning})
+		}
+		resp.RequestID = requestID(ctx)
+		return resp, nil
+	}
+
+	cacheKey := resultCacheKey(host)
+	if !host.Force {
+		if resp, ok := m.cache.get(cacheKey); ok {
+			resp.RequestID = requestID(ctx)
+			return resp, nil
+		}
+	}
+
 	m.mu.Lock()
 	// check if the host is on cooldown
 	if n := time.Until(m.cooldown[host.PublicKey]); n > 0 {
 		m.mu.Unlock()
-		return Result{}, fmt.Errorf("host is on cooldown, please try again in %s", n)
+		return Result{}, &CooldownError{Remaining: n}
 	}
-	m.cooldown[host.PublicKey] = time.Now().Add(15 * time.Second)
+	m.cooldown[host.PublicKey] = time.Now().Add(m.cooldownPeriod)
 	// grab the latest state
 	latestRelease := m.latestRelease
 	cs := m.state
+	stateAge := time.Since(m.lastStateUpdate)
 	m.mu.Unlock()
 
+	params := scanParams{
+		scanConfig: m.cfg,
+
+		hostKey:        host.PublicKey,
+		currentVersion: latestRelease,
+		tip:            cs.Index,
+		stateAge:       stateAge,
+		overrideIP:     overrideIP,
+		family:         host.AddressFamily,
+		expectedIPs:    expectedIPs,
+		quick:          host.Quick,
+
+		skipVersionCheck: host.SkipVersionCheck,
+
+		contractDuration: host.ContractDuration,
+		contractSize:     host.DataSize,
+		customContract:   host.ContractDuration != 0 || host.DataSize != 0,
+
+		maxIPs:         m.maxAddresses,
+		tryAcquireScan: m.tryAcquireScan,
+	}
+	if params.contractDuration == 0 {
+		params.contractDuration = m.cfg.thresholds.MinContractDuration
+		if params.contractDuration == 0 {
+			params.contractDuration = blocksPerMonth
+		}
+	}
+	if params.contractSize == 0 {
+		params.contractSize = representativeContractSize
+	}
+
 	start := time.Now()
 	log := m.log.With(zap.Stringer("host", host.PublicKey))
+	id := requestID(ctx)
+	if id != "" {
+		log = log.With(zap.String("requestID", id))
+	}
 	log.Debug("starting host test")
 
 	resp := Result{
-		PublicKey: host.PublicKey,
+		PublicKey:       host.PublicKey,
+		OverrideAddress: host.OverrideAddress,
+		AddressFamily:   host.AddressFamily,
+		Quick:           host.Quick,
+		RequestID:       id,
+		ScannedAt:       start,
 	}
+	resp.Transports, resp.MissingTransports = offeredTransports(host.RHP4NetAddresses)
 	var wg sync.WaitGroup
 
+	// fetch the host's on-chain announcement while the tests run. Quick
+	// tests skip it to reduce the load on the explorer.
+	var announced []chain.NetAddress
+	var announcedErr error
+	if !host.Quick {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// the explorer client does not take a context and retries
+			// for longer than a test takes
+			announcedHost, err := withContext(ctx, func() (explorer.Host, error) {
+				return m.explorer.Host(host.PublicKey)
+			})
+			if err != nil {
+				announcedErr = err
+				return
+			}
+			announced = announcedHost.V2NetAddresses
+		}()
+	}
+
 	resp.RHP4 = make([]RHP4Result, len(host.RHP4NetAddresses))
 	rhp4Protos := make(map[chain.Protocol]bool)
 	var rhp4VersionSet sync.Once
 	var rhp4Version string
 	for i, addr := range host.RHP4NetAddresses {
+		addr, resp.RHP4[i].Warnings = normalizeAddress(addr)
 		if rhp4Protos[addr.Protocol] {
 			// skip duplicate protocols
 			resp.RHP4[i].Errors = append(resp.RHP4[i].Errors, fmt.Sprintf("duplicate protocol %q", addr.Protocol))
 			continue
+		} else if skipAddress(m.cfg, protocols, addr, &resp.RHP4[i]) {
+			continue
 		}
 
 		wg.Add(1)
@@ -122,9 +902,18 @@ func (m *Manager) TestHost(ctx context.Context, host Host) (Result, error) {
 			defer wg.Done()
 
 			log := log.With(zap.String("addr", addr.Address), zap.String("protocol", string(addr.Protocol)))
+			release, err := m.acquireScan(ctx)
+			if err != nil {
+				resp.RHP4[i].NetAddress = addr
+				resp.RHP4[i].TimedOut = true
+				resp.RHP4[i].Errors = append(resp.RHP4[i].Errors, err.Error())
+				return
+			}
+			defer release()
+
 			log.Debug("starting RHP4 test")
 			start := time.Now()
-			testRHP4(ctx, latestRelease, cs.Index, host.PublicKey, addr, &resp.RHP4[i])
+			testRHP4(ctx, params, addr, &resp.RHP4[i])
 			log.Debug("finished RHP4 test", zap.Bool("successful", resp.RHP4[i].Scanned), zap.Duration("elapsed", time.Since(start)))
 			if resp.RHP4[i].Settings != nil {
 				// sticky version check
@@ -139,18 +928,278 @@ func (m *Manager) TestHost(ctx context.Context, host Host) (Result, error) {
 		}(i, addr)
 	}
 	wg.Wait()
+
+	if announcedErr != nil {
+		log.Debug("failed to get host announcement", zap.Error(announcedErr))
+	} else if !host.Quick {
+		for i := range resp.RHP4 {
+			if resp.RHP4[i].NetAddress.Address == "" || resp.RHP4[i].Skipped {
+				continue
+			}
+			checkAnnouncement(announced, &resp.RHP4[i])
+		}
+	}
+
+	correlateTransports(resp.RHP4)
+	warnings := checkTransports(resp.RHP4)
+	if limitWarning != "" {
+		warnings = append(warnings, limitWarning)
+	}
+	warnings = append(warnings, checkHardfork(cs, m.cfg.hardforkWindow, resp.RHP4)...)
+	warnings = append(warnings, m.checkFlapping(resp)...)
+	summarize(&resp, warnings)
+	if overrideIP == nil && host.AddressFamily == AddressFamilyAny {
+		// otherwise, a single address is dialed instead of every address
+		// the hostnames resolved to
+		resp.Diagnosis = diagnoseUnreachable(resp.RHP4)
+	}
+
 	if len(resp.RHP4) != 0 {
 		for _, r := range resp.RHP4 {
 			if r.Settings != nil {
 				resp.Version = r.Settings.Release
+				if release, err := parseReleaseString(resp.Version); err == nil && !params.skipVersionCheck {
+					resp.VersionDrift = release.Drift(params.currentVersion)
+				}
 				break
 			}
 		}
 	}
-	log.Info("host tested", zap.String("version", resp.Version), zap.Duration("elapsed", time.Since(start)))
+	resp.Score = score(resp, params)
+	resp.Grade = gradeForScore(resp.Score)
+	resp.Elapsed = time.Since(start)
+	log.Info("host tested", zap.String("version", resp.Version), zap.String("grade", string(resp.Grade)), zap.Duration("elapsed", resp.Elapsed))
+	m.history.add(resp)
+	m.cache.add(cacheKey, resp)
+	m.notify(resp, log)
 	return resp, nil
 }
 
+// limitAddresses removes the host's RHP4 addresses beyond the manager's
+// limit, so that a request with many addresses cannot use an unbounded
+// amount of resources. It returns a warning if any addresses were removed.
+func (m *Manager) limitAddresses(host Host) (Host, string) {
+	n := len(host.RHP4NetAddresses)
+	if m.maxAddresses <= 0 || n <= m.maxAddresses {
+		return host, ""
+	}
+	host.RHP4NetAddresses = host.RHP4NetAddresses[:m.maxAddresses]
+	return host, fmt.Sprintf("only the first %d of the host's %d RHP4 addresses were tested: this server tests at most %d addresses per host", m.maxAddresses, n, m.maxAddresses)
+}
+
+// dryRun checks the host's public key and addresses without resolving or
+// connecting to them.
+func dryRun(host Host, cfg scanConfig, protocols map[chain.Protocol]bool) Result {
+	resp := Result{
+		PublicKey:       host.PublicKey,
+		OverrideAddress: host.OverrideAddress,
+		AddressFamily:   host.AddressFamily,
+		DryRun:          true,
+		ScannedAt:       time.Now(),
+	}
+	resp.Transports, resp.MissingTransports = offeredTransports(host.RHP4NetAddresses)
+
+	resp.RHP4 = make([]RHP4Result, len(host.RHP4NetAddresses))
+	for i, addr := range host.RHP4NetAddresses {
+		addr, resp.RHP4[i].Warnings = normalizeAddress(addr)
+		if skipAddress(cfg, protocols, addr, &resp.RHP4[i]) {
+			continue
+		}
+		checkAddress(cfg, addr, &resp.RHP4[i])
+	}
+	summarize(&resp, nil)
+
+	if host.PublicKey == (types.PublicKey{}) {
+		resp.Errors = append(resp.Errors, Issue{Severity: SeverityError, Message: "missing public key"})
+	}
+	if len(host.RHP4NetAddresses) == 0 {
+		resp.Errors = append(resp.Errors, Issue{Severity: SeverityError, Message: "host has no RHP4 addresses"})
+	}
+	resp.Elapsed = time.Since(resp.ScannedAt)
+	return resp
+}
+
+// skipAddress marks the address as skipped if its protocol is disabled on
+// the server or was not requested. It returns true if the address should
+// not be tested.
+func skipAddress(cfg scanConfig, protocols map[chain.Protocol]bool, addr chain.NetAddress, res *RHP4Result) bool {
+	switch {
+	case cfg.disabledProtocols[addr.Protocol]:
+		res.Notes = append(res.Notes, fmt.Sprintf("%s testing is disabled on this server", addr.Protocol))
+	case protocols != nil && !protocols[addr.Protocol]:
+	default:
+		return false
+	}
+	res.NetAddress = addr
+	res.Skipped = true
+	return true
+}
+
+// requestedProtocols returns the set of protocols to test. A nil set means
+// every protocol should be tested.
+func requestedProtocols(protocols []chain.Protocol) (map[chain.Protocol]bool, error) {
+	if len(protocols) == 0 {
+		return nil, nil
+	}
+	requested := make(map[chain.Protocol]bool)
+	for _, proto := range protocols {
+		switch proto, _ := canonicalProtocol(proto); proto {
+		case siamux.Protocol, quic.Protocol:
+			requested[proto] = true
+		default:
+			return nil, fmt.Errorf("unknown protocol %q", proto)
+		}
+	}
+	return requested, nil
+}
+
+// summarize populates the result's top-level errors and warnings from each
+// address's results. Duplicate issues for the same protocol are only
+// included once.
+func summarize(resp *Result, warnings []string) {
+	seen := make(map[Issue]bool)
+	add := func(issues []Issue, severity Severity, proto chain.Protocol, messages []string) []Issue {
+		for _, msg := range messages {
+			issue := Issue{Severity: severity, Protocol: proto, Message: msg}
+			if seen[issue] {
+				continue
+			}
+			seen[issue] = true
+			issues = append(issues, issue)
+		}
+		return issues
+	}
+
+	for _, r := range resp.RHP4 {
+		resp.Errors = add(resp.Errors, SeverityError, r.NetAddress.Protocol, r.Errors)
+	}
+	for _, r := range resp.RHP4 {
+		resp.Warnings = add(resp.Warnings, SeverityWarning, r.NetAddress.Protocol, r.Warnings)
+	}
+	resp.Warnings = add(resp.Warnings, SeverityWarning, "", warnings)
+}
+
+// AnnouncedHost returns the host's announced RHP4 addresses as
+// reported by the explorer.
+func (m *Manager) AnnouncedHost(hostKey types.PublicKey) (Host, error) {
+	host, err := m.explorer.Host(hostKey)
+	if err != nil {
+		return Host{}, fmt.Errorf("failed to get host announcement: %w", err)
+	} else if len(host.V2NetAddresses) == 0 {
+		return Host{}, fmt.Errorf("host %q has not announced any RHP4 addresses", hostKey)
+	}
+	return Host{
+		PublicKey:        host.PublicKey,
+		RHP4NetAddresses: host.V2NetAddresses,
+	}, nil
+}
+
+// LookupSubnet resolves hostname as if the query came from a client in
+// subnet, using the EDNS0 client subnet option. It can be used to check
+// which addresses renters in different regions receive from geo-aware DNS.
+func (m *Manager) LookupSubnet(ctx context.Context, hostname, subnet string) (SubnetLookup, error) {
+	ctx, cancel, err := m.tg.AddContext(ctx)
+	if err != nil {
+		return SubnetLookup{}, err
+	}
+	defer cancel()
+
+	_, ipNet, err := net.ParseCIDR(subnet)
+	if err != nil {
+		// allow a single address
+		ip := net.ParseIP(subnet)
+		if ip == nil {
+			return SubnetLookup{}, fmt.Errorf("invalid subnet %q", subnet)
+		}
+		bits := 8 * len(ip.To16())
+		if ip4 := ip.To4(); ip4 != nil {
+			ip, bits = ip4, 32
+		}
+		ipNet = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+	}
+
+	ips, scope, err := dns.LookupIPSubnet(ctx, m.ecsResolver, hostname, ipNet)
+	if err != nil {
+		return SubnetLookup{}, fmt.Errorf("failed to resolve %q: %w", hostname, err)
+	}
+	lookup := SubnetLookup{
+		Hostname: hostname,
+		Subnet:   ipNet.String(),
+		Resolver: m.ecsResolver,
+		Scope:    scope,
+	}
+	for _, ip := range ips {
+		lookup.Addresses = append(lookup.Addresses, ip.String())
+	}
+	return lookup, nil
+}
+
+// LookupDNS resolves hostname using resolver, following CNAME records, and
+// runs the same supplementary DNS checks as a host test. If resolver is
+// empty, the resolver used for the supplementary checks is queried. If
+// recordType is "A" or "AAAA", only that address family is resolved.
+func (m *Manager) LookupDNS(ctx context.Context, hostname, resolver, recordType string) (DNSLookup, error) {
+	ctx, cancel, err := m.tg.AddContext(ctx)
+	if err != nil {
+		return DNSLookup{}, err
+	}
+	defer cancel()
+
+	if resolver == "" {
+		resolver = m.cfg.dnsServer()
+	} else if err := dns.ValidateServer(resolver); err != nil {
+		return DNSLookup{}, err
+	}
+	ctx = m.cfg.dnsContext(ctx, resolver)
+	recordType = strings.ToUpper(recordType)
+	var network string
+	switch recordType {
+	case "":
+		network = "ip"
+	case "A":
+		network = "ip4"
+	case "AAAA":
+		network = "ip6"
+	default:
+		return DNSLookup{}, fmt.Errorf("unsupported record type %q: must be A or AAAA", recordType)
+	}
+	hostname = strings.TrimSuffix(hostname, ".")
+
+	start := time.Now()
+	ips, chain, err := dns.LookupIPChain(ctx, resolver, network, hostname, m.cfg.maxCNAMEDepth)
+	if errors.Is(err, dns.ErrNotFound) {
+		// validating resolvers return no records if the signatures are
+		// invalid
+		if failure, _ := dns.DNSSECFailure(ctx, resolver, hostname); failure {
+			return DNSLookup{}, fmt.Errorf("failed to resolve %q: %w: %s", hostname, err, dnssecWarning(hostname))
+		}
+	}
+	if err != nil {
+		return DNSLookup{}, fmt.Errorf("failed to resolve %q: %w", hostname, err)
+	}
+	lookup := DNSLookup{
+		Hostname:    hostname,
+		Resolver:    resolver,
+		RecordType:  recordType,
+		ResolveTime: time.Since(start),
+	}
+	for _, ip := range ips {
+		lookup.Addresses = append(lookup.Addresses, ip.String())
+	}
+	if len(chain) > 1 {
+		lookup.CNAMEChain = chain
+	}
+
+	start = time.Now()
+	report := checkDNS(ctx, resolver, hostname, ips, m.cfg.maxCNAMEDepth)
+	lookup.CheckTime = time.Since(start)
+	if len(report.reverse) > 0 {
+		lookup.ReverseDNS = report.reverse
+	}
+	lookup.Warnings = report.warnings
+	return lookup, nil
+}
+
 // Close stops the manager and releases any resources it holds.
 func (m *Manager) Close() error {
 	m.tg.Stop()
@@ -159,29 +1208,62 @@ func (m *Manager) Close() error {
 
 // NewManager creates a new Manager instance. It fetches the latest release
 // from GitHub and initializes the manager with the provided Explorer and logger.
-func NewManager(explorer Explorer, log *zap.Logger) (*Manager, error) {
-	latestRelease, err := github.LatestRelease("SiaFoundation", "hostd")
-	if err != nil {
-		return nil, fmt.Errorf("failed to get latest release: %w", err)
-	}
-
+// The context bounds the initial requests to GitHub and the explorer; it
+// does not affect the manager once it is created.
+func NewManager(ctx context.Context, explorer Explorer, log *zap.Logger, opts ...Option) (*Manager, error) {
 	m := &Manager{
-		tg:       threadgroup.New(),
-		log:      log,
-		explorer: explorer,
+		tg:           threadgroup.New(),
+		log:          log,
+		explorer:     explorer,
+		scanSem:      make(chan struct{}, defaultMaxConcurrentScans),
+		maxAddresses: defaultMaxAddresses,
+		cfg: scanConfig{
+			retry:            retryPolicy{Attempts: 1, Backoff: time.Second},
+			dialTimeout:      defaultDialTimeout,
+			handshakeTimeout: defaultHandshakeTimeout,
+			settingsTimeout:  defaultSettingsTimeout,
+			priceLimits:      defaultPriceLimits,
+			thresholds:       defaultThresholds,
+			scoreWeights:     defaultScoreWeights,
+			hardforkWindow:   defaultHardforkWindow,
+			resolvers:        defaultResolvers(),
+			maxCNAMEDepth:    dns.DefaultMaxCNAMEDepth,
+			denyInternal:     true,
+		},
 
-		cooldown: make(map[types.PublicKey]time.Time),
+		explorerRetry:   defaultExplorerRetry,
+		explorerTimeout: defaultExplorerTimeout,
+
+		ecsResolver: defaultECSResolver,
+		history:     newHistory(defaultHistorySize, defaultHistoryMaxHosts),
+		cache:       newResultCache(defaultResultCacheTTL),
+
+		flapTransitions: defaultFlapTransitions,
+		flapWindow:      defaultFlapWindow,
+
+		cooldown:       make(map[types.PublicKey]time.Time),
+		cooldownPeriod: defaultCooldown,
+	}
+	for _, opt := range opts {
+		opt(m)
 	}
+	m.explorer = &retryExplorer{explorer: explorer, retry: m.explorerRetry, timeout: m.explorerTimeout}
 
+	latestRelease, err := fetchLatestRelease(ctx, m.prereleases)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get latest release: %w", err)
+	}
 	if err := m.latestRelease.UnmarshalText([]byte(latestRelease)); err != nil {
 		return nil, fmt.Errorf("failed to unmarshal latest release: %w", err)
 	}
+	m.lastReleaseUpdate = time.Now()
 
-	cs, err := explorer.ConsensusState()
+	cs, err := withContext(ctx, m.explorer.ConsensusState)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get tip state: %w", err)
 	}
 	m.state = cs
+	m.lastStateUpdate = time.Now()
 
 	ctx, cancel, err := m.tg.AddContext(context.Background())
 	if err != nil {
@@ -191,30 +1273,37 @@ func NewManager(explorer Explorer, log *zap.Logger) (*Manager, error) {
 	go func() {
 		defer cancel()
 
-		versionTicker := time.NewTicker(15 * time.Minute)
+		versionTicker := time.NewTicker(releasePollInterval)
 		defer versionTicker.Stop()
 
-		// tip state changes more frequently than the
-		// latest release, poll it every minute.
-		stateTicker := time.NewTicker(time.Minute)
+		stateTicker := time.NewTicker(statePollInterval)
 		defer stateTicker.Stop()
 
+		// releaseRetryAt is when GitHub's rate limit resets. The last
+		// known release is kept until then.
+		var releaseRetryAt time.Time
+
 		for {
 			select {
 			case <-ctx.Done():
 				return
 			case <-stateTicker.C:
-				cs, err := explorer.ConsensusState()
+				cs, err := m.explorer.ConsensusState()
 				if err != nil {
 					log.Warn("failed to update tip state", zap.Error(err))
 					continue
 				}
-				m.mu.Lock()
-				m.state = cs
-				m.mu.Unlock()
+				m.setState(cs)
 			case <-versionTicker.C:
-				releaseStr, err := github.LatestRelease("SiaFoundation", "hostd")
-				if err != nil {
+				if time.Now().Before(releaseRetryAt) {
+					continue
+				}
+				releaseStr, err := fetchLatestRelease(ctx, m.prereleases)
+				if reset, ok := github.RateLimitReset(err); ok {
+					releaseRetryAt = reset
+					log.Warn("GitHub rate limit reached, keeping the last known release until it resets", zap.Time("reset", reset), zap.Error(err))
+					continue
+				} else if err != nil {
 					log.Warn("failed to update latest release", zap.Error(err))
 					continue
 				}
@@ -223,9 +1312,7 @@ func NewManager(explorer Explorer, log *zap.Logger) (*Manager, error) {
 					log.Warn("failed to unmarshal latest release", zap.Error(err))
 					continue
 				}
-				m.mu.Lock()
-				m.latestRelease = release
-				m.mu.Unlock()
+				m.setLatestRelease(release)
 			}
 		}
 	}()
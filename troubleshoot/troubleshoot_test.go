@@ -0,0 +1,642 @@
+package troubleshoot
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.sia.tech/core/consensus"
+	"go.sia.tech/core/types"
+	"go.sia.tech/coreutils/chain"
+	"go.sia.tech/coreutils/rhp/v4/quic"
+	"go.sia.tech/coreutils/rhp/v4/siamux"
+	"go.sia.tech/coreutils/threadgroup"
+	"go.sia.tech/explored/explorer"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+type mockExplorer struct{}
+
+func (mockExplorer) ConsensusState() (consensus.State, error) {
+	return consensus.State{}, nil
+}
+
+func (mockExplorer) Host(types.PublicKey) (explorer.Host, error) {
+	return explorer.Host{}, errors.New("host not found")
+}
+
+func TestAcquireScan(t *testing.T) {
+	const limit = 3
+	m := &Manager{}
+	WithMaxConcurrentScans(limit)(m)
+
+	var releases []func()
+	for i := 0; i < limit; i++ {
+		release, err := m.acquireScan(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		releases = append(releases, release)
+	}
+
+	// the next scan should block until the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := m.acquireScan(ctx); err == nil {
+		t.Fatal("expected scan to be rejected when the limit is reached")
+	}
+
+	// releasing a slot should allow another scan
+	releases[0]()
+	release, err := m.acquireScan(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// a limit that is not positive keeps the default
+	for _, n := range []int{0, -1} {
+		m := &Manager{scanSem: make(chan struct{}, defaultMaxConcurrentScans)}
+		WithMaxConcurrentScans(n)(m)
+		if cap(m.scanSem) != defaultMaxConcurrentScans {
+			t.Fatalf("expected the default limit for %d, got %d", n, cap(m.scanSem))
+		}
+	}
+}
+
+func TestHealth(t *testing.T) {
+	m := &Manager{
+		lastStateUpdate:   time.Now(),
+		lastReleaseUpdate: time.Now(),
+	}
+	if err := m.Health(); err != nil {
+		t.Fatal(err)
+	}
+
+	m.lastStateUpdate = time.Now().Add(-3 * statePollInterval)
+	if err := m.Health(); !errors.Is(err, ErrUnhealthy) {
+		t.Fatalf("expected stale state to be unhealthy, got %v", err)
+	}
+
+	m.lastStateUpdate = time.Now()
+	m.lastReleaseUpdate = time.Time{}
+	if err := m.Health(); !errors.Is(err, ErrUnhealthy) {
+		t.Fatalf("expected unknown release to be unhealthy, got %v", err)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	resp := Result{
+		RHP4: []RHP4Result{
+			{
+				NetAddress: chain.NetAddress{Protocol: siamux.Protocol, Address: "host.sia.tech:9984"},
+				Errors:     []string{"host has no max collateral"},
+				Warnings:   []string{"host is not accepting contracts"},
+			},
+			{
+				NetAddress: chain.NetAddress{Protocol: quic.Protocol, Address: "host.sia.tech:9984"},
+				Errors:     []string{"host has no max collateral", "failed to get settings"},
+				Warnings:   []string{"host is not accepting contracts"},
+			},
+			{
+				// duplicate issues for the same protocol are only included once
+				NetAddress: chain.NetAddress{Protocol: quic.Protocol, Address: "host2.sia.tech:9984"},
+				Errors:     []string{"failed to get settings"},
+			},
+		},
+	}
+	summarize(&resp, []string{"host is reachable over siamux but not quic"})
+
+	expectedErrors := []Issue{
+		{Severity: SeverityError, Protocol: siamux.Protocol, Message: "host has no max collateral"},
+		{Severity: SeverityError, Protocol: quic.Protocol, Message: "host has no max collateral"},
+		{Severity: SeverityError, Protocol: quic.Protocol, Message: "failed to get settings"},
+	}
+	expectedWarnings := []Issue{
+		{Severity: SeverityWarning, Protocol: siamux.Protocol, Message: "host is not accepting contracts"},
+		{Severity: SeverityWarning, Protocol: quic.Protocol, Message: "host is not accepting contracts"},
+		{Severity: SeverityWarning, Message: "host is reachable over siamux but not quic"},
+	}
+
+	if len(resp.Errors) != len(expectedErrors) {
+		t.Fatalf("expected errors %v, got %v", expectedErrors, resp.Errors)
+	}
+	for i := range expectedErrors {
+		if resp.Errors[i] != expectedErrors[i] {
+			t.Fatalf("expected error %d to be %v, got %v", i, expectedErrors[i], resp.Errors[i])
+		}
+	}
+	if len(resp.Warnings) != len(expectedWarnings) {
+		t.Fatalf("expected warnings %v, got %v", expectedWarnings, resp.Warnings)
+	}
+	for i := range expectedWarnings {
+		if resp.Warnings[i] != expectedWarnings[i] {
+			t.Fatalf("expected warning %d to be %v, got %v", i, expectedWarnings[i], resp.Warnings[i])
+		}
+	}
+}
+
+func TestRequestedProtocols(t *testing.T) {
+	tests := []struct {
+		name      string
+		protocols []chain.Protocol
+		expected  map[chain.Protocol]bool
+		err       bool
+	}{
+		{"all", nil, nil, false},
+		{"quic", []chain.Protocol{quic.Protocol}, map[chain.Protocol]bool{quic.Protocol: true}, false},
+		{"both", []chain.Protocol{siamux.Protocol, quic.Protocol}, map[chain.Protocol]bool{siamux.Protocol: true, quic.Protocol: true}, false},
+		{"non-canonical", []chain.Protocol{"SiaMux", "QUIC"}, map[chain.Protocol]bool{siamux.Protocol: true, quic.Protocol: true}, false},
+		{"unknown", []chain.Protocol{"rhp2"}, nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			requested, err := requestedProtocols(test.protocols)
+			if test.err {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if len(requested) != len(test.expected) || (requested == nil) != (test.expected == nil) {
+				t.Fatalf("expected %v, got %v", test.expected, requested)
+			}
+			for proto := range test.expected {
+				if !requested[proto] {
+					t.Fatalf("expected %q to be requested", proto)
+				}
+			}
+		})
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	m := &Manager{
+		tg:       threadgroup.New(),
+		log:      zap.NewNop(),
+		explorer: mockExplorer{},
+		scanSem:  make(chan struct{}, defaultMaxConcurrentScans),
+		cooldown: make(map[types.PublicKey]time.Time),
+	}
+	WithCooldown(time.Minute)(m)
+
+	hostKey := types.GeneratePrivateKey().PublicKey()
+	start := time.Now()
+	if result, err := m.TestHost(context.Background(), Host{PublicKey: hostKey}); err != nil {
+		t.Fatal(err)
+	} else if result.ScannedAt.Before(start) || result.ScannedAt.After(time.Now()) {
+		t.Fatalf("expected scan time between %s and now, got %s", start, result.ScannedAt)
+	} else if result.Elapsed <= 0 {
+		t.Fatalf("expected positive elapsed time, got %s", result.Elapsed)
+	}
+
+	var ce *CooldownError
+	if _, err := m.TestHost(context.Background(), Host{PublicKey: hostKey}); !errors.As(err, &ce) {
+		t.Fatalf("expected cooldown error, got %v", err)
+	} else if ce.Remaining <= 0 || ce.Remaining > time.Minute {
+		t.Fatalf("expected remaining cooldown within 1m, got %s", ce.Remaining)
+	}
+	if n := m.Cooldown(Host{PublicKey: hostKey}); n <= 0 || n > time.Minute {
+		t.Fatalf("expected remaining cooldown within 1m, got %s", n)
+	} else if n := m.Cooldown(Host{PublicKey: hostKey, DryRun: true}); n != 0 {
+		t.Fatalf("expected dry runs to ignore the cooldown, got %s", n)
+	}
+
+	// other hosts should not be affected
+	if _, err := m.TestHost(context.Background(), Host{PublicKey: types.GeneratePrivateKey().PublicKey()}); err != nil {
+		t.Fatal(err)
+	}
+
+	// a zero cooldown should allow the host to be tested again immediately
+	WithCooldown(0)(m)
+	other := types.GeneratePrivateKey().PublicKey()
+	for i := 0; i < 2; i++ {
+		if _, err := m.TestHost(context.Background(), Host{PublicKey: other}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	m := &Manager{
+		tg:       threadgroup.New(),
+		log:      zap.New(core),
+		explorer: mockExplorer{},
+		scanSem:  make(chan struct{}, defaultMaxConcurrentScans),
+		cooldown: make(map[types.PublicKey]time.Time),
+	}
+
+	ctx := WithRequestID(context.Background(), "abc123")
+	result, err := m.TestHost(ctx, Host{PublicKey: types.GeneratePrivateKey().PublicKey()})
+	if err != nil {
+		t.Fatal(err)
+	} else if result.RequestID != "abc123" {
+		t.Fatalf("expected request ID %q, got %q", "abc123", result.RequestID)
+	}
+
+	entries := logs.All()
+	if len(entries) == 0 {
+		t.Fatal("expected logs")
+	}
+	for _, entry := range entries {
+		if entry.ContextMap()["requestID"] != "abc123" {
+			t.Fatalf("expected log %q to include the request ID, got %v", entry.Message, entry.ContextMap())
+		}
+	}
+}
+
+func TestLatestReleaseStatus(t *testing.T) {
+	m := &Manager{prereleases: true}
+	if status := m.ReleaseStatus(); status.Version != "" || !status.LastUpdate.IsZero() {
+		t.Fatalf("expected no release before the first update, got %+v", status)
+	}
+
+	updated := time.Now()
+	if err := m.latestRelease.UnmarshalText([]byte("v2.1.0-rc.1")); err != nil {
+		t.Fatal(err)
+	}
+	m.lastReleaseUpdate = updated
+	if release := m.LatestRelease(); release.String() != "v2.1.0-rc.1" {
+		t.Fatalf("expected release %q, got %q", "v2.1.0-rc.1", release)
+	} else if status := m.ReleaseStatus(); status.Version != "v2.1.0-rc.1" {
+		t.Fatalf("expected version %q, got %q", "v2.1.0-rc.1", status.Version)
+	} else if !status.LastUpdate.Equal(updated) || !status.Prereleases {
+		t.Fatalf("unexpected status %+v", status)
+	}
+}
+
+func TestConsensusStatus(t *testing.T) {
+	n, _ := chain.Mainnet()
+	m := &Manager{}
+	if status := m.ConsensusStatus(); status.Network != "" || status.V2Allowed {
+		t.Fatalf("expected empty status without a network, got %+v", status)
+	}
+
+	tests := []struct {
+		height   uint64
+		allowed  bool
+		required bool
+	}{
+		{n.HardforkV2.AllowHeight - 1, false, false},
+		{n.HardforkV2.AllowHeight, true, false},
+		{n.HardforkV2.RequireHeight, true, true},
+	}
+	for _, tt := range tests {
+		updated := time.Now()
+		m.state = consensus.State{Network: n, Index: types.ChainIndex{Height: tt.height}}
+		m.lastStateUpdate = updated
+
+		status := m.ConsensusStatus()
+		if status.Network != "mainnet" {
+			t.Fatalf("expected network %q, got %q", "mainnet", status.Network)
+		} else if status.Tip.Height != tt.height || !status.LastUpdate.Equal(updated) {
+			t.Fatalf("unexpected tip %v updated at %v", status.Tip, status.LastUpdate)
+		} else if status.V2Allowed != tt.allowed || status.V2Required != tt.required {
+			t.Fatalf("height %d: expected allowed %v required %v, got %v %v", tt.height, tt.allowed, tt.required, status.V2Allowed, status.V2Required)
+		} else if status.V2AllowHeight != n.HardforkV2.AllowHeight || status.V2RequireHeight != n.HardforkV2.RequireHeight {
+			t.Fatalf("unexpected hardfork heights %d and %d", status.V2AllowHeight, status.V2RequireHeight)
+		}
+	}
+}
+
+func TestStateAccessorsConcurrent(t *testing.T) {
+	n, _ := chain.Mainnet()
+	m := &Manager{}
+
+	const updates = 100
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range updates {
+			m.setState(consensus.State{Network: n, Index: types.ChainIndex{Height: uint64(i + 1)}})
+			m.setLatestRelease(SemVer{version: [3]byte{2, 0, byte(i)}})
+		}
+	}()
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range updates {
+				tip := m.ConsensusTip()
+				if status := m.ConsensusStatus(); status.Tip.Height < tip.Height {
+					t.Errorf("tip went backwards from %d to %d", tip.Height, status.Tip.Height)
+				}
+				m.LatestRelease()
+				m.ReleaseStatus()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if tip := m.ConsensusTip(); tip.Height != updates {
+		t.Fatalf("expected height %d, got %d", updates, tip.Height)
+	} else if release := m.LatestRelease(); release.String() != "v2.0.99" {
+		t.Fatalf("expected version %q, got %q", "v2.0.99", release)
+	}
+}
+
+// countingExplorer counts the requests made to it.
+type countingExplorer struct {
+	calls atomic.Int64
+}
+
+func (ce *countingExplorer) ConsensusState() (consensus.State, error) {
+	ce.calls.Add(1)
+	return consensus.State{}, nil
+}
+
+func (ce *countingExplorer) Host(types.PublicKey) (explorer.Host, error) {
+	ce.calls.Add(1)
+	return explorer.Host{}, errors.New("host not found")
+}
+
+func TestDryRun(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	var accepted atomic.Int64
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			conn.Close()
+		}
+	}()
+
+	e := new(countingExplorer)
+	m := &Manager{
+		tg:       threadgroup.New(),
+		log:      zap.NewNop(),
+		explorer: e,
+		scanSem:  make(chan struct{}, defaultMaxConcurrentScans),
+		cooldown: make(map[types.PublicKey]time.Time),
+	}
+	WithCooldown(time.Hour)(m)
+
+	host := Host{
+		PublicKey: types.GeneratePrivateKey().PublicKey(),
+		RHP4NetAddresses: []chain.NetAddress{
+			{Protocol: siamux.Protocol, Address: l.Addr().String()},
+			{Protocol: quic.Protocol, Address: "host.invalid:9982"},
+			{Protocol: siamux.Protocol, Address: "host.invalid"},
+			{Protocol: "foo", Address: "host.invalid:9984"},
+		},
+		DryRun: true,
+	}
+	result, err := m.TestHost(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	} else if !result.DryRun {
+		t.Fatal("expected dry run result")
+	} else if len(result.RHP4) != 4 {
+		t.Fatalf("expected 4 results, got %d", len(result.RHP4))
+	}
+
+	for _, res := range result.RHP4 {
+		if res.Connected || res.Scanned || len(res.ResolvedAddresses) != 0 {
+			t.Fatalf("expected %q not to be tested, got %+v", res.NetAddress.Address, res)
+		}
+	}
+	if len(result.RHP4[0].Errors) != 0 || len(result.RHP4[1].Errors) != 0 {
+		t.Fatalf("expected valid addresses to have no errors, got %v and %v", result.RHP4[0].Errors, result.RHP4[1].Errors)
+	} else if !hasIssue(result.RHP4[1].Warnings, "port 9982 is the default RHP2 port") {
+		t.Fatalf("expected legacy port warning, got %v", result.RHP4[1].Warnings)
+	} else if !hasIssue(result.RHP4[2].Errors, "failed to parse net address") {
+		t.Fatalf("expected parse error, got %v", result.RHP4[2].Errors)
+	} else if !hasIssue(result.RHP4[3].Errors, "unknown protocol") {
+		t.Fatalf("expected protocol error, got %v", result.RHP4[3].Errors)
+	}
+
+	// nothing should have been contacted
+	if n := accepted.Load(); n != 0 {
+		t.Fatalf("expected no connections, got %d", n)
+	} else if n := e.calls.Load(); n != 0 {
+		t.Fatalf("expected no explorer requests, got %d", n)
+	} else if _, ok := m.cooldown[host.PublicKey]; ok {
+		t.Fatal("expected host not to be on cooldown")
+	}
+
+	// a missing public key is reported instead of failing the handshake
+	result, err = m.TestHost(context.Background(), Host{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var messages []string
+	for _, issue := range result.Errors {
+		messages = append(messages, issue.Message)
+	}
+	if !hasIssue(messages, "missing public key") || !hasIssue(messages, "no RHP4 addresses") {
+		t.Fatalf("expected missing key and address errors, got %v", messages)
+	}
+}
+
+func TestTestHostMaxAddresses(t *testing.T) {
+	m := &Manager{
+		tg:       threadgroup.New(),
+		log:      zap.NewNop(),
+		explorer: mockExplorer{},
+		scanSem:  make(chan struct{}, defaultMaxConcurrentScans),
+		cooldown: make(map[types.PublicKey]time.Time),
+		cfg:      scanConfig{retry: retryPolicy{Attempts: 1}, dialTimeout: time.Second},
+	}
+	WithMaxAddresses(defaultMaxAddresses)(m)
+
+	// grab a free port and close the listener so the dials are refused
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	host := Host{PublicKey: types.GeneratePrivateKey().PublicKey()}
+	for range 1000 {
+		host.RHP4NetAddresses = append(host.RHP4NetAddresses, chain.NetAddress{Protocol: siamux.Protocol, Address: addr})
+	}
+
+	for _, dryRun := range []bool{true, false} {
+		host.DryRun = dryRun
+		result, err := m.TestHost(context.Background(), host)
+		if err != nil {
+			t.Fatal(err)
+		} else if len(result.RHP4) != defaultMaxAddresses {
+			t.Fatalf("expected %d results, got %d", defaultMaxAddresses, len(result.RHP4))
+		} else if !slices.ContainsFunc(result.Warnings, func(issue Issue) bool {
+			return strings.Contains(issue.Message, fmt.Sprintf("only the first %d of the host's 1000 RHP4 addresses were tested", defaultMaxAddresses))
+		}) {
+			t.Fatalf("expected a warning about the skipped addresses, got %v", result.Warnings)
+		}
+	}
+
+	// hosts within the limit are not warned
+	host.RHP4NetAddresses = host.RHP4NetAddresses[:2]
+	host.DryRun = true
+	if result, err := m.TestHost(context.Background(), host); err != nil {
+		t.Fatal(err)
+	} else if len(result.RHP4) != 2 || len(result.Warnings) != 0 {
+		t.Fatalf("expected 2 results and no warnings, got %d and %v", len(result.RHP4), result.Warnings)
+	}
+}
+
+func TestDisabledProtocols(t *testing.T) {
+	// reserve a port that refuses connections
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := l.Addr().String()
+	l.Close()
+
+	m := &Manager{
+		tg:       threadgroup.New(),
+		log:      zap.NewNop(),
+		explorer: mockExplorer{},
+		scanSem:  make(chan struct{}, defaultMaxConcurrentScans),
+		cooldown: make(map[types.PublicKey]time.Time),
+		cfg:      scanConfig{resolvers: defaultResolvers()},
+	}
+	WithDisabledProtocols(quic.Protocol)(m)
+
+	host := Host{
+		PublicKey: types.GeneratePrivateKey().PublicKey(),
+		RHP4NetAddresses: []chain.NetAddress{
+			{Protocol: siamux.Protocol, Address: closedAddr},
+			{Protocol: quic.Protocol, Address: closedAddr},
+		},
+	}
+	result, err := m.TestHost(context.Background(), host)
+	if err != nil {
+		t.Fatal(err)
+	} else if len(result.RHP4) != 2 {
+		t.Fatalf("expected 2 results, got %d", len(result.RHP4))
+	}
+
+	if res := result.RHP4[0]; res.Skipped || len(res.Errors) == 0 {
+		t.Fatalf("expected siamux to be tested and fail, got %+v", res)
+	}
+	res := result.RHP4[1]
+	if !res.Skipped || res.Connected || len(res.Errors) != 0 {
+		t.Fatalf("expected quic to be skipped without errors, got %+v", res)
+	} else if !hasIssue(res.Notes, "quic testing is disabled on this server") {
+		t.Fatalf("expected disabled note, got %v", res.Notes)
+	}
+	for _, issue := range result.Errors {
+		if issue.Protocol == quic.Protocol {
+			t.Fatalf("expected no quic errors, got %v", issue)
+		}
+	}
+
+	// dry runs and settings requests also skip the protocol
+	host.DryRun = true
+	if result, err := m.TestHost(context.Background(), host); err != nil {
+		t.Fatal(err)
+	} else if !result.RHP4[1].Skipped {
+		t.Fatalf("expected quic to be skipped in a dry run, got %+v", result.RHP4[1])
+	}
+	host.RHP4NetAddresses = host.RHP4NetAddresses[1:]
+	if _, err := m.FetchSettings(context.Background(), host); err == nil || !strings.Contains(err.Error(), "no addresses") {
+		t.Fatalf("expected no addresses to fetch settings from, got %v", err)
+	}
+}
+
+// A hangingExplorer blocks every request until it is closed.
+type hangingExplorer chan struct{}
+
+func (he hangingExplorer) ConsensusState() (consensus.State, error) {
+	<-he
+	return consensus.State{}, errors.New("closed")
+}
+
+func (he hangingExplorer) Host(types.PublicKey) (explorer.Host, error) {
+	<-he
+	return explorer.Host{}, errors.New("closed")
+}
+
+// A hangingHostExplorer returns the consensus state but blocks host requests
+// until it is closed.
+type hangingHostExplorer struct {
+	fakeExplorer
+	hang chan struct{}
+}
+
+func (he hangingHostExplorer) Host(types.PublicKey) (explorer.Host, error) {
+	<-he.hang
+	return explorer.Host{}, errors.New("closed")
+}
+
+func TestTestHostAnnouncementContext(t *testing.T) {
+	he := hangingHostExplorer{hang: make(chan struct{})}
+	t.Cleanup(func() { close(he.hang) })
+	m := newTestManager(t, he, "v2.1.0")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	m.TestHost(ctx, Host{
+		PublicKey:        types.GeneratePrivateKey().PublicKey(),
+		RHP4NetAddresses: []chain.NetAddress{{Protocol: siamux.Protocol, Address: "127.0.0.1:1"}},
+	})
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Fatalf("expected the test to return when the context expired, took %s", elapsed)
+	}
+}
+
+func TestNewManagerStartupContext(t *testing.T) {
+	release := "v2.1.0"
+	prev := fetchLatestRelease
+	fetchLatestRelease = func(ctx context.Context, _ bool) (string, error) {
+		if release == "" {
+			// GitHub is unresponsive
+			<-ctx.Done()
+			return "", ctx.Err()
+		}
+		return release, nil
+	}
+	t.Cleanup(func() { fetchLatestRelease = prev })
+
+	he := make(hangingExplorer)
+	t.Cleanup(func() { close(he) })
+
+	newManager := func() error {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		m, err := NewManager(ctx, he, zap.NewNop())
+		if err == nil {
+			m.Close()
+		} else if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Fatalf("expected NewManager to return when the context expired, took %s", elapsed)
+		}
+		return err
+	}
+
+	// the explorer hangs, even though each attempt has its own timeout
+	if err := newManager(); !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "tip state") {
+		t.Fatalf("expected the consensus state request to time out, got %v", err)
+	}
+
+	// GitHub hangs before the explorer is queried
+	release = ""
+	if err := newManager(); !errors.Is(err, context.DeadlineExceeded) || !strings.Contains(err.Error(), "latest release") {
+		t.Fatalf("expected the release request to time out, got %v", err)
+	}
+}
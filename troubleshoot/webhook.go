@@ -0,0 +1,104 @@
+package troubleshoot
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go.sia.tech/troubleshootd/build"
+	"go.uber.org/zap"
+)
+
+const (
+	// webhookTimeout is the timeout for each webhook delivery attempt.
+	webhookTimeout = 5 * time.Second
+	// webhookAttempts is the number of times delivery is attempted.
+	webhookAttempts = 2
+	// webhookRetryDelay is the delay between delivery attempts.
+	webhookRetryDelay = time.Second
+)
+
+// A webhook posts results that contain issues to a URL.
+type webhook struct {
+	url string
+	// warnings enables notifications for results that only contain
+	// warnings
+	warnings bool
+	client   *http.Client
+}
+
+// triggered returns true if the result should be delivered.
+func (wh *webhook) triggered(r Result) bool {
+	return len(r.Errors) > 0 || (wh.warnings && len(r.Warnings) > 0)
+}
+
+// send posts the result to the webhook URL.
+func (wh *webhook) send(ctx context.Context, r Result) error {
+	buf, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode result: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, webhookTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.url, bytes.NewReader(buf))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", build.UserAgent())
+
+	resp, err := wh.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deliver sends the result, retrying once on failure. Delivery is
+// best-effort and failures are only logged.
+func (wh *webhook) deliver(ctx context.Context, r Result, log *zap.Logger) {
+	for attempt := 1; ; attempt++ {
+		err := wh.send(ctx, r)
+		if err == nil {
+			return
+		} else if attempt >= webhookAttempts {
+			log.Warn("failed to deliver webhook", zap.String("url", wh.url), zap.Int("attempts", attempt), zap.Error(err))
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(webhookRetryDelay):
+		}
+	}
+}
+
+// notify delivers the result to the webhook in the background if one is
+// configured and the result has issues.
+func (m *Manager) notify(r Result, log *zap.Logger) {
+	if m.webhook == nil || !m.webhook.triggered(r) {
+		return
+	}
+
+	// delivery is not canceled on shutdown so that results found just
+	// before exiting, such as by the scan subcommand, are still sent.
+	// Each attempt is bounded by webhookTimeout.
+	done, err := m.tg.Add()
+	if err != nil {
+		return
+	}
+	go func() {
+		defer done()
+		m.webhook.deliver(context.Background(), r, log)
+	}()
+}
@@ -0,0 +1,70 @@
+package troubleshoot
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"go.sia.tech/core/types"
+	"go.sia.tech/troubleshootd/build"
+	"go.uber.org/zap"
+)
+
+func TestWebhookTriggered(t *testing.T) {
+	errs := Result{Errors: []Issue{{Message: "failed to connect"}}}
+	warnings := Result{Warnings: []Issue{{Message: "outdated version"}}}
+
+	wh := &webhook{}
+	if !wh.triggered(errs) {
+		t.Fatal("expected errors to trigger the webhook")
+	} else if wh.triggered(warnings) {
+		t.Fatal("expected warnings to not trigger the webhook")
+	} else if wh.triggered(Result{}) {
+		t.Fatal("expected a healthy result to not trigger the webhook")
+	}
+
+	wh.warnings = true
+	if !wh.triggered(warnings) {
+		t.Fatal("expected warnings to trigger the webhook")
+	}
+}
+
+func TestWebhookDeliver(t *testing.T) {
+	var mu sync.Mutex
+	var attempts int
+	var delivered Result
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if ua := r.Header.Get("User-Agent"); ua != build.UserAgent() {
+			t.Errorf("expected User-Agent %q, got %q", build.UserAgent(), ua)
+		}
+		if attempts == 1 {
+			// fail the first attempt so that it is retried
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		} else if err := json.NewDecoder(r.Body).Decode(&delivered); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	result := Result{
+		PublicKey: types.GeneratePrivateKey().PublicKey(),
+		Errors:    []Issue{{Severity: SeverityError, Message: "failed to connect"}},
+	}
+	wh := &webhook{url: srv.URL, client: srv.Client()}
+	wh.deliver(context.Background(), result, zap.NewNop())
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", attempts)
+	} else if delivered.PublicKey != result.PublicKey || len(delivered.Errors) != 1 || delivered.Errors[0] != result.Errors[0] {
+		t.Fatalf("expected result to be delivered, got %+v", delivered)
+	}
+}